@@ -166,7 +166,7 @@ func HandleGetLook(c *gin.Context) {
 		return
 	}
 
-	err = gorse.Read(strconv.Itoa(int(user.ID)), strconv.Itoa(int(look.ID)))
+	err = gorse.Read(strconv.Itoa(int(user.ID)), look.Slug)
 	if err != nil {
 		logrus.Errorf("error submitting 'read' feedback to adviser: %v", err)
 	}
@@ -247,11 +247,11 @@ func HandleLikeLook(c *gin.Context) {
 		c.AbortWithStatus(http.StatusInternalServerError)
 	}
 
-	err = gorse.Like(strconv.Itoa(int(user.ID)), strconv.Itoa(int(look.ID)))
+	err = gorse.Like(strconv.Itoa(int(user.ID)), look.Slug)
 	if err != nil {
 		logrus.Errorf("error submitting 'like' feedback to adviser: %v", err)
 	}
-	err = gorse.Undislike(strconv.Itoa(int(user.ID)), strconv.Itoa(int(look.ID)))
+	err = gorse.Undislike(strconv.Itoa(int(user.ID)), look.Slug)
 	if err != nil {
 		logrus.Errorf("gorse error disliking look: %v", err)
 	}
@@ -281,7 +281,7 @@ func HandleUnlikeLook(c *gin.Context) {
 
 	database.DB().Model(user).Association("LikedLooks").Delete(&look)
 
-	err = gorse.Unlike(strconv.Itoa(int(user.ID)), strconv.Itoa(int(look.ID)))
+	err = gorse.Unlike(strconv.Itoa(int(user.ID)), look.Slug)
 	if err != nil {
 		logrus.Errorf("gorse error unliking look: %v", err)
 	}
@@ -316,11 +316,11 @@ func HandleDislikeLook(c *gin.Context) {
 		c.AbortWithStatus(http.StatusInternalServerError)
 	}
 
-	err = gorse.Unlike(strconv.Itoa(int(user.ID)), strconv.Itoa(int(look.ID)))
+	err = gorse.Unlike(strconv.Itoa(int(user.ID)), look.Slug)
 	if err != nil {
 		logrus.Errorf("gorse error unliking look: %v", err)
 	}
-	err = gorse.Dislike(strconv.Itoa(int(user.ID)), strconv.Itoa(int(look.ID)))
+	err = gorse.Dislike(strconv.Itoa(int(user.ID)), look.Slug)
 	if err != nil {
 		logrus.Errorf("gorse error disliking look: %v", err)
 	}
@@ -354,7 +354,7 @@ func HandleUndislikeLook(c *gin.Context) {
 		c.AbortWithStatus(http.StatusInternalServerError)
 	}
 
-	err = gorse.Undislike(strconv.Itoa(int(user.ID)), strconv.Itoa(int(look.ID)))
+	err = gorse.Undislike(strconv.Itoa(int(user.ID)), look.Slug)
 	if err != nil {
 		logrus.Errorf("gorse error undisliking look: %v", err)
 	}
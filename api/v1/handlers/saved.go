@@ -82,7 +82,7 @@ func HandleSavedAdd(c *gin.Context) {
 		logrus.Errorf("error adding look to saved: %v", err)
 	}
 
-	err = gorse.Star(strconv.Itoa(int(user.ID)), strconv.Itoa(int(look.ID)))
+	err = gorse.Star(strconv.Itoa(int(user.ID)), look.Slug)
 	if err != nil {
 		logrus.Errorf("gorse error starring look: %v", err)
 	}
@@ -112,7 +112,7 @@ func HandleSavedRemove(c *gin.Context) {
 		logrus.Errorf("error removing look from saved: %v", err)
 	}
 
-	err = gorse.Unstar(strconv.Itoa(int(user.ID)), strconv.Itoa(int(look.ID)))
+	err = gorse.Unstar(strconv.Itoa(int(user.ID)), look.Slug)
 	if err != nil {
 		logrus.Errorf("gorse error starring look: %v", err)
 	}
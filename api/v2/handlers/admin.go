@@ -0,0 +1,272 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/parasource/papaya-api/api/v2/requests"
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/parasource/papaya-api/pkg/events"
+	"github.com/parasource/papaya-api/pkg/experiments"
+	"github.com/parasource/papaya-api/pkg/search"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	DefaultMetricsDays = 14
+	MaxMetricsDays     = 90
+)
+
+func HandleAdminMetrics(c *gin.Context) {
+	days := DefaultMetricsDays
+	if d := c.Query("days"); d != "" {
+		var err error
+		days, err = strconv.Atoi(d)
+		if err != nil || days <= 0 || days > MaxMetricsDays {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+
+	metrics, err := events.GetMetrics(days)
+	if err != nil {
+		logrus.Errorf("error getting metrics: %v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(200, metrics)
+}
+
+// HandleAdminSearchReport shows top queries, queries
+// finding nothing and click-through rate of the search
+func HandleAdminSearchReport(c *gin.Context) {
+	days := DefaultMetricsDays
+	if d := c.Query("days"); d != "" {
+		var err error
+		days, err = strconv.Atoi(d)
+		if err != nil || days <= 0 || days > MaxMetricsDays {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+
+	report, err := search.GetReport(days)
+	if err != nil {
+		logrus.Errorf("error getting search report: %v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(200, report)
+}
+
+func HandleAdminExperimentResults(c *gin.Context) {
+	key := c.Param("experiment")
+
+	results, err := experiments.Results(key)
+	if err != nil {
+		logrus.Errorf("error getting experiment results: %v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"experiment": key,
+		"variants":   results,
+	})
+}
+
+func HandleAdminListSynonyms(c *gin.Context) {
+	var groups []*models.SearchSynonymGroup
+	err := database.DB().Preload("Terms").Order("id").Find(&groups).Error
+	if err != nil {
+		logrus.Errorf("error getting synonyms: %v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(200, groups)
+}
+
+func HandleAdminCreateSynonyms(c *gin.Context) {
+	var r requests.SynonymGroupRequest
+	err := c.ShouldBindJSON(&r)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	group := &models.SearchSynonymGroup{
+		Terms: synonymTerms(r.Terms),
+	}
+	err = database.DB().Create(group).Error
+	if err != nil {
+		logrus.Errorf("error creating synonyms: %v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	search.ReloadSynonyms()
+
+	c.JSON(http.StatusCreated, group)
+}
+
+func HandleAdminUpdateSynonyms(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("group"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var r requests.SynonymGroupRequest
+	err = c.ShouldBindJSON(&r)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var group models.SearchSynonymGroup
+	err = database.DB().Find(&group, id).Error
+	if err != nil {
+		logrus.Errorf("error getting synonyms: %v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if group.ID == 0 {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	// Terms are replaced as a whole
+	group.Terms = synonymTerms(r.Terms)
+	err = database.DB().Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("search_synonym_group_id = ?", group.ID).Delete(&models.SearchSynonym{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Save(&group).Error
+	})
+	if err != nil {
+		logrus.Errorf("error updating synonyms: %v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	search.ReloadSynonyms()
+
+	c.JSON(200, group)
+}
+
+func HandleAdminDeleteSynonyms(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("group"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	err = database.DB().Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("search_synonym_group_id = ?", id).Delete(&models.SearchSynonym{}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&models.SearchSynonymGroup{}, id).Error
+	})
+	if err != nil {
+		logrus.Errorf("error deleting synonyms: %v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	search.ReloadSynonyms()
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
+
+// synonymTerms normalizes terms, as the dictionary is
+// looked up by lowercase terms without extra spaces
+func synonymTerms(terms []string) []*models.SearchSynonym {
+	var result []*models.SearchSynonym
+	seen := make(map[string]bool, len(terms))
+	for _, term := range terms {
+		term = strings.Join(strings.Fields(strings.ToLower(term)), " ")
+		if term == "" || seen[term] {
+			continue
+		}
+		seen[term] = true
+		result = append(result, &models.SearchSynonym{Term: term})
+	}
+	return result
+}
+
+func HandleAdminListBlockedTerms(c *gin.Context) {
+	var terms []*models.SearchBlockedTerm
+	err := database.DB().Order("term").Find(&terms).Error
+	if err != nil {
+		logrus.Errorf("error getting blocked terms: %v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(200, terms)
+}
+
+// HandleAdminBlockTerm hides queries containing the term from
+// trending searches and autofill, blocking it twice is fine
+func HandleAdminBlockTerm(c *gin.Context) {
+	var r requests.BlockedTermRequest
+	err := c.ShouldBindJSON(&r)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	term := &models.SearchBlockedTerm{
+		Term: strings.ToLower(strings.TrimSpace(r.Term)),
+	}
+	if term.Term == "" {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	err = database.DB().Where("term = ?", term.Term).FirstOrCreate(term).Error
+	if err != nil {
+		logrus.Errorf("error blocking term: %v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusCreated, term)
+}
+
+func HandleAdminUnblockTerm(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("term"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	err = database.DB().Delete(&models.SearchBlockedTerm{}, id).Error
+	if err != nil {
+		logrus.Errorf("error unblocking term: %v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusNoContent, gin.H{})
+}
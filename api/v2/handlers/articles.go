@@ -20,6 +20,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/parasource/papaya-api/pkg/database"
 	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/parasource/papaya-api/pkg/events"
 	"github.com/rs/zerolog/log"
 	"github.com/sirupsen/logrus"
 	"math"
@@ -129,5 +130,9 @@ func HandleGetArticle(c *gin.Context) {
 		log.Error().Err(err).Msg("error updating number of views on article")
 	}
 
+	// Articles are open, so readers might be anonymous
+	user, _ := GetUser(c)
+	recordEvent(c, user, events.TypeRead, events.EntityArticle, article.ID, nil)
+
 	c.JSON(200, article)
 }
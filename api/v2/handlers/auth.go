@@ -25,21 +25,13 @@ import (
 	"github.com/parasource/papaya-api/api/v2/requests"
 	"github.com/parasource/papaya-api/pkg/database"
 	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/parasource/papaya-api/pkg/today"
 	"github.com/parasource/papaya-api/pkg/util"
 	"github.com/sirupsen/logrus"
 	"io/ioutil"
 	"net/http"
 )
 
-const getTodayLookSql = `
-select looks.id from looks
-	 join look_items li on looks.id = li.look_id
-	 right join users_wardrobe uw on li.wardrobe_item_id = uw.wardrobe_item_id
-	WHERE uw.user_id = ?
-	  AND looks.sex = ?
-	  AND looks.deleted_at IS NULL
-	GROUP BY looks.id ORDER BY random() DESC LIMIT 1`
-
 func HandleRegister(c *gin.Context) {
 	var r requests.RegisterRequest
 	err := c.BindJSON(&r)
@@ -74,7 +66,7 @@ func HandleRegister(c *gin.Context) {
 		return
 	}
 
-	err = associateTodayLook(user)
+	err = today.Get().Rotate(user)
 	if err != nil {
 		logrus.Errorf("error associating today's look: %v", err)
 		c.AbortWithStatus(500)
@@ -183,7 +175,7 @@ func HandleGoogleLoginOrRegister(c *gin.Context) {
 			user.Sex = "male"
 			database.CreateUser(user)
 
-			err = associateTodayLook(user)
+			err = today.Get().Rotate(user)
 			if err != nil {
 				logrus.Errorf("error adding today's look to new user: %v", err)
 			}
@@ -305,7 +297,7 @@ func HandleAppleLoginOrRegister(c *gin.Context) {
 			user.Sex = "male"
 			database.CreateUser(user)
 
-			err = associateTodayLook(user)
+			err = today.Get().Rotate(user)
 			if err != nil {
 				logrus.Errorf("error adding today's look to new user: %v", err)
 			}
@@ -360,41 +352,6 @@ func HandleAppleLoginOrRegister(c *gin.Context) {
 	}
 }
 
-func associateTodayLook(user *models.User) error {
-	var todayLookMale, todayLookFemale uint
-	err := database.DB().Debug().Raw(getTodayLookSql, user.ID, "male").Scan(&todayLookMale).Error
-	if err != nil {
-		return fmt.Errorf("error getting today male look for new user: %v", err)
-	}
-	if todayLookMale == 0 {
-		err = database.DB().Debug().Raw("select looks.id from looks where sex = ? order by random() limit 1", "male").Find(&todayLookMale).Error
-		if err != nil {
-			return fmt.Errorf("error getting fallback male look for new user: %v", err)
-		}
-	}
-	err = database.DB().Debug().Raw(getTodayLookSql, user.ID, "female").Scan(&todayLookFemale).Error
-	if err != nil {
-		return fmt.Errorf("error getting today female look for new user: %v", err)
-	}
-	if todayLookFemale == 0 {
-		err = database.DB().Debug().Raw("select looks.id from looks where sex = ? order by random() limit 1", "female").Find(&todayLookFemale).Error
-		if err != nil {
-			return fmt.Errorf("error getting fallback male look for new user: %v", err)
-		}
-	}
-
-	err = database.DB().Debug().Exec("INSERT INTO today_looks (user_id, look_id, sex) VALUES (?, ?, ?)", user.ID, todayLookMale, "male").Error
-	if err != nil {
-		return fmt.Errorf("error setting male today look: %v", err)
-	}
-	err = database.DB().Debug().Exec("INSERT INTO today_looks (user_id, look_id, sex) VALUES (?, ?, ?)", user.ID, todayLookFemale, "female").Error
-	if err != nil {
-		return fmt.Errorf("error setting female today look: %v", err)
-	}
-
-	return nil
-}
-
 func HandleRefresh(c *gin.Context) {
 	var req requests.RefreshTokenRequest
 	err := c.BindJSON(&req)
@@ -467,7 +424,18 @@ func HandleUser(c *gin.Context) {
 ///////////////////
 /// Helper methods
 
+// UserKey is the context key auth middleware keeps the user under
+const UserKey = "user"
+
+// GetUser returns the user set by auth middleware, or
+// the one the authorization token was issued for
 func GetUser(c *gin.Context) (*models.User, error) {
+	if v, ok := c.Get(UserKey); ok {
+		if user, ok := v.(*models.User); ok {
+			return user, nil
+		}
+	}
+
 	token, err := util.ExtractToken(c.GetHeader("Authorization"))
 	if err != nil {
 		return nil, err
@@ -0,0 +1,48 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/parasource/papaya-api/pkg/events"
+)
+
+// Headers clients send their platform and app version in
+const (
+	PlatformHeader   = "X-Platform"
+	AppVersionHeader = "X-App-Version"
+)
+
+// recordEvent records user interaction with an entity.
+// User may be nil for anonymous requests
+func recordEvent(c *gin.Context, user *models.User, typ string, entityType string, entityID uint, context map[string]interface{}) {
+	var userID uint
+	if user != nil {
+		userID = user.ID
+	}
+
+	events.Get().Record(events.Event{
+		UserID:     userID,
+		Type:       typ,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Context:    context,
+		Platform:   c.GetHeader(PlatformHeader),
+		AppVersion: c.GetHeader(AppVersionHeader),
+	})
+}
@@ -21,15 +21,24 @@ import (
 	"github.com/parasource/papaya-api/pkg/adviser"
 	database "github.com/parasource/papaya-api/pkg/database"
 	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/parasource/papaya-api/pkg/events"
 	"github.com/parasource/papaya-api/pkg/gorse"
+	"github.com/parasource/papaya-api/pkg/search"
+	"github.com/parasource/papaya-api/pkg/season"
+	"github.com/parasource/papaya-api/pkg/weather"
 	"github.com/rs/zerolog/log"
 	"github.com/sirupsen/logrus"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
 )
 
 var (
 	FeedPagination = 20
+
+	CategoriesCacheTTL = 10 * time.Minute
+	CarouselCacheTTL   = 5 * time.Minute
 )
 
 func HandleFeed(c *gin.Context) {
@@ -43,28 +52,46 @@ func HandleFeed(c *gin.Context) {
 
 	params := c.Request.URL.Query()
 
-	var page int64
-	if _, ok := params["page"]; !ok {
-		page = 0
-	} else {
-		page, err = strconv.ParseInt(params["page"][0], 10, 64)
+	// Cursor is preferred, page is kept for older clients
+	cursor := adviser.NewCursor()
+	if _, ok := params["cursor"]; ok && params["cursor"][0] != "" {
+		cursor, err = adviser.DecodeCursor(params["cursor"][0])
 		if err != nil {
 			c.AbortWithStatus(400)
 			return
 		}
+	} else if _, ok := params["page"]; ok {
+		page, err := strconv.ParseInt(params["page"][0], 10, 64)
+		if err != nil || page < 0 {
+			c.AbortWithStatus(400)
+			return
+		}
+		// Positions of sources are estimated from the page
+		cursor.Page = int(page)
+		cursor.Offsets = nil
 	}
+	page := cursor.Page
+
+	// Weather is optional, feed is only filtered by season without it
+	conditions := currentWeather(c, params)
 
 	// Feed looks
-	looks, err := adviser.Get().Feed(user, int(page))
+	feed, err := adviser.Get().FeedPage(user, cursor, conditions)
 	if err != nil {
 		logrus.Errorf("error getting feed: %v", err)
 		c.AbortWithStatus(500)
 		return
 	}
 
+	cache := adviser.Get().Cache()
+
 	// Categories
 	var categories []models.Category
-	err = database.DB().Find(&categories).Error
+	err = cache.Remember(c, "categories", CategoriesCacheTTL, &categories, func() (interface{}, error) {
+		var categories []models.Category
+		err := database.DB().Find(&categories).Error
+		return categories, err
+	})
 	if err != nil {
 		logrus.Errorf("error getting categories: %v", err)
 		c.AbortWithStatus(500)
@@ -73,7 +100,11 @@ func HandleFeed(c *gin.Context) {
 
 	// Topics
 	var topics []models.Topic
-	err = database.DB().Order("RANDOM()").Limit(10).Find(&topics).Error
+	err = cache.Remember(c, "carousel:topics", CarouselCacheTTL, &topics, func() (interface{}, error) {
+		var topics []models.Topic
+		err := database.DB().Order("RANDOM()").Limit(10).Find(&topics).Error
+		return topics, err
+	})
 	if err != nil {
 		logrus.Errorf("error getting popular topics: %v", err)
 		c.AbortWithStatus(500)
@@ -82,7 +113,11 @@ func HandleFeed(c *gin.Context) {
 
 	// Articles
 	var articles []models.Article
-	err = database.DB().Where("sex = ?", user.Sex).Order("RANDOM()").Limit(3).Find(&articles).Error
+	err = cache.Remember(c, "carousel:articles:"+user.Sex, CarouselCacheTTL, &articles, func() (interface{}, error) {
+		var articles []models.Article
+		err := database.DB().Where("sex = ?", user.Sex).Order("RANDOM()").Limit(3).Find(&articles).Error
+		return articles, err
+	})
 	if err != nil {
 		logrus.Errorf("error getting articles: %v", err)
 		c.AbortWithStatus(500)
@@ -98,8 +133,13 @@ func HandleFeed(c *gin.Context) {
 
 	result := gin.H{
 		"page":               page,
+		"cursor":             feed.Next.Encode(),
+		"degraded":           feed.Degraded,
+		"variant":            feed.Variant,
+		"season":             season.ForUser(user),
+		"weather":            conditions,
 		"topics":             topics,
-		"looks":              looks,
+		"looks":              feed.Looks,
 		"categories":         categories,
 		"articles":           articles,
 		"alerts":             alerts,
@@ -142,12 +182,21 @@ func HandleFeedByCategory(c *gin.Context) {
 		return
 	}
 
+	// Looks for other seasons are hidden, and looks made
+	// exactly for the current one go first
+	current := season.ForUser(user)
+	seasonFilter, seasonArgs := season.Filter(current)
+	madeFor, madeForArgs := season.MadeFor(current)
+
+	args := append([]interface{}{category.ID, user.Sex}, seasonArgs...)
+	args = append(append(args, madeForArgs...), FeedPagination, offset)
+
 	var looks []*models.Look
-	err = database.DB().Debug().
-		Raw("SELECT * FROM looks JOIN look_categories lc on looks.id = lc.look_id WHERE looks.deleted_at IS NULL AND lc.category_id = ? AND looks.sex = ?", category.ID, user.Sex).
-		Order("id DESC").
-		Offset(offset).Preload("Items.Urls.Brand").
-		Limit(FeedPagination).Find(&looks).Error
+	err = database.DB().
+		Raw("SELECT looks.* FROM looks JOIN look_categories lc on looks.id = lc.look_id WHERE looks.deleted_at IS NULL AND lc.category_id = ? AND looks.sex = ? AND "+seasonFilter+
+			" ORDER BY ("+madeFor+") DESC NULLS LAST, looks.id DESC LIMIT ? OFFSET ?", args...).
+		Preload("Items.Urls.Brand").
+		Find(&looks).Error
 	if err != nil {
 		logrus.Errorf("error getting feed looks by style: %v", err)
 	}
@@ -177,7 +226,18 @@ func HandleGetLook(c *gin.Context) {
 		return
 	}
 
-	err = gorse.Read(strconv.Itoa(int(user.ID)), strconv.Itoa(int(look.ID)))
+	recordEvent(c, user, events.TypeView, events.EntityLook, look.ID, nil)
+
+	// Look opened from search results
+	if searchID, err := strconv.Atoi(c.Query("search_id")); err == nil && searchID > 0 {
+		position, _ := strconv.Atoi(c.Query("position"))
+		err = search.RecordClick(user.ID, uint(searchID), events.EntityLook, look.ID, position)
+		if err != nil {
+			logrus.Errorf("error recording search click: %v", err)
+		}
+	}
+
+	err = gorse.Read(strconv.Itoa(int(user.ID)), look.Slug)
 	if err != nil {
 		logrus.Errorf("error submitting 'read' feedback to adviser: %v", err)
 	}
@@ -191,13 +251,15 @@ func HandleGetLook(c *gin.Context) {
 	var isSaved bool
 	database.DB().Raw("SELECT COUNT(1) FROM saved_looks WHERE user_id = ? AND look_id = ?", user.ID, look.ID).Scan(&isSaved)
 
-	var similar []models.Look
-	err = database.DB().Where("sex = ?", user.Sex).Limit(8).Order("random()").Find(&similar).Error
+	similar, err := adviser.Get().Similar(user, &look, 8)
 	if err != nil {
 		log.Error().Err(err).Msg("error finding similar looks")
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
+	if similar == nil {
+		similar = []*models.Look{}
+	}
 
 	c.JSON(200, gin.H{
 		"look":       look,
@@ -256,11 +318,14 @@ func HandleLikeLook(c *gin.Context) {
 		c.AbortWithStatus(http.StatusInternalServerError)
 	}
 
-	err = gorse.Like(strconv.Itoa(int(user.ID)), strconv.Itoa(int(look.ID)))
+	adviser.Get().InvalidateFeed(user.ID)
+	recordEvent(c, user, events.TypeLike, events.EntityLook, look.ID, nil)
+
+	err = gorse.Like(strconv.Itoa(int(user.ID)), look.Slug)
 	if err != nil {
 		logrus.Errorf("error submitting 'like' feedback to adviser: %v", err)
 	}
-	err = gorse.Undislike(strconv.Itoa(int(user.ID)), strconv.Itoa(int(look.ID)))
+	err = gorse.Undislike(strconv.Itoa(int(user.ID)), look.Slug)
 	if err != nil {
 		logrus.Errorf("gorse error disliking look: %v", err)
 	}
@@ -290,7 +355,10 @@ func HandleUnlikeLook(c *gin.Context) {
 
 	database.DB().Model(user).Association("LikedLooks").Delete(&look)
 
-	err = gorse.Unlike(strconv.Itoa(int(user.ID)), strconv.Itoa(int(look.ID)))
+	adviser.Get().InvalidateFeed(user.ID)
+	recordEvent(c, user, events.TypeUnlike, events.EntityLook, look.ID, nil)
+
+	err = gorse.Unlike(strconv.Itoa(int(user.ID)), look.Slug)
 	if err != nil {
 		logrus.Errorf("gorse error unliking look: %v", err)
 	}
@@ -325,11 +393,14 @@ func HandleDislikeLook(c *gin.Context) {
 		c.AbortWithStatus(http.StatusInternalServerError)
 	}
 
-	err = gorse.Unlike(strconv.Itoa(int(user.ID)), strconv.Itoa(int(look.ID)))
+	adviser.Get().InvalidateFeed(user.ID)
+	recordEvent(c, user, events.TypeDislike, events.EntityLook, look.ID, nil)
+
+	err = gorse.Unlike(strconv.Itoa(int(user.ID)), look.Slug)
 	if err != nil {
 		logrus.Errorf("gorse error unliking look: %v", err)
 	}
-	err = gorse.Dislike(strconv.Itoa(int(user.ID)), strconv.Itoa(int(look.ID)))
+	err = gorse.Dislike(strconv.Itoa(int(user.ID)), look.Slug)
 	if err != nil {
 		logrus.Errorf("gorse error disliking look: %v", err)
 	}
@@ -363,7 +434,10 @@ func HandleUndislikeLook(c *gin.Context) {
 		c.AbortWithStatus(http.StatusInternalServerError)
 	}
 
-	err = gorse.Undislike(strconv.Itoa(int(user.ID)), strconv.Itoa(int(look.ID)))
+	adviser.Get().InvalidateFeed(user.ID)
+	recordEvent(c, user, events.TypeUndislike, events.EntityLook, look.ID, nil)
+
+	err = gorse.Undislike(strconv.Itoa(int(user.ID)), look.Slug)
 	if err != nil {
 		logrus.Errorf("gorse error undisliking look: %v", err)
 	}
@@ -391,3 +465,28 @@ func GetLikedLooks(c *gin.Context) {
 
 	c.JSON(200, looks)
 }
+
+// currentWeather returns weather at lat and lon from query
+// params, or nil if they are missing or weather is disabled
+func currentWeather(c *gin.Context, params url.Values) *weather.Conditions {
+	provider := weather.Get()
+	if provider == nil || params.Get("lat") == "" || params.Get("lon") == "" {
+		return nil
+	}
+
+	lat, err := strconv.ParseFloat(params.Get("lat"), 64)
+	if err != nil {
+		return nil
+	}
+	lon, err := strconv.ParseFloat(params.Get("lon"), 64)
+	if err != nil {
+		return nil
+	}
+
+	conditions, err := provider.Current(c, lat, lon)
+	if err != nil {
+		logrus.Warnf("error getting weather: %v", err)
+		return nil
+	}
+	return conditions
+}
@@ -0,0 +1,76 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/parasource/papaya-api/api/v2/requests"
+	"github.com/parasource/papaya-api/pkg/adviser"
+	"github.com/parasource/papaya-api/pkg/events"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+// HandleImpressions accepts batches of looks, that were
+// shown to user, so that feed doesn't repeat them
+func HandleImpressions(c *gin.Context) {
+	user, err := GetUser(c)
+	if err != nil {
+		logrus.Errorf("error getting user: %v", err)
+		c.AbortWithStatus(403)
+		return
+	}
+
+	var r requests.ImpressionsRequest
+	err = c.ShouldBindJSON(&r)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	impressions := make([]adviser.Impression, 0, len(r.Impressions))
+	for _, imp := range r.Impressions {
+		surface := imp.Surface
+		if surface == "" {
+			surface = "feed"
+		}
+		impressions = append(impressions, adviser.Impression{
+			LookID:   imp.LookID,
+			Position: imp.Position,
+			DwellMs:  imp.DwellMs,
+			Surface:  surface,
+		})
+	}
+
+	err = adviser.Get().RecordImpressions(user, impressions)
+	if err != nil {
+		logrus.Errorf("error recording impressions: %v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	for _, imp := range impressions {
+		recordEvent(c, user, events.TypeImpression, events.EntityLook, imp.LookID, map[string]interface{}{
+			"position": imp.Position,
+			"dwell_ms": imp.DwellMs,
+			"surface":  imp.Surface,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/parasource/papaya-api/pkg/events"
+	"github.com/parasource/papaya-api/pkg/outfits"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+)
+
+func HandleGenerateOutfits(c *gin.Context) {
+	user, err := GetUser(c)
+	if err != nil {
+		logrus.Errorf("error getting user: %v", err)
+		c.AbortWithStatus(403)
+		return
+	}
+
+	opts := outfits.Options{}
+	if limit := c.Query("limit"); limit != "" {
+		opts.Limit, err = strconv.Atoi(limit)
+		if err != nil || opts.Limit < 0 {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+	if outerwear := c.Query("outerwear"); outerwear != "" {
+		opts.Outerwear, err = strconv.ParseBool(outerwear)
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := outfits.Generate(user, opts)
+	if err != nil {
+		logrus.Errorf("error generating outfits: %v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if result == nil {
+		result = []*outfits.Outfit{}
+	}
+	recordEvent(c, user, events.TypeOutfits, "", 0, map[string]interface{}{
+		"outfits":   len(result),
+		"outerwear": opts.Outerwear,
+	})
+
+	c.JSON(200, gin.H{
+		"outfits": result,
+	})
+}
+
+func HandleCompleteLook(c *gin.Context) {
+	slug := c.Param("look")
+
+	var look models.Look
+	database.DB().Preload("Items.Urls.Brand").Preload("Items.WardrobeCategory").First(&look, "slug = ?", slug)
+
+	if look.ID == 0 {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	user, err := GetUser(c)
+	if err != nil {
+		logrus.Errorf("error getting user: %v", err)
+		c.AbortWithStatus(403)
+		return
+	}
+
+	completion, err := outfits.CompleteLook(user, &look)
+	if err != nil {
+		logrus.Errorf("error completing look: %v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	recordEvent(c, user, events.TypeCompleteLook, events.EntityLook, look.ID, map[string]interface{}{
+		"owned":   len(completion.Owned),
+		"missing": len(completion.Missing),
+	})
+
+	c.JSON(200, gin.H{
+		"look":    look,
+		"owned":   completion.Owned,
+		"missing": completion.Missing,
+	})
+}
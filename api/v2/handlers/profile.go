@@ -19,10 +19,16 @@ package handlers
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/parasource/papaya-api/api/v2/requests"
+	"github.com/parasource/papaya-api/pkg/adviser"
 	database "github.com/parasource/papaya-api/pkg/database"
 	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/parasource/papaya-api/pkg/events"
+	"github.com/parasource/papaya-api/pkg/insights"
+	"github.com/parasource/papaya-api/pkg/mood"
+	"github.com/parasource/papaya-api/pkg/season"
 	"github.com/parasource/papaya-api/pkg/util"
 	"github.com/sirupsen/logrus"
+	"time"
 )
 
 func HandleProfileSetWardrobe(c *gin.Context) {
@@ -50,6 +56,11 @@ func HandleProfileSetWardrobe(c *gin.Context) {
 		return
 	}
 
+	adviser.Get().InvalidateFeed(user.ID)
+	recordEvent(c, user, events.TypeSetWardrobe, "", 0, map[string]interface{}{
+		"items": len(items),
+	})
+
 	c.JSON(200, gin.H{
 		"success": true,
 	})
@@ -70,8 +81,23 @@ func HandleProfileSetMood(c *gin.Context) {
 		return
 	}
 
-	user.Mood = r.Mood
-	database.DB().Save(user)
+	// Older app versions send free-form moods,
+	// those just reset user's mood
+	user.Mood = ""
+	if m := mood.Get(r.Mood); m != nil {
+		user.Mood = m.Slug
+	}
+	err = database.DB().Save(user).Error
+	if err != nil {
+		logrus.Errorf("error saving user mood: %v", err)
+		c.AbortWithStatus(500)
+		return
+	}
+
+	adviser.Get().InvalidateFeed(user.ID)
+	recordEvent(c, user, events.TypeSetMood, "", 0, map[string]interface{}{
+		"mood": user.Mood,
+	})
 
 	c.JSON(200, gin.H{
 		"success": true,
@@ -116,6 +142,34 @@ func HandleProfileUpdateSettings(c *gin.Context) {
 		return
 	}
 
+	if r.Timezone != "" {
+		// Local day is worked out both in go and postgres,
+		// so the zone has to be known to both of them
+		if _, err := time.LoadLocation(r.Timezone); err != nil || r.Timezone == "Local" {
+			c.AbortWithStatus(400)
+			return
+		}
+		known, err := database.IsTimezone(r.Timezone)
+		if err != nil {
+			logrus.Errorf("error checking time zone: %v", err)
+			c.AbortWithStatus(500)
+			return
+		}
+		if !known {
+			c.AbortWithStatus(400)
+			return
+		}
+		user.Timezone = r.Timezone
+	}
+
+	if r.Hemisphere != "" {
+		if r.Hemisphere != season.HemisphereNorth && r.Hemisphere != season.HemisphereSouth {
+			c.AbortWithStatus(400)
+			return
+		}
+		user.Hemisphere = r.Hemisphere
+	}
+
 	user.Sex = r.Sex
 	if r.Name != "" {
 		user.Name = r.Name
@@ -177,3 +231,25 @@ func HandleProfileGetWardrobe(c *gin.Context) {
 
 	c.JSON(200, items)
 }
+
+func HandleProfileWardrobeInsights(c *gin.Context) {
+	user, err := GetUser(c)
+	if err != nil {
+		logrus.Errorf("error getting user: %v", err)
+		c.AbortWithStatus(403)
+		return
+	}
+
+	report, err := insights.Get().ForUser(user)
+	if err != nil {
+		logrus.Errorf("error getting wardrobe insights: %v", err)
+		c.AbortWithStatus(500)
+		return
+	}
+
+	c.JSON(200, report)
+}
+
+func HandleGetMoods(c *gin.Context) {
+	c.JSON(200, mood.All())
+}
@@ -18,8 +18,10 @@ package handlers
 
 import (
 	"github.com/gin-gonic/gin"
+	"github.com/parasource/papaya-api/pkg/adviser"
 	"github.com/parasource/papaya-api/pkg/database"
 	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/parasource/papaya-api/pkg/events"
 	"github.com/parasource/papaya-api/pkg/gorse"
 	"github.com/sirupsen/logrus"
 	"strconv"
@@ -82,7 +84,10 @@ func HandleSavedAdd(c *gin.Context) {
 		logrus.Errorf("error adding look to saved: %v", err)
 	}
 
-	err = gorse.Star(strconv.Itoa(int(user.ID)), strconv.Itoa(int(look.ID)))
+	adviser.Get().InvalidateFeed(user.ID)
+	recordEvent(c, user, events.TypeSave, events.EntityLook, look.ID, nil)
+
+	err = gorse.Star(strconv.Itoa(int(user.ID)), look.Slug)
 	if err != nil {
 		logrus.Errorf("gorse error starring look: %v", err)
 	}
@@ -112,7 +117,10 @@ func HandleSavedRemove(c *gin.Context) {
 		logrus.Errorf("error removing look from saved: %v", err)
 	}
 
-	err = gorse.Unstar(strconv.Itoa(int(user.ID)), strconv.Itoa(int(look.ID)))
+	adviser.Get().InvalidateFeed(user.ID)
+	recordEvent(c, user, events.TypeUnsave, events.EntityLook, look.ID, nil)
+
+	err = gorse.Unstar(strconv.Itoa(int(user.ID)), look.Slug)
 	if err != nil {
 		logrus.Errorf("gorse error starring look: %v", err)
 	}
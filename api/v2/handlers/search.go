@@ -17,63 +17,36 @@
 package handlers
 
 import (
-	"fmt"
+	"context"
 	"github.com/gin-gonic/gin"
+	"github.com/parasource/papaya-api/api/v2/requests"
+	"github.com/parasource/papaya-api/pkg/adviser"
 	"github.com/parasource/papaya-api/pkg/database"
 	"github.com/parasource/papaya-api/pkg/database/models"
-	"github.com/parasource/papaya-api/pkg/gorse"
+	"github.com/parasource/papaya-api/pkg/embeddings"
+	"github.com/parasource/papaya-api/pkg/events"
+	"github.com/parasource/papaya-api/pkg/experiments"
+	"github.com/parasource/papaya-api/pkg/mood"
+	"github.com/parasource/papaya-api/pkg/search"
 	"github.com/rs/zerolog/log"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/text/cases"
-	"golang.org/x/text/language"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
-	searchSql = `SELECT looks.*,
-        ts_rank(looks.tsv, plainto_tsquery('russian', ?)) as rank
-FROM looks
-		LEFT JOIN look_items li on looks.id = li.look_id JOIN wardrobe_items wi on wi.id = li.wardrobe_item_id
-    JOIN (
-        VALUES %[1]v
-    ) AS x (id, ordering) ON wi.id = x.id
-	WHERE (looks.tsv @@ plainto_tsquery('russian', ?)
-   	OR wi.id IN (?))
-	AND looks.sex = ?
-	ORDER BY x.ordering DESC, rank desc
-	OFFSET 0 LIMIT 10
-`
-
-	searchSqlNoWardrobeFound = `SELECT looks.*,
-        ts_rank(looks.tsv, plainto_tsquery('russian', ?)) as rank
-FROM looks
-		LEFT JOIN look_items li on looks.id = li.look_id JOIN wardrobe_items wi on wi.id = li.wardrobe_item_id
-		WHERE looks.tsv @@ plainto_tsquery('russian', ?)
-		AND looks.sex = ?
-		GROUP BY looks.id
-		ORDER BY rank desc
-		OFFSET ? LIMIT ?
-`
-
-	searchSqlWardrobe = `SELECT wardrobe_items.id, ts_rank(wardrobe_items.tsv, plainto_tsquery('pg_catalog.russian', ?)) AS rank,
-       count(li.id) AS items_count
-    FROM wardrobe_items JOIN look_items li on wardrobe_items.id = li.wardrobe_item_id
-    WHERE wardrobe_items.tsv @@ plainto_tsquery('pg_catalog.russian', ?) and sex = ?
-    GROUP BY wardrobe_items.id, rank
-	ORDER BY rank, items_count DESC LIMIT 5;`
+	searchPageSize = 20
+
+	// hybridCandidates is how many looks are taken from text and
+	// semantic search each to be fused, pages end after them. Total
+	// of hybrid search is never more than twice of it, the response
+	// tells when text search found more looks than were fused
+	hybridCandidates = search.MaxLooksLimit
+	semanticTimeout  = 2 * time.Second
 )
 
-type SearchSuggestion struct {
-	Query string `json:"query"`
-}
-
-type SearchDBWardrobe struct {
-	ID   int     `json:"id"`
-	Rank float32 `json:"rank"`
-}
-
 func HandleSearch(c *gin.Context) {
 	params := c.Request.URL.Query()
 
@@ -94,91 +67,314 @@ func HandleSearch(c *gin.Context) {
 		return
 	}
 
-	sr := models.SearchRecord{
-		Query:   strings.ToLower(searchQuery),
-		UserID:  user.ID,
-		Visible: true,
+	// Cursor is preferred, page is kept for older clients
+	cursor := &search.Cursor{}
+	if _, ok := params["cursor"]; ok && params["cursor"][0] != "" {
+		cursor, err = search.DecodeCursor(params["cursor"][0])
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	} else if _, ok := params["page"]; ok {
+		page, err := strconv.ParseInt(params["page"][0], 10, 64)
+		if err != nil || page < 0 {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		cursor.Offset = int(page) * searchPageSize
 	}
-	err = database.DB().Create(&sr).Error
-	if err != nil {
-		logrus.Errorf("error recording user search: %v", err)
+
+	// Search ranking experiment, "wardrobe" puts looks with
+	// matching wardrobe items first, "text" ranks by text only
+	variant := experiments.Get().Assign(user, experiments.Search)
+
+	// Looks close to the query in meaning are fused with the ones
+	// matching its words, so all candidates are taken at once.
+	// Fusion ranks them itself, so they are ranked by text only
+	offset, limit := cursor.Offset, searchPageSize
+	order := variant.Param("ranking", search.OrderWardrobe)
+	hybrid := embeddings.Get() != nil && variant.Param("semantic", "on") == "on"
+	if hybrid {
+		offset, limit, order = 0, hybridCandidates, search.OrderText
 	}
 
-	var page int64
-	if _, ok := params["page"]; !ok {
-		page = 0
-	} else {
-		page, _ = strconv.ParseInt(params["page"][0], 10, 64)
+	// Query corrected on the first page is
+	// searched on the following ones as well
+	didYouMean := cursor.Query
+	effectiveQuery := searchQuery
+	if didYouMean != "" {
+		effectiveQuery = didYouMean
 	}
-	offset := int(page * 20)
 
-	// First we need to query wardrobe matches,
-	// as it is our main goal
-	var wardrobeSearchResult []SearchDBWardrobe
-	err = database.DB().Debug().Raw(searchSqlWardrobe, searchQuery, searchQuery, user.Sex).Scan(&wardrobeSearchResult).Error
+	// Mood passed in request filters results, while
+	// user's own mood only lifts matching looks up
+	filter := mood.Get(c.Query("mood"))
+	query := &search.LooksQuery{
+		Text:   effectiveQuery,
+		Sex:    user.Sex,
+		Mood:   filter,
+		Order:  order,
+		Offset: offset,
+		Limit:  limit,
+	}
+
+	started := time.Now()
+	results, err := search.Get().Looks(query)
 	if err != nil {
-		log.Error().Err(err).Msg("error querying wardrobe")
+		logrus.Errorf("error searching: %v", err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
-	var wardrobeIds []int
-	for _, item := range wardrobeSearchResult {
-		wardrobeIds = append(wardrobeIds, item.ID)
-	}
 
-	var wardrobeItems []models.WardrobeItem
-	if len(wardrobeIds) > 0 {
-		err = database.DB().Where("id", wardrobeIds).Preload("WardrobeCategory").Preload("Urls.Brand").Find(&wardrobeItems).Error
+	// Nothing found, query might be misspelled
+	if results.Total == 0 && len(results.Items) == 0 && cursor.Offset == 0 && didYouMean == "" {
+		didYouMean, err = search.Get().Suggest(searchQuery)
 		if err != nil {
-			log.Error().Err(err).Msg("error searching wardrobe items")
+			logrus.Errorf("error correcting search query: %v", err)
+		}
+		if didYouMean != "" {
+			query.Text = didYouMean
+			results, err = search.Get().Looks(query)
+			if err != nil {
+				logrus.Errorf("error searching corrected query: %v", err)
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+			cursor.Query = didYouMean
+			effectiveQuery = didYouMean
+		}
+	}
+	looks, wardrobeItems, total := results.Looks, results.Items, results.Total
+	totalCapped := hybrid && results.Total > hybridCandidates
+	if hybrid {
+		looks, total, err = fuseSemantic(c.Request.Context(), user, effectiveQuery, looks, cursor, filter)
+		if err != nil {
+			logrus.Errorf("error fusing semantic search: %v", err)
 			c.AbortWithStatus(http.StatusInternalServerError)
 			return
 		}
-	} else {
-		log.Warn().Str("query", searchQuery).Msg("did not found any wardrobe items")
+	}
+	next := cursor.Next(len(looks), total)
+
+	// Only the first page is a new search, the
+	// rest are just user scrolling through results
+	var searchID uint
+	if cursor.Offset == 0 {
+		searchID = recordSearch(c, user, searchQuery, total+int64(len(wardrobeItems)), time.Since(started))
+	}
+
+	if m := mood.Get(user.Mood); m != nil && filter == nil {
+		looks, err = m.Boost(looks)
+		if err != nil {
+			log.Error().Err(err).Msg("error boosting search results by mood")
+		}
+	}
+
+	var variantName string
+	if variant != nil {
+		variantName = variant.Name
+	}
+
+	if looks == nil {
+		looks = []*models.Look{}
+	}
+
+	c.JSON(200, gin.H{
+		"looks":          looks,
+		"wardrobe_items": wardrobeItems,
+		"variant":        variantName,
+		"total":          total,
+		"total_capped":   totalCapped,
+		"next_cursor":    next,
+		"did_you_mean":   didYouMean,
+		"search_id":      searchID,
+	})
+}
+
+// fuseSemantic fuses looks found by text with the ones close
+// to the query in meaning and matching the mood, if it's given,
+// and returns a page of them with the number of fused looks,
+// which is what pages end after. If the query can't be
+// embedded, text results are paged as they are
+func fuseSemantic(ctx context.Context, user *models.User, query string, textLooks []*models.Look, cursor *search.Cursor, m *mood.Mood) ([]*models.Look, int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, semanticTimeout)
+	defer cancel()
+	matches, err := embeddings.Get().SimilarText(ctx, query, user.Sex, hybridCandidates)
+	if err != nil {
+		logrus.Errorf("error searching looks by meaning: %v", err)
+	}
+
+	byID := make(map[uint]*models.Look, len(textLooks))
+	textIDs := make([]uint, len(textLooks))
+	for i, l := range textLooks {
+		byID[l.ID] = l
+		textIDs[i] = l.ID
+	}
+	semanticIDs := make([]uint, 0, len(matches))
+	for _, match := range matches {
+		semanticIDs = append(semanticIDs, match.ID)
+	}
+	if m != nil {
+		matching, err := m.MatchingLooks(semanticIDs)
+		if err != nil {
+			return nil, 0, err
+		}
+		semanticIDs = semanticIDs[:0]
+		for _, match := range matches {
+			if matching[match.ID] {
+				semanticIDs = append(semanticIDs, match.ID)
+			}
+		}
+	}
+
+	fused := search.Fuse(textIDs, semanticIDs)
+	if cursor.Offset >= len(fused) {
+		return []*models.Look{}, int64(len(fused)), nil
+	}
+	page := fused[cursor.Offset:]
+	if len(page) > searchPageSize {
+		page = page[:searchPageSize]
 	}
 
-	dbQuery := fmt.Sprintf(searchSql, idsToInClauseWithOrdering(wardrobeIds))
-	if len(wardrobeIds) == 0 {
-		dbQuery = searchSqlNoWardrobeFound
+	var missing []uint
+	for _, id := range page {
+		if _, ok := byID[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		found, err := search.Get().LoadLooks(missing)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, l := range found {
+			byID[l.ID] = l
+		}
 	}
 
-	var looks []models.Look
-	if len(wardrobeIds) > 0 {
-		err = database.DB().Debug().Raw(dbQuery, searchQuery, searchQuery, wardrobeIds, user.Sex, offset, 20).Find(&looks).Error
-	} else {
-		err = database.DB().Debug().Raw(dbQuery, searchQuery, searchQuery, user.Sex, offset, 20).Find(&looks).Error
+	looks := make([]*models.Look, 0, len(page))
+	for _, id := range page {
+		if l, ok := byID[id]; ok {
+			looks = append(looks, l)
+		}
 	}
+	return looks, int64(len(fused)), nil
+}
+
+// HandleSearchAll searches looks, topics, wardrobe items, brands
+// and articles at once. Results can be filtered by facets, every
+// facet param may be repeated or hold comma separated values
+func HandleSearchAll(c *gin.Context) {
+	user, err := GetUser(c)
+	if err != nil {
+		logrus.Errorf("error getting user: %v", err)
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	query := &search.Query{
+		Text: q,
+		Sex:  user.Sex,
+		Filters: search.Filters{
+			Categories:         queryList(c, search.FacetCategory),
+			Seasons:            queryList(c, search.FacetSeason),
+			Brands:             queryList(c, search.FacetBrand),
+			WardrobeCategories: queryList(c, search.FacetWardrobeCategory),
+		},
+	}
+	if limit := c.Query("limit"); limit != "" {
+		query.Limit, err = strconv.Atoi(limit)
+		if err != nil || query.Limit < 0 {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+
+	started := time.Now()
+	results, err := search.Get().Do(query)
 	if err != nil {
 		logrus.Errorf("error searching: %v", err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	if len(looks) == 0 {
-		c.JSON(200, gin.H{
-			"looks":          []interface{}{},
-			"wardrobe_items": wardrobeItems,
-		})
+	var found int64
+	for _, section := range results.Sections {
+		found += section.Count
+	}
+	results.SearchID = recordSearch(c, user, q, found, time.Since(started))
+
+	c.JSON(http.StatusOK, results)
+}
+
+// recordSearch adds query to user's search history and returns id
+// of the record, so clients could report which result was opened
+func recordSearch(c *gin.Context, user *models.User, query string, results int64, latency time.Duration) uint {
+	sr, err := search.Get().Record(user.ID, query, results, latency)
+	if err != nil {
+		logrus.Errorf("error recording user search: %v", err)
+		return 0
+	}
+	recordEvent(c, user, events.TypeSearch, "", 0, map[string]interface{}{
+		"query":      sr.Query,
+		"results":    results,
+		"latency_ms": latency.Milliseconds(),
+	})
+	return sr.ID
+}
+
+// HandleSearchClick records the result user opened from the search
+func HandleSearchClick(c *gin.Context) {
+	user, err := GetUser(c)
+	if err != nil {
+		logrus.Errorf("error getting user: %v", err)
+		c.AbortWithStatus(http.StatusForbidden)
 		return
 	}
 
-	c.JSON(200, gin.H{
-		"looks":          looks,
-		"wardrobe_items": wardrobeItems,
+	searchID, err := strconv.Atoi(c.Param("search"))
+	if err != nil || searchID <= 0 {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var r requests.SearchClickRequest
+	if err := c.BindJSON(&r); err != nil {
+		return
+	}
+
+	err = search.RecordClick(user.ID, uint(searchID), r.EntityType, r.EntityID, r.Position)
+	if err != nil {
+		logrus.Errorf("error recording search click: %v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	recordEvent(c, user, events.TypeClick, r.EntityType, r.EntityID, map[string]interface{}{
+		"search_id": searchID,
+		"position":  r.Position,
 	})
+
+	c.Status(http.StatusOK)
 }
 
-func idsToInClauseWithOrdering(ids []int) string {
-	clause := ""
-	for i := 0; i < len(ids); i++ {
-		if i == len(ids)-1 {
-			clause += fmt.Sprintf("(%v, %v)", ids[i], i+1)
-			break
+// queryList returns values of a query param,
+// that may be repeated or comma separated
+func queryList(c *gin.Context, name string) []string {
+	var values []string
+	for _, param := range c.QueryArray(name) {
+		for _, v := range strings.Split(param, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
 		}
-		clause += fmt.Sprintf("(%v, %v), ", ids[i], i+1)
 	}
-	return clause
+	return values
 }
 
 func HandleSearchSuggestions(c *gin.Context) {
@@ -197,32 +393,23 @@ func HandleSearchSuggestions(c *gin.Context) {
 		return
 	}
 
-	var suggestions []*SearchSuggestion
-
-	err = database.DB().Raw(`SELECT query, count(id) AS c FROM search_records
-                             WHERE created_at >= NOW() - interval '7 day'
-                             GROUP BY search_records.query ORDER BY c DESC LIMIT ?;`, 5).Find(&suggestions).Error
+	suggestions, err := search.Trending(user.Sex, 5)
 	if err != nil {
 		logrus.Errorf("error getting search suggestions: %v", err)
+		suggestions = []*search.Suggestion{}
 	}
 
-	var looks []*models.Look
-
-	lookSlugs, err := gorse.RecommendPopular(user.Sex, 10)
+	looks, degraded, err := adviser.Get().Popular(user, 10)
 	if err != nil {
 		logrus.Errorf("error getting popular looks: %v", err)
 		c.AbortWithStatus(500)
 		return
 	}
 
-	if len(lookSlugs) == 0 {
+	if len(looks) == 0 {
 		err = database.DB().Order("RANDOM()").Where("sex = ?", user.Sex).Limit(10).Find(&looks).Error
-	} else {
-		err = database.DB().Debug().Where("slug in ?", lookSlugs).Find(&looks).Error
 		if err != nil {
-			logrus.Errorf("error getting popular looks from db: %v", err)
-			c.AbortWithStatus(500)
-			return
+			logrus.Errorf("error getting random looks from db: %v", err)
 		}
 	}
 
@@ -231,7 +418,8 @@ func HandleSearchSuggestions(c *gin.Context) {
 			"history":     sr,
 			"suggestions": suggestions,
 		},
-		"looks": looks,
+		"looks":    looks,
+		"degraded": degraded,
 	})
 }
 
@@ -253,119 +441,47 @@ func HandleSearchClearHistory(c *gin.Context) {
 	c.JSON(200, []struct{}{})
 }
 
+// HandleSearchAutofill completes wardrobe item names and tags
+// and suggests queries starting with the text typed
 func HandleSearchAutofill(c *gin.Context) {
-	params := c.Request.URL.Query()
-	q := params["q"]
-	if q[0] == "" {
-		c.JSON(http.StatusNoContent, []int{})
-		return
-	}
-	query := strings.ToLower(strings.TrimSpace(q[0]))
-
-	// Splitting by tags
-	tmpQueryWords := []string{}
-	queryWords := strings.Split(query, " ")
-	for i := 0; i < len(queryWords); i++ {
-		var word string
-		if isSeparator(queryWords[i]) {
-			word = strings.Join([]string{queryWords[i], queryWords[i+1]}, " ")
-			i++
-		} else {
-			word = queryWords[i]
-		}
-		tmpQueryWords = append(tmpQueryWords, word)
-	}
-	queryWordCount := len(tmpQueryWords)
-
-	// Capitalizing first letter
-	capitalizedFirstWord := cases.Title(language.Russian).String(tmpQueryWords[0])
-	var queryWardrobe string
-	if len(tmpQueryWords) > 1 {
-		queryWardrobe = capitalizedFirstWord + " " + strings.Join(tmpQueryWords[1:], " ")
-		tmpQueryWords[0] = capitalizedFirstWord
-	} else {
-		queryWardrobe = capitalizedFirstWord
-		tmpQueryWords[0] = capitalizedFirstWord
-	}
-
-	var wsr []*models.WardrobeItem
-	err := database.DB().Raw("select * from wardrobe_items where name like ? limit ?", queryWardrobe+"%", 10).Find(&wsr).Error
+	user, err := GetUser(c)
 	if err != nil {
-		logrus.Errorf("error searching: %v", err)
-		c.AbortWithStatus(500)
+		logrus.Errorf("error getting user: %v", err)
+		c.AbortWithStatus(http.StatusForbidden)
 		return
 	}
 
-	var sr []*models.SearchRecord
-	err = database.DB().Raw("select query, count(id) as freq from search_records where query like ? group by query order by freq desc limit ?", query+"%", 10).Find(&sr).Error
-	if err != nil {
-		logrus.Errorf("error searching: %v", err)
-		c.AbortWithStatus(500)
+	query := strings.ToLower(strings.TrimSpace(c.Query("q")))
+	if query == "" {
+		c.JSON(http.StatusNoContent, []int{})
 		return
 	}
 
+	completions := search.Get().Complete(user.Sex, query, 10)
+
+	// Tags are words following the query, kept for older clients
 	tags := []string{}
-	counter := 0
-	for {
-		if counter >= len(wsr) {
-			break
-		}
+	queryTokens := len(search.Tokenize(query))
+	for _, completion := range completions {
 		if len(tags) == 3 {
 			break
 		}
-
-		arr := strings.Split(wsr[counter].Name, " ")
-		if len(arr)-queryWordCount < 1 {
-			counter++
-			continue
-		}
-
-		tmpQueryTags := []string{}
-		for i := 0; i < len(arr); i++ {
-			var word string
-			if isSeparator(arr[i]) {
-				word = strings.Join([]string{arr[i], arr[i+1]}, " ")
-				i++
-			} else {
-				word = arr[i]
-			}
-			tmpQueryTags = append(tmpQueryTags, word)
-		}
-
-		if tmpQueryWords[len(tmpQueryWords)-1] != tmpQueryTags[len(tmpQueryWords)-1] {
-			break
-		}
-
-		if !inListAlready(strings.ToLower(tmpQueryTags[queryWordCount]), tags) {
-			tags = append(tags, strings.ToLower(tmpQueryTags[queryWordCount]))
+		tokens := search.Tokenize(completion.Text)
+		if len(tokens) > queryTokens {
+			tags = append(tags, tokens[len(tokens)-1].Text)
 		}
+	}
 
-		counter++
+	sr, err := search.Autofill(user.ID, user.Sex, query, 10)
+	if err != nil {
+		logrus.Errorf("error searching: %v", err)
+		c.AbortWithStatus(500)
+		return
 	}
 
 	c.JSON(200, gin.H{
 		"tags":        tags,
+		"completions": completions,
 		"suggestions": sr,
 	})
 }
-
-func inListAlready(s string, list []string) bool {
-	for _, s2 := range list {
-		if s == s2 {
-			return true
-		}
-	}
-	return false
-}
-
-func isSeparator(sep string) bool {
-	separatorsList := []string{
-		"в", "с", "без",
-	}
-	for _, s := range separatorsList {
-		if s == sep {
-			return true
-		}
-	}
-	return false
-}
@@ -0,0 +1,158 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handlers
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/parasource/papaya-api/pkg/embeddings"
+	"github.com/parasource/papaya-api/pkg/events"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	imageSearchLooks = 20
+	imageSearchItems = 10
+
+	imageSearchTimeout = 15 * time.Second
+)
+
+// HandleSearchImage finds looks and wardrobe items similar to
+// the photo uploaded in the "image" field of a multipart form
+func HandleSearchImage(c *gin.Context) {
+	user, err := GetUser(c)
+	if err != nil {
+		logrus.Errorf("error getting user: %v", err)
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	e := embeddings.Get()
+	if e == nil {
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, embeddings.MaxImageSize+1<<20)
+	file, _, err := c.Request.FormFile("image")
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	image, err := io.ReadAll(io.LimitReader(file, embeddings.MaxImageSize+1))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+	if len(image) > embeddings.MaxImageSize {
+		c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+		return
+	}
+	if !strings.HasPrefix(http.DetectContentType(image), "image/") {
+		c.AbortWithStatus(http.StatusUnsupportedMediaType)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), imageSearchTimeout)
+	defer cancel()
+	embedding, err := e.Embed(ctx, image)
+	if err != nil {
+		logrus.Errorf("error embedding uploaded image: %v", err)
+		c.AbortWithStatus(http.StatusBadGateway)
+		return
+	}
+
+	lookMatches, err := e.Similar(embeddings.EntityLook, embedding, user.Sex, imageSearchLooks)
+	if err != nil {
+		logrus.Errorf("error searching looks by image: %v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	itemMatches, err := e.Similar(embeddings.EntityItem, embedding, user.Sex, imageSearchItems)
+	if err != nil {
+		logrus.Errorf("error searching wardrobe items by image: %v", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	looks := []*models.Look{}
+	if len(lookMatches) > 0 {
+		var found []*models.Look
+		err = database.DB().Where("id IN ?", matchIDs(lookMatches)).Find(&found).Error
+		if err != nil {
+			logrus.Errorf("error getting looks: %v", err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		byID := make(map[uint]*models.Look, len(found))
+		for _, l := range found {
+			byID[l.ID] = l
+		}
+		for _, m := range lookMatches {
+			if l, ok := byID[m.ID]; ok {
+				looks = append(looks, l)
+			}
+		}
+	}
+
+	items := []*models.WardrobeItem{}
+	if len(itemMatches) > 0 {
+		var found []*models.WardrobeItem
+		err = database.DB().Where("id IN ?", matchIDs(itemMatches)).
+			Preload("WardrobeCategory").Preload("Urls.Brand").Find(&found).Error
+		if err != nil {
+			logrus.Errorf("error getting wardrobe items: %v", err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+		byID := make(map[uint]*models.WardrobeItem, len(found))
+		for _, i := range found {
+			byID[i.ID] = i
+		}
+		for _, m := range itemMatches {
+			if i, ok := byID[m.ID]; ok {
+				items = append(items, i)
+			}
+		}
+	}
+
+	recordEvent(c, user, events.TypeSearch, "", 0, map[string]interface{}{
+		"image":   true,
+		"results": len(looks) + len(items),
+	})
+
+	c.JSON(200, gin.H{
+		"looks":          looks,
+		"wardrobe_items": items,
+	})
+}
+
+func matchIDs(matches []*embeddings.Match) []uint {
+	ids := make([]uint, len(matches))
+	for i, m := range matches {
+		ids[i] = m.ID
+	}
+	return ids
+}
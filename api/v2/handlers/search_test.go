@@ -0,0 +1,277 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/parasource/papaya-api/pkg/embeddings"
+	"github.com/parasource/papaya-api/pkg/experiments"
+	"github.com/parasource/papaya-api/pkg/mood"
+	"github.com/parasource/papaya-api/pkg/search"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+type searchResponse struct {
+	Looks         []*models.Look         `json:"looks"`
+	WardrobeItems []*models.WardrobeItem `json:"wardrobe_items"`
+	Total         int64                  `json:"total"`
+	TotalCapped   bool                   `json:"total_capped"`
+	NextCursor    string                 `json:"next_cursor"`
+	DidYouMean    string                 `json:"did_you_mean"`
+}
+
+var searchUser = &models.User{Sex: "male"}
+
+// experimentsStore keeps experiments in memory
+type experimentsStore struct {
+	experiments []*models.Experiment
+	exposures   []string
+}
+
+func (s *experimentsStore) Experiments() ([]*models.Experiment, error) {
+	return s.experiments, nil
+}
+
+func (s *experimentsStore) Expose(userID uint, key string, variant string) error {
+	s.exposures = append(s.exposures, fmt.Sprintf("%v:%v:%v", userID, key, variant))
+	return nil
+}
+
+// embeddingsStore finds the same looks by meaning for any query
+type embeddingsStore struct {
+	matches []*embeddings.Match
+}
+
+func (s *embeddingsStore) Setup() error {
+	return nil
+}
+
+func (s *embeddingsStore) Similar(table string, entity string, embedding []float32, sex string, limit int) ([]*embeddings.Match, error) {
+	if table != "text_embeddings" || entity != embeddings.EntityLook {
+		return nil, fmt.Errorf("unexpected search of %v in %v", entity, table)
+	}
+	return s.matches, nil
+}
+
+// newSearchFake makes search use a fake backend with 25 looks
+// of jeans, 3 of which are basic, and a look of the other sex
+func newSearchFake(t *testing.T) *search.Fake {
+	fake := search.NewFake()
+	for i := 1; i <= 25; i++ {
+		look := &models.Look{Model: gorm.Model{ID: uint(i)}, Name: fmt.Sprintf("Джинсы %v", i), Sex: "male"}
+		if i <= 3 {
+			look.Categories = []*models.Category{{Slug: "basic"}}
+		}
+		fake.AddLook(look)
+	}
+	fake.AddLook(&models.Look{Model: gorm.Model{ID: 100}, Name: "Джинсы", Sex: "female"})
+
+	_, err := search.NewWithBackend(search.Config{}, fake)
+	if err != nil {
+		t.Fatalf("error creating search: %v", err)
+	}
+	_, err = experiments.NewWithStore(experiments.Config{}, &experimentsStore{})
+	if err != nil {
+		t.Fatalf("error creating experiments: %v", err)
+	}
+	return fake
+}
+
+func doSearch(t *testing.T, params url.Values) (int, *searchResponse) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/search", func(c *gin.Context) {
+		c.Set(UserKey, searchUser)
+	}, HandleSearch)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/search?"+params.Encode(), nil))
+	if w.Code != http.StatusOK {
+		return w.Code, nil
+	}
+
+	res := &searchResponse{}
+	err := json.Unmarshal(w.Body.Bytes(), res)
+	if err != nil {
+		t.Fatalf("error decoding response: %v", err)
+	}
+	return w.Code, res
+}
+
+func TestHandleSearchPages(t *testing.T) {
+	fake := newSearchFake(t)
+
+	_, first := doSearch(t, url.Values{"q": {"джинсы"}})
+	if len(first.Looks) != searchPageSize || first.Total != 25 || first.NextCursor == "" {
+		t.Fatalf("got %v looks of %v, cursor %q on the first page", len(first.Looks), first.Total, first.NextCursor)
+	}
+	if len(fake.Records) != 1 || *fake.Records[0].Results != 25 {
+		t.Errorf("got %v searches recorded, want the first page", len(fake.Records))
+	}
+
+	_, second := doSearch(t, url.Values{"q": {"джинсы"}, "cursor": {first.NextCursor}})
+	if len(second.Looks) != 5 || second.Total != 25 || second.NextCursor != "" {
+		t.Fatalf("got %v looks of %v, cursor %q on the last page", len(second.Looks), second.Total, second.NextCursor)
+	}
+	if len(fake.Records) != 1 {
+		t.Errorf("got %v searches recorded, scrolling is not a search", len(fake.Records))
+	}
+
+	seen := make(map[uint]bool)
+	for _, look := range append(first.Looks, second.Looks...) {
+		if look.Sex != "male" || seen[look.ID] {
+			t.Errorf("got look %v of sex %v, seen before: %v", look.ID, look.Sex, seen[look.ID])
+		}
+		seen[look.ID] = true
+	}
+
+	// Pages are kept for older clients
+	_, page := doSearch(t, url.Values{"q": {"джинсы"}, "page": {"1"}})
+	if len(page.Looks) != 5 || page.Looks[0].ID != second.Looks[0].ID {
+		t.Errorf("got %v looks on the second page", len(page.Looks))
+	}
+}
+
+func TestHandleSearchMood(t *testing.T) {
+	newSearchFake(t)
+	err := mood.Configure(mood.Config{Rules: `{"calm": {"categories": ["basic"]}}`})
+	if err != nil {
+		t.Fatalf("error configuring moods: %v", err)
+	}
+	defer mood.Configure(mood.Config{})
+
+	_, res := doSearch(t, url.Values{"q": {"джинсы"}, "mood": {"calm"}})
+	if res.Total != 3 || len(res.Looks) != 3 || res.NextCursor != "" {
+		t.Errorf("got %v looks of %v, cursor %q, want 3 basic looks", len(res.Looks), res.Total, res.NextCursor)
+	}
+
+	// Moods without rules match nothing
+	_, res = doSearch(t, url.Values{"q": {"джинсы"}, "mood": {"cozy"}})
+	if res.Total != 0 || len(res.Looks) != 0 {
+		t.Errorf("got %v looks of %v for a mood without rules", len(res.Looks), res.Total)
+	}
+}
+
+func TestHandleSearchWardrobe(t *testing.T) {
+	fake := newSearchFake(t)
+	sneakers := &models.WardrobeItem{ID: 1, Name: "Белые кеды", Sex: "male"}
+	fake.AddItem(sneakers)
+	fake.AddLook(&models.Look{Model: gorm.Model{ID: 200}, Name: "Кеды и шорты", Sex: "male"})
+	fake.AddLook(&models.Look{Model: gorm.Model{ID: 201}, Name: "Прогулка", Sex: "male", Items: []*models.WardrobeItem{sneakers}})
+
+	_, res := doSearch(t, url.Values{"q": {"кеды"}})
+	if res.Total != 2 || len(res.WardrobeItems) != 1 || res.WardrobeItems[0].ID != sneakers.ID {
+		t.Fatalf("got %v looks, %v wardrobe items", res.Total, len(res.WardrobeItems))
+	}
+	if res.Looks[0].ID != 201 {
+		t.Errorf("got look %v first, want the one with matching wardrobe item", res.Looks[0].ID)
+	}
+}
+
+func TestHandleSearchDidYouMean(t *testing.T) {
+	fake := newSearchFake(t)
+	fake.Suggestions["джинсв"] = "джинсы"
+
+	_, first := doSearch(t, url.Values{"q": {"джинсв"}})
+	if first.DidYouMean != "джинсы" || first.Total != 25 {
+		t.Fatalf("got %v looks for %q", first.Total, first.DidYouMean)
+	}
+
+	// The corrected query is searched on the following pages too
+	_, second := doSearch(t, url.Values{"q": {"джинсв"}, "cursor": {first.NextCursor}})
+	if second.DidYouMean != "джинсы" || len(second.Looks) != 5 {
+		t.Errorf("got %v looks for %q on the second page", len(second.Looks), second.DidYouMean)
+	}
+}
+
+func TestHandleSearchInvalid(t *testing.T) {
+	newSearchFake(t)
+
+	for _, params := range []url.Values{
+		{"q": {"джинсы"}, "cursor": {"!!"}},
+		{"q": {"джинсы"}, "page": {"-1"}},
+		{},
+	} {
+		if code, _ := doSearch(t, params); code != http.StatusBadRequest {
+			t.Errorf("%v: got status %v, want 400", params.Encode(), code)
+		}
+	}
+}
+
+func TestHandleSearchHybrid(t *testing.T) {
+	fake := newSearchFake(t)
+	jeans := &models.WardrobeItem{ID: 1, Name: "Синие джинсы", Sex: "male"}
+	fake.AddItem(jeans)
+	// Found by meaning only
+	fake.AddLook(&models.Look{Model: gorm.Model{ID: 300}, Name: "Деним", Sex: "male"})
+	// Found by the wardrobe item only, so it is the last by text
+	fake.AddLook(&models.Look{Model: gorm.Model{ID: 400}, Name: "Прогулка", Sex: "male", Items: []*models.WardrobeItem{jeans}})
+
+	_, err := embeddings.NewWithStore(embeddings.Config{Provider: embeddings.ProviderFake}, &embeddingsStore{
+		matches: []*embeddings.Match{{ID: 300, Similarity: 0.9}, {ID: 25, Similarity: 0.8}},
+	})
+	if err != nil {
+		t.Fatalf("error creating embeddings: %v", err)
+	}
+	defer embeddings.New(embeddings.Config{})
+
+	_, first := doSearch(t, url.Values{"q": {"джинсы"}})
+	if len(first.Looks) != searchPageSize || first.Total != 27 || first.TotalCapped || first.NextCursor == "" {
+		t.Fatalf("got %v looks of %v, capped %v, cursor %q on the first page", len(first.Looks), first.Total, first.TotalCapped, first.NextCursor)
+	}
+	// Found both ways goes first, ties keep the text order
+	for i, id := range []uint{25, 1, 300, 2} {
+		if first.Looks[i].ID != id {
+			t.Errorf("got look %v at %v, want %v", first.Looks[i].ID, i, id)
+		}
+	}
+
+	_, second := doSearch(t, url.Values{"q": {"джинсы"}, "cursor": {first.NextCursor}})
+	if len(second.Looks) != 7 || second.NextCursor != "" {
+		t.Fatalf("got %v looks, cursor %q on the last page", len(second.Looks), second.NextCursor)
+	}
+	// Candidates are ranked by text, not by wardrobe items
+	if last := second.Looks[len(second.Looks)-1]; last.ID != 400 {
+		t.Errorf("got look %v last, want the one matching by wardrobe only", last.ID)
+	}
+
+	seen := make(map[uint]bool)
+	for _, look := range append(first.Looks, second.Looks...) {
+		if seen[look.ID] {
+			t.Errorf("got look %v twice", look.ID)
+		}
+		seen[look.ID] = true
+	}
+
+	// Only so many text candidates are fused, the new looks
+	// rank higher by text, so both looks found by meaning
+	// are added to them
+	for i := 1000; i < 1000+hybridCandidates; i++ {
+		fake.AddLook(&models.Look{Model: gorm.Model{ID: uint(i)}, Name: "Джинсы", Sex: "male"})
+	}
+	_, capped := doSearch(t, url.Values{"q": {"джинсы"}})
+	if !capped.TotalCapped || capped.Total != hybridCandidates+2 {
+		t.Errorf("got %v looks, capped %v, want %v capped", capped.Total, capped.TotalCapped, hybridCandidates+2)
+	}
+}
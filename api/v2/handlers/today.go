@@ -0,0 +1,54 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/parasource/papaya-api/pkg/events"
+	"github.com/parasource/papaya-api/pkg/today"
+	"github.com/rs/zerolog/log"
+	"github.com/sirupsen/logrus"
+	"net/http"
+)
+
+func HandleGetTodayLook(c *gin.Context) {
+	user, err := GetUser(c)
+	if err != nil {
+		logrus.Errorf("error getting user: %v", err)
+		c.AbortWithStatus(403)
+		return
+	}
+
+	look, date, err := today.Get().TodayLook(user)
+	if err != nil {
+		log.Error().Err(err).Msg("error getting today look")
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	if look == nil {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	recordEvent(c, user, events.TypeTodayLook, events.EntityLook, look.ID, map[string]interface{}{
+		"date": date,
+	})
+
+	c.JSON(200, gin.H{
+		"look": look,
+		"date": date,
+	})
+}
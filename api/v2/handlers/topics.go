@@ -20,6 +20,7 @@ import (
 	"github.com/gin-gonic/gin"
 	database "github.com/parasource/papaya-api/pkg/database"
 	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/parasource/papaya-api/pkg/events"
 	"github.com/sirupsen/logrus"
 	"net/http"
 	"strconv"
@@ -84,6 +85,10 @@ func HandleGetTopic(c *gin.Context) {
 	var isSaved bool
 	database.DB().Raw("SELECT COUNT(1) FROM saved_topics WHERE user_id = ? AND topic_id = ?", user.ID, topic.ID).Scan(&isSaved)
 
+	if page == 0 {
+		recordEvent(c, user, events.TypeView, events.EntityTopic, topic.ID, nil)
+	}
+
 	c.JSON(200, gin.H{
 		"topic":   topic,
 		"looks":   looks,
@@ -114,8 +119,11 @@ func HandleSaveTopic(c *gin.Context) {
 	if err != nil {
 		logrus.Errorf("error watching topic: %v", err)
 		c.AbortWithStatus(http.StatusInternalServerError)
+		return
 	}
 
+	recordEvent(c, user, events.TypeFollow, events.EntityTopic, topic.ID, nil)
+
 	c.JSON(200, gin.H{
 		"success": true,
 	})
@@ -143,8 +151,11 @@ func HandleUnsaveTopic(c *gin.Context) {
 	if err != nil {
 		logrus.Errorf("error watching topic: %v", err)
 		c.AbortWithStatus(http.StatusInternalServerError)
+		return
 	}
 
+	recordEvent(c, user, events.TypeUnfollow, events.EntityTopic, topic.ID, nil)
+
 	c.JSON(200, gin.H{
 		"success": true,
 	})
@@ -0,0 +1,41 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package middleware
+
+import (
+	"crypto/subtle"
+	"github.com/gin-gonic/gin"
+	"github.com/parasource/papaya-api/pkg/util"
+	"net/http"
+)
+
+// AdminToken grants access to admin endpoints,
+// they are disabled while it's empty
+var AdminToken string
+
+func AdminMiddleware(c *gin.Context) {
+	if AdminToken == "" {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	token, err := util.ExtractToken(c.GetHeader("Authorization"))
+	if err != nil || subtle.ConstantTimeCompare([]byte(token), []byte(AdminToken)) != 1 {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+}
@@ -18,7 +18,7 @@ package middleware
 
 import (
 	"github.com/gin-gonic/gin"
-	"github.com/parasource/papaya-api/api/v1/handlers"
+	"github.com/parasource/papaya-api/api/v2/handlers"
 	"github.com/parasource/papaya-api/pkg/util"
 	"net/http"
 	"strings"
@@ -52,4 +52,5 @@ func AuthMiddleware(c *gin.Context) {
 		c.AbortWithStatus(403)
 		return
 	}
+	c.Set(handlers.UserKey, user)
 }
@@ -15,3 +15,14 @@
  */
 
 package requests
+
+type ImpressionsRequest struct {
+	Impressions []ImpressionRequest `json:"impressions" binding:"required,max=200,dive"`
+}
+
+type ImpressionRequest struct {
+	LookID   uint   `json:"look_id" binding:"required"`
+	Position int    `json:"position" binding:"min=0"`
+	DwellMs  int64  `json:"dwell_ms" binding:"min=0"`
+	Surface  string `json:"surface"`
+}
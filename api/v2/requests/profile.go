@@ -20,10 +20,12 @@ type UpdateSettingsRequest struct {
 	Name                     string `json:"name" bson:"name"`
 	Sex                      string `json:"sex" bson:"sex"`
 	ReceivePushNotifications bool   `json:"receive_push_notifications" bson:"receive_push_notifications"`
+	Timezone                 string `json:"timezone" bson:"timezone"`
+	Hemisphere               string `json:"hemisphere" bson:"hemisphere"`
 }
 
 type SetMoodRequest struct {
-	Mood string `json:"mood" binding:"required"`
+	Mood string `json:"mood"`
 }
 
 type SetWardrobeRequest struct {
@@ -0,0 +1,31 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package requests
+
+type SynonymGroupRequest struct {
+	Terms []string `json:"terms" binding:"required,min=2,dive,required"`
+}
+
+type BlockedTermRequest struct {
+	Term string `json:"term" binding:"required,max=100"`
+}
+
+type SearchClickRequest struct {
+	EntityType string `json:"entity_type" binding:"required,oneof=look topic article wardrobe_item brand"`
+	EntityID   uint   `json:"entity_id" binding:"required"`
+	Position   int    `json:"position" binding:"min=0"`
+}
@@ -59,9 +59,12 @@ func Routes(r *gin.Engine) {
 
 	/// Search
 	apiV2.GET("/search", middleware.AuthMiddleware, handlers.HandleSearch)
+	apiV2.GET("/search/all", middleware.AuthMiddleware, handlers.HandleSearchAll)
+	apiV2.POST("/search/image", middleware.AuthMiddleware, handlers.HandleSearchImage)
 	apiV2.GET("/search/suggestions", middleware.AuthMiddleware, handlers.HandleSearchSuggestions)
 	apiV2.POST("/search/clear-history", middleware.AuthMiddleware, handlers.HandleSearchClearHistory)
 	apiV2.GET("/search/autofill", middleware.AuthMiddleware, handlers.HandleSearchAutofill)
+	apiV2.POST("/search/:search/click", middleware.AuthMiddleware, handlers.HandleSearchClick)
 
 	/// Topics
 	apiV2.GET("/topics/saved", middleware.AuthMiddleware, handlers.HandleGetSavedTopics)
@@ -77,6 +80,7 @@ func Routes(r *gin.Engine) {
 	/// Feed and looks
 	apiV2.GET("/looks/:look", middleware.AuthMiddleware, handlers.HandleGetLook)
 	apiV2.GET("/looks/:look/item/:item", middleware.AuthMiddleware, handlers.HandleGetLookItem)
+	apiV2.GET("/looks/:look/complete", middleware.AuthMiddleware, handlers.HandleCompleteLook)
 	apiV2.PUT("/looks/:look/like", middleware.AuthMiddleware, handlers.HandleLikeLook)
 	apiV2.DELETE("/looks/:look/like", middleware.AuthMiddleware, handlers.HandleUnlikeLook)
 	apiV2.PUT("/looks/:look/dislike", middleware.AuthMiddleware, handlers.HandleDislikeLook)
@@ -84,6 +88,8 @@ func Routes(r *gin.Engine) {
 	apiV2.GET("/liked", middleware.AuthMiddleware, handlers.GetLikedLooks)
 	apiV2.GET("/feed", middleware.AuthMiddleware, handlers.HandleFeed)
 	apiV2.GET("/feed/:category", middleware.AuthMiddleware, handlers.HandleFeedByCategory)
+	apiV2.POST("/impressions", middleware.AuthMiddleware, handlers.HandleImpressions)
+	apiV2.GET("/today", middleware.AuthMiddleware, handlers.HandleGetTodayLook)
 
 	// Articles
 	// I'll make it open because we have an articles site,
@@ -96,13 +102,30 @@ func Routes(r *gin.Engine) {
 	apiV2.GET("/wardrobe", middleware.AuthMiddleware, handlers.HandleGetWardrobeCategories)
 	apiV2.GET("/wardrobe/:category", middleware.AuthMiddleware, handlers.HandleGetWardrobeItems)
 
+	/// Outfits
+	apiV2.GET("/outfits/generate", middleware.AuthMiddleware, handlers.HandleGenerateOutfits)
+
 	// Email Subscriptions
 	apiV2.POST("/email/subscribe", handlers.HandleEmailSubscribe)
 
 	/// Profile
 	apiV2.POST("/profile/set-wardrobe", middleware.AuthMiddleware, handlers.HandleProfileSetWardrobe)
 	apiV2.POST("/profile/set-mood", middleware.AuthMiddleware, handlers.HandleProfileSetMood)
+	apiV2.GET("/moods", middleware.AuthMiddleware, handlers.HandleGetMoods)
 	apiV2.POST("/profile/update-settings", middleware.AuthMiddleware, handlers.HandleProfileUpdateSettings)
 	apiV2.GET("/profile/get-wardrobe", middleware.AuthMiddleware, handlers.HandleProfileGetWardrobe)
+	apiV2.GET("/profile/wardrobe/insights", middleware.AuthMiddleware, handlers.HandleProfileWardrobeInsights)
 	apiV2.POST("/profile/set-apns-token", middleware.AuthMiddleware, handlers.HandleSetAPNSToken)
+
+	/// Admin
+	apiV2.GET("/admin/metrics", middleware.AdminMiddleware, handlers.HandleAdminMetrics)
+	apiV2.GET("/admin/search", middleware.AdminMiddleware, handlers.HandleAdminSearchReport)
+	apiV2.GET("/admin/experiments/:experiment", middleware.AdminMiddleware, handlers.HandleAdminExperimentResults)
+	apiV2.GET("/admin/synonyms", middleware.AdminMiddleware, handlers.HandleAdminListSynonyms)
+	apiV2.POST("/admin/synonyms", middleware.AdminMiddleware, handlers.HandleAdminCreateSynonyms)
+	apiV2.PUT("/admin/synonyms/:group", middleware.AdminMiddleware, handlers.HandleAdminUpdateSynonyms)
+	apiV2.DELETE("/admin/synonyms/:group", middleware.AdminMiddleware, handlers.HandleAdminDeleteSynonyms)
+	apiV2.GET("/admin/blocklist", middleware.AdminMiddleware, handlers.HandleAdminListBlockedTerms)
+	apiV2.POST("/admin/blocklist", middleware.AdminMiddleware, handlers.HandleAdminBlockTerm)
+	apiV2.DELETE("/admin/blocklist/:term", middleware.AdminMiddleware, handlers.HandleAdminUnblockTerm)
 }
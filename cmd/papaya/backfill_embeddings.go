@@ -0,0 +1,82 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/embeddings"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	rootCmd.AddCommand(backfillEmbeddingsCmd)
+}
+
+// backfillEmbeddingsCmd embeds images of looks and wardrobe items
+// and texts of looks, that have no embeddings yet. It is configured
+// with the same environment variables as the server
+var backfillEmbeddingsCmd = &cobra.Command{
+	Use:   "backfill-embeddings",
+	Short: "Embed images and texts for visual and semantic search",
+	Run: func(cmd *cobra.Command, args []string) {
+		for k, v := range configDefaults {
+			viper.SetDefault(k, v)
+		}
+
+		bindEnvs := []string{
+			"db_address",
+			"embeddings_provider", "embeddings_address", "embeddings_images_address",
+		}
+		for _, env := range bindEnvs {
+			err := viper.BindEnv(env)
+			if err != nil {
+				logrus.Fatalf("error binding env variable: %v", err)
+			}
+		}
+
+		v := viper.GetViper()
+
+		dbConfig, err := getDatabaseConfig(v)
+		if err != nil {
+			logrus.Fatalf("eror getting database config: %v", err)
+		}
+		err = database.New(dbConfig)
+		if err != nil {
+			logrus.Fatalf("error creating database: %v", err)
+		}
+
+		e, err := embeddings.New(embeddings.Config{
+			Provider:      v.GetString("embeddings_provider"),
+			Address:       v.GetString("embeddings_address"),
+			ImagesAddress: v.GetString("embeddings_images_address"),
+		})
+		if err != nil {
+			logrus.Fatalf("error creating embeddings: %v", err)
+		}
+		if e == nil {
+			logrus.Fatalf("embeddings provider is not set")
+		}
+
+		err = e.BackfillAll()
+		if err != nil {
+			logrus.Fatalf("error backfilling embeddings: %v", err)
+		}
+		logrus.Infof("embeddings backfilled")
+	},
+}
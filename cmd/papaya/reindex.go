@@ -0,0 +1,79 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package main
+
+import (
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/search"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	rootCmd.AddCommand(reindexCmd)
+}
+
+// reindexCmd rebuilds documents of the search backend. It is
+// configured with the same environment variables as the server
+var reindexCmd = &cobra.Command{
+	Use:   "reindex",
+	Short: "Rebuild documents of the search backend",
+	Run: func(cmd *cobra.Command, args []string) {
+		for k, v := range configDefaults {
+			viper.SetDefault(k, v)
+		}
+
+		bindEnvs := []string{
+			"db_address",
+			"search_backend", "search_address", "search_api_key", "search_index_prefix",
+		}
+		for _, env := range bindEnvs {
+			err := viper.BindEnv(env)
+			if err != nil {
+				logrus.Fatalf("error binding env variable: %v", err)
+			}
+		}
+
+		v := viper.GetViper()
+
+		dbConfig, err := getDatabaseConfig(v)
+		if err != nil {
+			logrus.Fatalf("eror getting database config: %v", err)
+		}
+		err = database.New(dbConfig)
+		if err != nil {
+			logrus.Fatalf("error creating database: %v", err)
+		}
+
+		s, err := search.New(search.Config{
+			Backend:     v.GetString("search_backend"),
+			Address:     v.GetString("search_address"),
+			APIKey:      v.GetString("search_api_key"),
+			IndexPrefix: v.GetString("search_index_prefix"),
+		})
+		if err != nil {
+			logrus.Fatalf("error creating search: %v", err)
+		}
+
+		err = s.Reindex()
+		if err != nil {
+			logrus.Fatalf("error reindexing search: %v", err)
+		}
+		logrus.Infof("search reindexed")
+	},
+}
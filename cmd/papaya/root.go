@@ -26,6 +26,17 @@ import (
 	"runtime"
 )
 
+// defaultMoodRules are categories, topics and item tags of looks
+// matching every mood, they should follow categories in the database
+const defaultMoodRules = `{
+	"calm": {"categories": ["casual", "minimalism"], "topics": ["basic", "capsule"], "tags": ["базовый", "трикотаж", "лен"]},
+	"confident": {"categories": ["classic", "business"], "topics": ["office"], "tags": ["пиджак", "костюм", "кожа"]},
+	"romantic": {"categories": ["romantic", "evening"], "topics": ["date"], "tags": ["кружево", "шелк", "платье"]},
+	"playful": {"categories": ["streetwear"], "topics": ["party"], "tags": ["принт", "яркий", "оверсайз"]},
+	"cozy": {"categories": ["casual", "homewear"], "topics": ["weekend"], "tags": ["кашемир", "вязаный", "флис"]},
+	"energetic": {"categories": ["sport"], "topics": ["active"], "tags": ["спортивный", "кроссовки"]}
+}`
+
 var configDefaults = map[string]interface{}{
 	"gomaxprocs": 0,
 	// http file server host and port
@@ -37,6 +48,42 @@ var configDefaults = map[string]interface{}{
 	"adviser_host": "gorse-server",
 	"adviser_port": "8087",
 
+	// weights of feed recommendation sources
+	"feed_weights": "gorse=15,wardrobe=5,mood=4",
+	// weights of similar looks ranking features
+	"similar_weights": "items=3,categories=2,topics=1,neighbors=4",
+
+	// redis is used for caching, leave address empty to disable it
+	"redis_address":  "",
+	"redis_password": "",
+	"redis_database": 0,
+
+	// weather provider is either http or fixture, leave it empty to disable weather
+	"weather_provider": "",
+	"weather_address":  "https://api.open-meteo.com",
+	"weather_fixture":  "",
+
+	// json array of a/b experiments, see experiments.Config
+	"experiments": "",
+	// json object of looks matching moods, see mood.Config
+	"mood_rules": defaultMoodRules,
+
+	// search backend is either postgres or meilisearch
+	"search_backend":      "postgres",
+	"search_address":      "",
+	"search_api_key":      "",
+	"search_index_prefix": "papaya_",
+
+	// embeddings provider is either http or fake, leave it empty to disable visual and semantic search
+	"embeddings_provider":       "",
+	"embeddings_address":        "",
+	"embeddings_images_address": "",
+
+	// admin endpoints are disabled without a token
+	"admin_token": "",
+	// feed cursors are signed with it, it should be the same on all instances
+	"cursor_secret": "",
+
 	// seconds to wait til force shutdown
 	"shutdown_timeout": 30,
 }
@@ -47,6 +94,25 @@ func init() {
 	rootCmd.Flags().String("db_address", "postgres://postgres:5432/papaya", "database url")
 	rootCmd.Flags().String("adviser_host", "gorse-server", "adviser host")
 	rootCmd.Flags().String("adviser_port", "8087", "adviser port")
+	rootCmd.Flags().String("feed_weights", "gorse=15,wardrobe=5,mood=4", "feed recommendation sources weights")
+	rootCmd.Flags().String("similar_weights", "items=3,categories=2,topics=1,neighbors=4", "similar looks ranking weights")
+	rootCmd.Flags().String("redis_address", "", "redis address")
+	rootCmd.Flags().String("redis_password", "", "redis password")
+	rootCmd.Flags().Int("redis_database", 0, "redis database")
+	rootCmd.Flags().String("weather_provider", "", "weather provider, http or fixture")
+	rootCmd.Flags().String("weather_address", "https://api.open-meteo.com", "weather http provider address")
+	rootCmd.Flags().String("weather_fixture", "", "weather fixture provider file")
+	rootCmd.Flags().String("experiments", "", "a/b experiments json")
+	rootCmd.Flags().String("mood_rules", defaultMoodRules, "looks matching moods json")
+	rootCmd.Flags().String("search_backend", "postgres", "search backend, postgres or meilisearch")
+	rootCmd.Flags().String("search_address", "", "external search backend address")
+	rootCmd.Flags().String("search_api_key", "", "external search backend api key")
+	rootCmd.Flags().String("search_index_prefix", "papaya_", "external search backend index prefix")
+	rootCmd.Flags().String("embeddings_provider", "", "embeddings provider, http or fake")
+	rootCmd.Flags().String("embeddings_address", "", "embeddings http provider address")
+	rootCmd.Flags().String("embeddings_images_address", "", "base url of relative image paths")
+	rootCmd.Flags().String("admin_token", "", "admin endpoints token")
+	rootCmd.Flags().String("cursor_secret", "", "feed cursors signing secret")
 	rootCmd.Flags().Int("shutdown_timeout", 30, "node graceful shutdown timeout")
 
 	viper.BindPFlag("http_host", rootCmd.Flags().Lookup("http_host"))
@@ -54,6 +120,25 @@ func init() {
 	viper.BindPFlag("db_address", rootCmd.Flags().Lookup("db_address"))
 	viper.BindPFlag("adviser_host", rootCmd.Flags().Lookup("adviser_host"))
 	viper.BindPFlag("adviser_port", rootCmd.Flags().Lookup("adviser_port"))
+	viper.BindPFlag("feed_weights", rootCmd.Flags().Lookup("feed_weights"))
+	viper.BindPFlag("similar_weights", rootCmd.Flags().Lookup("similar_weights"))
+	viper.BindPFlag("redis_address", rootCmd.Flags().Lookup("redis_address"))
+	viper.BindPFlag("redis_password", rootCmd.Flags().Lookup("redis_password"))
+	viper.BindPFlag("redis_database", rootCmd.Flags().Lookup("redis_database"))
+	viper.BindPFlag("weather_provider", rootCmd.Flags().Lookup("weather_provider"))
+	viper.BindPFlag("weather_address", rootCmd.Flags().Lookup("weather_address"))
+	viper.BindPFlag("weather_fixture", rootCmd.Flags().Lookup("weather_fixture"))
+	viper.BindPFlag("experiments", rootCmd.Flags().Lookup("experiments"))
+	viper.BindPFlag("mood_rules", rootCmd.Flags().Lookup("mood_rules"))
+	viper.BindPFlag("search_backend", rootCmd.Flags().Lookup("search_backend"))
+	viper.BindPFlag("search_address", rootCmd.Flags().Lookup("search_address"))
+	viper.BindPFlag("search_api_key", rootCmd.Flags().Lookup("search_api_key"))
+	viper.BindPFlag("search_index_prefix", rootCmd.Flags().Lookup("search_index_prefix"))
+	viper.BindPFlag("embeddings_provider", rootCmd.Flags().Lookup("embeddings_provider"))
+	viper.BindPFlag("embeddings_address", rootCmd.Flags().Lookup("embeddings_address"))
+	viper.BindPFlag("embeddings_images_address", rootCmd.Flags().Lookup("embeddings_images_address"))
+	viper.BindPFlag("admin_token", rootCmd.Flags().Lookup("admin_token"))
+	viper.BindPFlag("cursor_secret", rootCmd.Flags().Lookup("cursor_secret"))
 	viper.BindPFlag("shutdown_timeout", rootCmd.Flags().Lookup("shutdown_timeout"))
 }
 
@@ -68,6 +153,12 @@ var rootCmd = &cobra.Command{
 		bindEnvs := []string{
 			"http_host", "http_port",
 			"adviser_host", "adviser_port", "db_address",
+			"feed_weights", "similar_weights",
+			"redis_address", "redis_password", "redis_database",
+			"weather_provider", "weather_address", "weather_fixture",
+			"experiments", "mood_rules", "admin_token", "cursor_secret",
+			"search_backend", "search_address", "search_api_key", "search_index_prefix",
+			"embeddings_provider", "embeddings_address", "embeddings_images_address",
 			"shutdown_timeout",
 		}
 		for _, env := range bindEnvs {
@@ -92,16 +183,64 @@ var rootCmd = &cobra.Command{
 
 		adviserHost := v.GetString("adviser_host")
 		adviserPort := v.GetString("adviser_port")
+		feedWeights := v.GetString("feed_weights")
+		similarWeights := v.GetString("similar_weights")
+
+		redisAddress := v.GetString("redis_address")
+		redisPassword := v.GetString("redis_password")
+		redisDatabase := v.GetInt("redis_database")
+
+		weatherProvider := v.GetString("weather_provider")
+		weatherAddress := v.GetString("weather_address")
+		weatherFixture := v.GetString("weather_fixture")
+
+		experiments := v.GetString("experiments")
+		moodRules := v.GetString("mood_rules")
+		adminToken := v.GetString("admin_token")
+		cursorSecret := v.GetString("cursor_secret")
+
+		searchBackend := v.GetString("search_backend")
+		searchAddress := v.GetString("search_address")
+		searchAPIKey := v.GetString("search_api_key")
+		searchIndexPrefix := v.GetString("search_index_prefix")
+
+		embeddingsProvider := v.GetString("embeddings_provider")
+		embeddingsAddress := v.GetString("embeddings_address")
+		embeddingsImagesAddress := v.GetString("embeddings_images_address")
 
 		dbConfig, err := getDatabaseConfig(v)
 		if err != nil {
 			logrus.Fatalf("eror getting database config: %v", err)
 		}
 		papaya, err := papaya.NewPapaya(papaya.Config{
-			HttpHost:    httpHost,
-			HttpPort:    httpPort,
-			AdviserHost: adviserHost,
-			AdviserPort: adviserPort,
+			HttpHost:       httpHost,
+			HttpPort:       httpPort,
+			AdviserHost:    adviserHost,
+			AdviserPort:    adviserPort,
+			FeedWeights:    feedWeights,
+			SimilarWeights: similarWeights,
+
+			RedisAddress:  redisAddress,
+			RedisPassword: redisPassword,
+			RedisDatabase: redisDatabase,
+
+			WeatherProvider: weatherProvider,
+			WeatherAddress:  weatherAddress,
+			WeatherFixture:  weatherFixture,
+
+			Experiments:  experiments,
+			MoodRules:    moodRules,
+			AdminToken:   adminToken,
+			CursorSecret: cursorSecret,
+
+			SearchBackend:     searchBackend,
+			SearchAddress:     searchAddress,
+			SearchAPIKey:      searchAPIKey,
+			SearchIndexPrefix: searchIndexPrefix,
+
+			EmbeddingsProvider:      embeddingsProvider,
+			EmbeddingsAddress:       embeddingsAddress,
+			EmbeddingsImagesAddress: embeddingsImagesAddress,
 		}, dbConfig)
 		if err != nil {
 			logrus.Fatal(err)
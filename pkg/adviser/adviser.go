@@ -17,86 +17,365 @@
 package adviser
 
 import (
-	"github.com/parasource/papaya-api/pkg/database"
+	"context"
+	"fmt"
 	"github.com/parasource/papaya-api/pkg/database/models"
-	"github.com/parasource/papaya-api/pkg/gorse"
+	"github.com/parasource/papaya-api/pkg/experiments"
+	"github.com/parasource/papaya-api/pkg/season"
+	"github.com/parasource/papaya-api/pkg/weather"
+	"github.com/sirupsen/logrus"
+	"hash/fnv"
 	"math/rand"
 	"strconv"
+	"sync"
 	"time"
 )
 
 var instance *Adviser
 
-const feedWardrobeRecommendationTemplate = `select looks.* from looks
-    join look_items li on looks.id = li.look_id
-    right join users_wardrobe uw on li.wardrobe_item_id = uw.wardrobe_item_id
-               WHERE uw.user_id = ?
-                 AND looks.id NOT IN (SELECT saved_looks.look_id FROM saved_looks WHERE saved_looks.user_id = ?)
-                 AND looks.sex = ?
-                 AND looks.deleted_at IS NULL
-               	 AND looks.slug NOT IN ?
-               GROUP BY looks.id ORDER BY looks.id DESC LIMIT ? OFFSET ?;`
-
-const feedWardrobeRecommendationFallbackTemplate = `select looks.* from looks
-    join look_items li on looks.id = li.look_id
-    right join users_wardrobe uw on li.wardrobe_item_id = uw.wardrobe_item_id
-               WHERE uw.user_id = ?
-                 AND looks.id NOT IN (SELECT saved_looks.look_id FROM saved_looks WHERE saved_looks.user_id = ?)
-                 AND looks.sex = ?
-                 AND looks.deleted_at IS NULL
-               GROUP BY looks.id ORDER BY looks.id DESC LIMIT ? OFFSET ?;`
+const DefaultFeedWeights = "gorse=15,wardrobe=5,mood=4"
+
+// FeedPageTTL is how long a precomputed feed page lives,
+// if user didn't do anything to invalidate it earlier
+const FeedPageTTL = 10 * time.Minute
+
+// ServedTTL is how long looks served in a feed session
+// are remembered, so that they are not repeated
+const ServedTTL = 24 * time.Hour
+
+type Config struct {
+	// FeedWeights describes how much each source
+	// contributes to the feed, see ParseWeights
+	FeedWeights string
+	PageSize    int
+	// SimilarWeights are weights of LinearScore used
+	// to rank similar looks, see ParseWeights
+	SimilarWeights string
+	// Redis is optional, without it nothing is cached
+	// and only looks served lately in a session, that
+	// cursors carry, are skipped
+	Redis RedisConfig
+	// CursorSecret signs feed cursors, it should be the
+	// same on all instances
+	CursorSecret string
+}
 
 type Adviser struct {
-	cache *Cache
+	cfg          Config
+	blender      *Blender
+	cache        *Cache
+	similarScore ScoreFunc
+
+	// Blenders for feed experiment variants, keyed by weights
+	mu       sync.Mutex
+	blenders map[string]*Blender
+}
+
+func New(cfg Config) (*Adviser, error) {
+	if cfg.FeedWeights == "" {
+		cfg.FeedWeights = DefaultFeedWeights
+	}
+	if cfg.PageSize <= 0 {
+		cfg.PageSize = 20
+	}
+	if cfg.SimilarWeights == "" {
+		cfg.SimilarWeights = DefaultSimilarWeights
+	}
+
+	weights, err := ParseWeights(cfg.FeedWeights)
+	if err != nil {
+		return nil, err
+	}
+	blender, err := NewBlender(weights)
+	if err != nil {
+		return nil, err
+	}
+	similarWeights, err := ParseWeights(cfg.SimilarWeights)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.CursorSecret == "" {
+		logrus.Warnf("feed cursor secret is not set, cursors won't survive restarts")
+	}
+	SetCursorSecret(cfg.CursorSecret)
+
+	var cache *Cache
+	if cfg.Redis.Address != "" {
+		cache, err = NewCache(cfg.Redis)
+		if err != nil {
+			logrus.Errorf("error creating adviser cache, continuing without it: %v", err)
+			cache = nil
+		}
+	}
+	if cache == nil {
+		logrus.Warnf("adviser cache is disabled, seen looks won't be moved down the feed")
+	}
+
+	instance = &Adviser{
+		cfg:          cfg,
+		blender:      blender,
+		cache:        cache,
+		similarScore: LinearScore(similarWeights),
+		blenders:     make(map[string]*Blender),
+	}
+	return instance, nil
 }
 
 func Get() *Adviser {
 	if instance == nil {
-		instance = &Adviser{}
+		_, err := New(Config{})
+		if err != nil {
+			logrus.Fatalf("error creating adviser: %v", err)
+		}
 	}
 	return instance
 }
 
+// SetSimilarScore replaces the function similar looks are ranked with
+func (a *Adviser) SetSimilarScore(fn ScoreFunc) {
+	a.similarScore = fn
+}
+
+// Cache returns adviser's cache, which may be nil
+// if redis is not configured. It is still safe to use
+func (a *Adviser) Cache() *Cache {
+	return a.cache
+}
+
+// Feed returns a page of looks for clients, that still
+// paginate with page numbers
 func (a *Adviser) Feed(user *models.User, page int) ([]*models.Look, error) {
-	var looks []*models.Look
+	cursor := NewCursor()
+	cursor.Page = page
+	cursor.Offsets = nil
 
-	// So first we grab major part of page items from gorse
-	slugs, err := gorse.RecommendForUserAndCategory(strconv.Itoa(int(user.ID)), user.Sex, 15, 15*page)
+	result, err := a.buildFeedPage(user, cursor, nil, nil)
 	if err != nil {
 		return nil, err
 	}
-	err = database.DB().Where("slug in ?", slugs).Find(&looks).Error
+	return result.Looks, nil
+}
+
+type FeedPage struct {
+	Looks []*models.Look `json:"looks"`
+	Next  *Cursor        `json:"next"`
+	// Degraded is set when some of the sources were
+	// unavailable and the page was built from fallbacks
+	Degraded bool `json:"degraded"`
+	// Variant is user's variant of the feed experiment
+	Variant string `json:"variant,omitempty"`
+}
+
+// FeedPage returns looks for the page at cursor and the
+// cursor for the next one. Looks served earlier in the
+// same session are never repeated. Conditions are
+// optional weather at user's location
+func (a *Adviser) FeedPage(user *models.User, cursor *Cursor, conditions *weather.Conditions) (*FeedPage, error) {
+	ctx := context.Background()
+
+	variant := experiments.Get().Assign(user, experiments.Feed)
+
+	key, err := a.feedPageKey(ctx, user.ID, cursor, conditions, variant)
+	if err != nil {
+		logrus.Errorf("error getting feed cache version: %v", err)
+	}
+
+	var result FeedPage
+	err = a.cache.Remember(ctx, key, FeedPageTTL, &result, func() (interface{}, error) {
+		return a.buildFeedPage(user, cursor, conditions, variant)
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	// Then we need looks, which contain at least one item from user's wardrobe
-	var wardrobeLooks []*models.Look
+	ids := make([]interface{}, len(result.Looks))
+	for i, look := range result.Looks {
+		ids[i] = look.ID
+	}
+	err = a.cache.AddToSet(ctx, servedKey(user.ID, cursor.Seed), ServedTTL, ids...)
+	if err != nil {
+		logrus.Errorf("error remembering served looks: %v", err)
+	}
 
-	// This is the main scenario, but we need a fallback when there are no recommendation from gorse
-	if len(slugs) > 0 {
-		err = database.DB().Debug().Raw(feedWardrobeRecommendationTemplate, user.ID, user.ID, user.Sex, slugs, 5, 5*page).Scan(&wardrobeLooks).Error
-		if err != nil {
-			return nil, err
-		}
-	} else {
-		err = database.DB().Debug().Raw(feedWardrobeRecommendationFallbackTemplate, user.ID, user.ID, user.Sex, 20, 20*page).Scan(&wardrobeLooks).Error
-		if err != nil {
-			return nil, err
-		}
+	if result.Degraded {
+		// We don't want to keep serving fallbacks
+		// once sources are back
+		a.cache.Delete(ctx, key)
+		return &result, nil
+	}
+
+	// While user is looking at this page, we
+	// prepare the next one in background
+	if a.cache != nil && len(result.Looks) > 0 {
+		go a.precomputeFeedPage(user, result.Next, conditions, variant)
+	}
+
+	return &result, nil
+}
+
+// Popular returns popular looks for user's sex. If gorse
+// is unavailable, they are computed from our database
+// and the result is marked as degraded
+func (a *Adviser) Popular(user *models.User, n int) ([]*models.Look, bool, error) {
+	req := &Request{User: user}
+
+	looks, err := (&PopularSource{}).Recommend(req, n, 0)
+	if err == nil {
+		return found(looks), false, nil
+	}
+	logrus.Warnf("error getting popular looks, falling back to trending: %v", err)
+
+	looks, err = (&TrendingSource{}).Recommend(req, n, 0)
+	return looks, true, err
+}
+
+// InvalidateFeed drops all cached feed pages of user.
+// It should be called whenever user does something
+// that changes their recommendations
+func (a *Adviser) InvalidateFeed(userID uint) {
+	_, err := a.cache.Incr(context.Background(), feedVersionKey(userID))
+	if err != nil {
+		logrus.Errorf("error invalidating feed cache: %v", err)
+	}
+}
+
+func (a *Adviser) precomputeFeedPage(user *models.User, cursor *Cursor, conditions *weather.Conditions, variant *experiments.Variant) {
+	ctx := context.Background()
+
+	key, err := a.feedPageKey(ctx, user.ID, cursor, conditions, variant)
+	if err != nil {
+		return
+	}
+
+	var result FeedPage
+	err = a.cache.Remember(ctx, key, FeedPageTTL, &result, func() (interface{}, error) {
+		return a.buildFeedPage(user, cursor, conditions, variant)
+	})
+	if err != nil {
+		logrus.Errorf("error precomputing feed page: %v", err)
+		return
 	}
-	for _, look := range wardrobeLooks {
-		look.IsFromWardrobe = true
+	if result.Degraded {
+		a.cache.Delete(ctx, key)
 	}
+}
 
-	looks = append(looks, wardrobeLooks...)
+func (a *Adviser) buildFeedPage(user *models.User, cursor *Cursor, conditions *weather.Conditions, variant *experiments.Variant) (*FeedPage, error) {
+	blend, err := a.blenderFor(variant).Recommend(&Request{
+		User:    user,
+		Page:    cursor.Page,
+		Offsets: cursor.Offsets,
+		Exclude: a.served(user.ID, cursor),
+		Season:  season.ForUser(user),
+		Weather: conditions,
+	}, a.cfg.PageSize)
+	if err != nil {
+		return nil, err
+	}
+	looks := blend.Looks
 
-	// Random sorting for entropy
-	rand.Seed(time.Now().UnixNano())
+	// Random sorting for entropy, seeded per session and
+	// page so the same cursor always yields the same order
+	rnd := rand.New(rand.NewSource(cursor.Seed + int64(cursor.Page)))
 	for i := len(looks) - 1; i > 0; i-- { // Fisher–Yates shuffle
-		j := rand.Intn(i + 1)
+		j := rnd.Intn(i + 1)
 		looks[i], looks[j] = looks[j], looks[i]
 	}
 
-	return looks, nil
+	looks, err = boostForConditions(looks, season.ForUser(user), conditions)
+	if err != nil {
+		logrus.Errorf("error boosting feed looks for weather: %v", err)
+	}
+
+	// Looks user has already scrolled past go last
+	looks = a.downrankSeen(user.ID, looks)
+
+	page := &FeedPage{
+		Looks:    looks,
+		Next:     cursor.Next(blend.Offsets, lookIDs(looks)),
+		Degraded: blend.Degraded,
+	}
+	if variant != nil {
+		page.Variant = variant.Name
+	}
+	return page, nil
+}
+
+// served returns looks already served in the feed session,
+// the ones kept in cache and the ones the cursor carries
+func (a *Adviser) served(userID uint, cursor *Cursor) map[uint]struct{} {
+	members, err := a.cache.SetMembers(context.Background(), servedKey(userID, cursor.Seed))
+	if err != nil {
+		logrus.Errorf("error getting served looks: %v", err)
+	}
+
+	served := make(map[uint]struct{}, len(members)+len(cursor.Served))
+	for _, id := range cursor.Served {
+		served[id] = struct{}{}
+	}
+	for _, m := range members {
+		id, err := strconv.ParseUint(m, 10, 64)
+		if err == nil {
+			served[uint(id)] = struct{}{}
+		}
+	}
+	return served
+}
+
+// blenderFor returns blender with feed weights of the
+// variant, or the default one if it doesn't set any
+func (a *Adviser) blenderFor(variant *experiments.Variant) *Blender {
+	weights := variant.Param("feed_weights", "")
+	if weights == "" {
+		return a.blender
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if b, ok := a.blenders[weights]; ok {
+		return b
+	}
+
+	parsed, err := ParseWeights(weights)
+	if err == nil {
+		var b *Blender
+		b, err = NewBlender(parsed)
+		if err == nil {
+			a.blenders[weights] = b
+			return b
+		}
+	}
+	logrus.Errorf("invalid feed weights of variant %v, using default ones: %v", variant.Name, err)
+	a.blenders[weights] = a.blender
+	return a.blender
+}
+
+func (a *Adviser) feedPageKey(ctx context.Context, userID uint, cursor *Cursor, conditions *weather.Conditions, variant *experiments.Variant) (string, error) {
+	version, err := a.cache.Counter(ctx, feedVersionKey(userID))
+
+	h := fnv.New64a()
+	h.Write([]byte(cursor.Encode()))
+
+	// Pages only differ by weather when it's cold or wet
+	var cold, wet bool
+	if conditions != nil {
+		cold, wet = conditions.Cold(), conditions.Wet()
+	}
+
+	var variantName string
+	if variant != nil {
+		variantName = variant.Name
+	}
+
+	return fmt.Sprintf("feed:%v:%v:%x:%v:%v:%v", userID, version, h.Sum64(), cold, wet, variantName), err
+}
+
+func feedVersionKey(userID uint) string {
+	return fmt.Sprintf("feed:version:%v", userID)
+}
+
+func servedKey(userID uint, seed int64) string {
+	return fmt.Sprintf("feed:served:%v:%v", userID, seed)
 }
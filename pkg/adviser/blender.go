@@ -0,0 +1,302 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package adviser
+
+import (
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/parasource/papaya-api/pkg/season"
+	"github.com/sirupsen/logrus"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const feedExcludedLooksTemplate = `SELECT look_id FROM disliked_looks WHERE user_id = ?
+	UNION SELECT look_id FROM saved_looks WHERE user_id = ?`
+
+// sourceFallbacks are used instead of sources,
+// that depend on external services, when they fail
+var sourceFallbacks = map[string]string{
+	SourceGorse:   SourceTrending,
+	SourcePopular: SourceTrending,
+}
+
+type weightedSource struct {
+	source   Recommender
+	fallback Recommender
+	weight   float64
+}
+
+// Blender mixes looks from several sources according
+// to their weights, removes duplicates and filters out
+// looks user should not see
+type Blender struct {
+	sources []weightedSource
+}
+
+func NewBlender(weights map[string]float64) (*Blender, error) {
+	b := &Blender{}
+	for name, weight := range weights {
+		if weight <= 0 {
+			continue
+		}
+		source := NewSource(name)
+		if source == nil {
+			return nil, fmt.Errorf("unknown recommendation source: %v", name)
+		}
+		ws := weightedSource{source: source, weight: weight}
+		if fallback, ok := sourceFallbacks[name]; ok {
+			ws.fallback = NewSource(fallback)
+		}
+		b.sources = append(b.sources, ws)
+	}
+	if len(b.sources) == 0 {
+		return nil, fmt.Errorf("no recommendation sources configured")
+	}
+
+	// Heavier sources go first, so they win ties
+	sort.Slice(b.sources, func(i, j int) bool {
+		if b.sources[i].weight == b.sources[j].weight {
+			return b.sources[i].source.Name() < b.sources[j].source.Name()
+		}
+		return b.sources[i].weight > b.sources[j].weight
+	})
+
+	return b, nil
+}
+
+// maxBlendRounds limits how many times sources are asked for
+// more looks, when filtered out ones leave the page short
+const maxBlendRounds = 4
+
+// overFetch is how many times more looks than their share
+// sources are asked for, as some of them get filtered out
+const overFetch = 2
+
+// Blend is a page of looks and positions of
+// sources, the next page continues from
+type Blend struct {
+	Looks []*models.Look
+	// Offsets are numbers of looks taken from every source
+	// so far, fallbacks have their own offsets
+	Offsets map[string]int
+	// Degraded is set if some source failed and
+	// its fallback was used instead
+	Degraded bool
+}
+
+// Recommend returns up to limit looks starting at offsets of the
+// request. Sources are asked again, while filtered out looks
+// leave the page short and there are still looks to take
+func (b *Blender) Recommend(req *Request, limit int) (*Blend, error) {
+	err := b.loadExcluded(req)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Blend{Offsets: make(map[string]int)}
+	for name, offset := range req.Offsets {
+		result.Offsets[name] = offset
+	}
+
+	sources := b.applicable(req)
+	if len(sources) == 0 {
+		return result, nil
+	}
+
+	var total float64
+	for _, ws := range sources {
+		total += ws.weight
+	}
+
+	var (
+		lastErr   error
+		seen      = make(map[uint]*models.Look)
+		exhausted = make([]bool, len(sources))
+	)
+	for round := 0; len(result.Looks) < limit && round < maxBlendRounds; round++ {
+		var (
+			failed     int
+			active     int
+			missing    = limit - len(result.Looks)
+			names      = make([]string, len(sources))
+			candidates = make([][]*models.Look, len(sources))
+		)
+		for i, ws := range sources {
+			if exhausted[i] {
+				continue
+			}
+			share := ws.weight / total
+			quota := int(math.Ceil(share*float64(missing))) * overFetch
+
+			source := ws.source
+			looks, err := source.Recommend(req, quota, b.offset(req, result.Offsets, source.Name(), share, limit))
+			if err != nil && ws.fallback != nil {
+				logrus.Warnf("error getting looks from %v, falling back to %v: %v", source.Name(), ws.fallback.Name(), err)
+				result.Degraded = true
+				source = ws.fallback
+				looks, err = source.Recommend(req, quota, b.offset(req, result.Offsets, source.Name(), share, limit))
+			}
+			if err != nil {
+				logrus.Errorf("error getting looks from %v: %v", source.Name(), err)
+				result.Degraded = true
+				exhausted[i] = true
+				failed++
+				lastErr = err
+				continue
+			}
+
+			if len(looks) < quota {
+				exhausted[i] = true
+			}
+			names[i] = source.Name()
+			candidates[i] = looks
+			active++
+		}
+		if round == 0 && failed == len(sources) {
+			return nil, fmt.Errorf("all recommendation sources failed: %v", lastErr)
+		}
+		if active == 0 {
+			break
+		}
+
+		consumed := b.blend(req, sources, candidates, seen, result, limit)
+		for i, n := range consumed {
+			if names[i] != "" {
+				result.Offsets[names[i]] += n
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// offset returns where the source continues from. Clients paginating
+// with page numbers have no offsets, so they are estimated from the
+// share of the source
+func (b *Blender) offset(req *Request, offsets map[string]int, name string, share float64, limit int) int {
+	if offset, ok := offsets[name]; ok || req.Offsets != nil {
+		return offset
+	}
+	return int(math.Ceil(share*float64(limit))) * req.Page
+}
+
+func (b *Blender) applicable(req *Request) []weightedSource {
+	sources := make([]weightedSource, 0, len(b.sources))
+	for _, ws := range b.sources {
+		if c, ok := ws.source.(Conditional); ok && !c.Applicable(req) {
+			continue
+		}
+		sources = append(sources, ws)
+	}
+	return sources
+}
+
+// blend interleaves candidates with smooth weighted round-robin, so
+// that every source gets its share along the whole page. It returns
+// how many candidates of every source were used up, filtered out
+// and duplicate ones included
+func (b *Blender) blend(req *Request, sources []weightedSource, candidates [][]*models.Look, seen map[uint]*models.Look, result *Blend, limit int) []int {
+	var (
+		current = make([]float64, len(sources))
+		cursors = make([]int, len(sources))
+	)
+
+	for len(result.Looks) < limit {
+		var total float64
+		best := -1
+		for i, ws := range sources {
+			if cursors[i] >= len(candidates[i]) {
+				continue
+			}
+			current[i] += ws.weight
+			total += ws.weight
+			if best == -1 || current[i] > current[best] {
+				best = i
+			}
+		}
+		if best == -1 {
+			break
+		}
+		current[best] -= total
+
+		look := candidates[best][cursors[best]]
+		cursors[best]++
+		if look == nil {
+			continue
+		}
+
+		if kept, ok := seen[look.ID]; ok {
+			// Same look from another source, it's
+			// recommended for one more reason
+			for _, r := range look.Reasons {
+				kept.AddReason(r)
+			}
+			continue
+		}
+		if req.Excluded(look.ID) {
+			continue
+		}
+		if req.Season != "" && !season.Matches(look, req.Season) {
+			continue
+		}
+		seen[look.ID] = look
+		result.Looks = append(result.Looks, look)
+	}
+
+	return cursors
+}
+
+func (b *Blender) loadExcluded(req *Request) error {
+	var ids []uint
+	err := database.DB().Raw(feedExcludedLooksTemplate, req.User.ID, req.User.ID).Scan(&ids).Error
+	if err != nil {
+		return fmt.Errorf("error getting excluded looks: %v", err)
+	}
+
+	if req.Exclude == nil {
+		req.Exclude = make(map[uint]struct{}, len(ids))
+	}
+	for _, id := range ids {
+		req.Exclude[id] = struct{}{}
+	}
+	return nil
+}
+
+// ParseWeights parses weights in "source=weight,source=weight" format
+func ParseWeights(s string) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid weight format: %v", pair)
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid weight for %v: %v", parts[0], err)
+		}
+		weights[strings.TrimSpace(parts[0])] = weight
+	}
+	return weights, nil
+}
@@ -16,7 +16,26 @@
 
 package adviser
 
-import "github.com/go-redis/redis/v9"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-redis/redis/v9"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	cacheKeyPrefix = "papaya:"
+
+	// lockTTL is how long a single rebuild of a key may take,
+	// before other instances stop waiting and build it themselves
+	lockTTL      = 5 * time.Second
+	lockWait     = 50 * time.Millisecond
+	lockAttempts = 10
+)
 
 type RedisConfig struct {
 	Address  string
@@ -24,8 +43,20 @@ type RedisConfig struct {
 	Database int
 }
 
+// Cache is a redis backed cache. A nil *Cache is valid
+// and behaves as a cache that never has anything, so
+// callers don't need to check if redis is configured
 type Cache struct {
 	redis *redis.Client
+
+	mu    sync.Mutex
+	calls map[string]*cacheCall
+}
+
+type cacheCall struct {
+	wg   sync.WaitGroup
+	data []byte
+	err  error
 }
 
 func NewCache(conf RedisConfig) (*Cache, error) {
@@ -35,7 +66,197 @@ func NewCache(conf RedisConfig) (*Cache, error) {
 		DB:       conf.Database, // use default DB
 	})
 
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	err := rdb.Ping(ctx).Err()
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to redis: %v", err)
+	}
+
 	return &Cache{
 		redis: rdb,
+		calls: make(map[string]*cacheCall),
 	}, nil
 }
+
+// Get unmarshals cached value into dst and reports
+// whether the key was found
+func (c *Cache) Get(ctx context.Context, key string, dst interface{}) (bool, error) {
+	if c == nil {
+		return false, nil
+	}
+
+	data, err := c.redis.Get(ctx, cacheKeyPrefix+key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, json.Unmarshal(data, dst)
+}
+
+func (c *Cache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	if c == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return c.redis.Set(ctx, cacheKeyPrefix+key, data, jitter(ttl)).Err()
+}
+
+func (c *Cache) Delete(ctx context.Context, keys ...string) error {
+	if c == nil || len(keys) == 0 {
+		return nil
+	}
+
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = cacheKeyPrefix + key
+	}
+	return c.redis.Del(ctx, prefixed...).Err()
+}
+
+// Incr increments a counter, it's used to version
+// groups of keys, so they can be dropped at once
+func (c *Cache) Incr(ctx context.Context, key string) (int64, error) {
+	if c == nil {
+		return 0, nil
+	}
+	return c.redis.Incr(ctx, cacheKeyPrefix+key).Result()
+}
+
+// Counter returns the current value of a counter set by Incr
+func (c *Cache) Counter(ctx context.Context, key string) (int64, error) {
+	if c == nil {
+		return 0, nil
+	}
+
+	v, err := c.redis.Get(ctx, cacheKeyPrefix+key).Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return v, err
+}
+
+// AddToSet adds members to a set and resets its ttl
+func (c *Cache) AddToSet(ctx context.Context, key string, ttl time.Duration, members ...interface{}) error {
+	if c == nil || len(members) == 0 {
+		return nil
+	}
+
+	pipe := c.redis.TxPipeline()
+	pipe.SAdd(ctx, cacheKeyPrefix+key, members...)
+	pipe.Expire(ctx, cacheKeyPrefix+key, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// SetMembers returns all members of a set
+func (c *Cache) SetMembers(ctx context.Context, key string) ([]string, error) {
+	if c == nil {
+		return nil, nil
+	}
+	return c.redis.SMembers(ctx, cacheKeyPrefix+key).Result()
+}
+
+// Remember returns the cached value for key into dst, or builds it
+// with fn and caches it for ttl. Concurrent misses for the same key
+// are collapsed into a single fn call within this instance, and across
+// instances with a short redis lock, so an expired hot key doesn't
+// hit the database from every request at once
+func (c *Cache) Remember(ctx context.Context, key string, ttl time.Duration, dst interface{}, fn func() (interface{}, error)) error {
+	if c == nil {
+		return build(fn, dst)
+	}
+
+	found, err := c.Get(ctx, key, dst)
+	if err == nil && found {
+		return nil
+	}
+
+	c.mu.Lock()
+	if call, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		call.wg.Wait()
+		if call.err != nil {
+			return call.err
+		}
+		return json.Unmarshal(call.data, dst)
+	}
+	call := &cacheCall{}
+	call.wg.Add(1)
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	call.data, call.err = c.rebuild(ctx, key, ttl, fn)
+
+	c.mu.Lock()
+	delete(c.calls, key)
+	c.mu.Unlock()
+	call.wg.Done()
+
+	if call.err != nil {
+		return call.err
+	}
+	return json.Unmarshal(call.data, dst)
+}
+
+func (c *Cache) rebuild(ctx context.Context, key string, ttl time.Duration, fn func() (interface{}, error)) ([]byte, error) {
+	lockKey := cacheKeyPrefix + "lock:" + key
+
+	locked, err := c.redis.SetNX(ctx, lockKey, 1, lockTTL).Result()
+	if err == nil && !locked {
+		// Somebody else is building this key, so we
+		// wait a bit for them before doing it ourselves
+		for i := 0; i < lockAttempts; i++ {
+			<-time.After(lockWait)
+			data, err := c.redis.Get(ctx, cacheKeyPrefix+key).Bytes()
+			if err == nil {
+				return data, nil
+			}
+		}
+	}
+	if locked {
+		defer c.redis.Del(ctx, lockKey)
+	}
+
+	value, err := fn()
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	// Failing to write cache shouldn't fail the request
+	c.redis.Set(ctx, cacheKeyPrefix+key, data, jitter(ttl))
+
+	return data, nil
+}
+
+func build(fn func() (interface{}, error), dst interface{}) error {
+	value, err := fn()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, dst)
+}
+
+// jitter spreads expiration of keys, that were set at the
+// same time, by up to 10% so they don't expire all at once
+func jitter(ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		return ttl
+	}
+	return ttl + time.Duration(rand.Int63n(int64(ttl)/10+1))
+}
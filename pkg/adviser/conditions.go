@@ -0,0 +1,71 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package adviser
+
+import (
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/parasource/papaya-api/pkg/outfits"
+	"github.com/parasource/papaya-api/pkg/season"
+	"github.com/parasource/papaya-api/pkg/weather"
+	"sort"
+)
+
+// OuterwearCategories are values of WardrobeCategory.ParentCategory
+// we consider outerwear
+var OuterwearCategories = outfits.SlotCategories[outfits.SlotOuterwear]
+
+const outerwearLooksTemplate = `SELECT DISTINCT li.look_id FROM look_items li
+    JOIN wardrobe_items wi ON wi.id = li.wardrobe_item_id
+    JOIN wardrobe_categories wc ON wc.id = wi.wardrobe_category_id
+    WHERE li.look_id IN ? AND lower(wc.parent_category) IN ?`
+
+// boostForConditions moves looks made for the current season up,
+// and when it's cold or wet outside, looks with outerwear go first
+func boostForConditions(looks []*models.Look, s string, conditions *weather.Conditions) ([]*models.Look, error) {
+	if len(looks) == 0 {
+		return looks, nil
+	}
+
+	score := make(map[uint]int, len(looks))
+	for _, look := range looks {
+		if s != "" && season.Normalize(look.Season) == s {
+			score[look.ID]++
+		}
+	}
+
+	if conditions != nil && (conditions.Cold() || conditions.Wet()) {
+		ids := make([]uint, len(looks))
+		for i, look := range looks {
+			ids[i] = look.ID
+		}
+
+		var outerwear []uint
+		err := database.DB().Raw(outerwearLooksTemplate, ids, OuterwearCategories).Scan(&outerwear).Error
+		if err != nil {
+			return looks, err
+		}
+		for _, id := range outerwear {
+			score[id] += 2
+		}
+	}
+
+	sort.SliceStable(looks, func(i, j int) bool {
+		return score[looks[i].ID] > score[looks[j].ID]
+	})
+	return looks, nil
+}
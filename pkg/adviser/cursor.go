@@ -0,0 +1,127 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package adviser
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// cursorMacSize is how many bytes of the signature cursors carry
+const cursorMacSize = 16
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursorSecret signs cursors, so that clients can't forge them.
+// Until it's set, a random one is used, and cursors don't survive
+// restarts and can't be shared between instances
+var cursorSecret = randomSecret()
+
+// SetCursorSecret sets the key cursors are signed with
+func SetCursorSecret(secret string) {
+	if secret != "" {
+		cursorSecret = []byte(secret)
+	}
+}
+
+func randomSecret() []byte {
+	secret := make([]byte, 32)
+	_, _ = rand.Read(secret)
+	return secret
+}
+
+// maxCursorServed is how many looks served last a cursor
+// carries, older ones are only skipped by positions of sources
+const maxCursorServed = 200
+
+// Cursor is an opaque feed position passed between
+// client and server. It keeps the per-session seed,
+// so that the order of every page is stable, and
+// positions of sources. Looks already served in the
+// session are kept in cache by the seed, the latest
+// of them are carried along for when there is no cache
+type Cursor struct {
+	Seed    int64          `json:"s"`
+	Page    int            `json:"p"`
+	Offsets map[string]int `json:"o"`
+	Served  []uint         `json:"v,omitempty"`
+}
+
+func NewCursor() *Cursor {
+	return &Cursor{
+		Seed:    time.Now().UnixNano(),
+		Offsets: make(map[string]int),
+	}
+}
+
+func DecodeCursor(s string) (*Cursor, error) {
+	dot := strings.LastIndexByte(s, '.')
+	if dot < 0 {
+		return nil, ErrInvalidCursor
+	}
+	payload, signature := s[:dot], s[dot+1:]
+
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, sign(payload)) {
+		return nil, ErrInvalidCursor
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c Cursor
+	err = json.Unmarshal(data, &c)
+	if err != nil || c.Page < 0 {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+func (c *Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(sign(payload))
+}
+
+// Next returns the cursor for the page following
+// the one, that ended at offsets and served looks
+func (c *Cursor) Next(offsets map[string]int, served []uint) *Cursor {
+	all := make([]uint, 0, len(c.Served)+len(served))
+	all = append(append(all, c.Served...), served...)
+	if len(all) > maxCursorServed {
+		all = all[len(all)-maxCursorServed:]
+	}
+	return &Cursor{
+		Seed:    c.Seed,
+		Page:    c.Page + 1,
+		Offsets: offsets,
+		Served:  all,
+	}
+}
+
+func sign(payload string) []byte {
+	h := hmac.New(sha256.New, cursorSecret)
+	h.Write([]byte(payload))
+	return h.Sum(nil)[:cursorMacSize]
+}
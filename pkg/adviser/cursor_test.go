@@ -0,0 +1,84 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package adviser
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	c := NewCursor()
+	c.Offsets["gorse"] = 12
+	next := c.Next(map[string]int{"gorse": 30, "wardrobe": 4}, []uint{7, 3})
+
+	decoded, err := DecodeCursor(next.Encode())
+	if err != nil {
+		t.Fatalf("error decoding cursor: %v", err)
+	}
+	if decoded.Seed != c.Seed || decoded.Page != 1 {
+		t.Errorf("got seed %v page %v, want %v and 1", decoded.Seed, decoded.Page, c.Seed)
+	}
+	if decoded.Offsets["gorse"] != 30 || decoded.Offsets["wardrobe"] != 4 {
+		t.Errorf("got offsets %v", decoded.Offsets)
+	}
+	if len(decoded.Served) != 2 || decoded.Served[0] != 7 || decoded.Served[1] != 3 {
+		t.Errorf("got served %v", decoded.Served)
+	}
+}
+
+func TestCursorServed(t *testing.T) {
+	c := NewCursor()
+	for page := 0; page < 30; page++ {
+		served := make([]uint, 10)
+		for i := range served {
+			served[i] = uint(page*10 + i)
+		}
+		c = c.Next(nil, served)
+	}
+	// Only the latest looks are carried
+	if len(c.Served) != maxCursorServed || c.Served[0] != 100 || c.Served[len(c.Served)-1] != 299 {
+		t.Fatalf("got %v served looks from %v", len(c.Served), c.Served[0])
+	}
+
+	// They are skipped without redis
+	served := (&Adviser{}).served(1, c)
+	if _, ok := served[299]; !ok || len(served) != maxCursorServed {
+		t.Errorf("got %v served looks", len(served))
+	}
+}
+
+func TestCursorForged(t *testing.T) {
+	encoded := NewCursor().Encode()
+	payload, signature := encoded[:strings.LastIndexByte(encoded, '.')], encoded[strings.LastIndexByte(encoded, '.')+1:]
+
+	forged, _ := json.Marshal(&Cursor{Seed: 1, Page: 100})
+	tests := map[string]string{
+		"empty":        "",
+		"unsigned":     payload,
+		"bad base64":   payload + ".!!",
+		"other secret": payload + "." + base64.RawURLEncoding.EncodeToString([]byte("0123456789abcdef")),
+		"forged":       base64.RawURLEncoding.EncodeToString(forged) + "." + signature,
+	}
+	for name, s := range tests {
+		if _, err := DecodeCursor(s); err != ErrInvalidCursor {
+			t.Errorf("%v: got %v, want ErrInvalidCursor", name, err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package adviser
+
+import (
+	"context"
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/parasource/papaya-api/pkg/gorse"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// SeenTTL is how long we remember looks user has seen.
+// It's prolonged with every new impression
+const SeenTTL = 14 * 24 * time.Hour
+
+const impressionRollupSql = `INSERT INTO look_impression_rollups (date, look_id, surface, impressions, dwell_ms, position_sum)
+	VALUES (current_date, ?, ?, ?, ?, ?)
+	ON CONFLICT (date, look_id, surface) DO UPDATE
+	    SET impressions = look_impression_rollups.impressions + excluded.impressions,
+	        dwell_ms = look_impression_rollups.dwell_ms + excluded.dwell_ms,
+	        position_sum = look_impression_rollups.position_sum + excluded.position_sum`
+
+// Impression is a look shown to user on some surface of the app
+type Impression struct {
+	LookID   uint   `json:"look_id"`
+	Position int    `json:"position"`
+	DwellMs  int64  `json:"dwell_ms"`
+	Surface  string `json:"surface"`
+}
+
+// RecordImpressions remembers looks user has seen, so the feed
+// can move them down, counts them per day and tells gorse
+func (a *Adviser) RecordImpressions(user *models.User, impressions []Impression) error {
+	if len(impressions) == 0 {
+		return nil
+	}
+
+	type rollupKey struct {
+		lookID  uint
+		surface string
+	}
+	type rollup struct {
+		impressions int
+		dwellMs     int64
+		positionSum int64
+	}
+
+	rollups := make(map[rollupKey]*rollup)
+	seen := make(map[uint]struct{}, len(impressions))
+	for _, imp := range impressions {
+		key := rollupKey{imp.LookID, imp.Surface}
+		r, ok := rollups[key]
+		if !ok {
+			r = &rollup{}
+			rollups[key] = r
+		}
+		r.impressions++
+		r.dwellMs += imp.DwellMs
+		r.positionSum += int64(imp.Position)
+		seen[imp.LookID] = struct{}{}
+	}
+
+	members := make([]interface{}, 0, len(seen))
+	ids := make([]uint, 0, len(seen))
+	for id := range seen {
+		members = append(members, id)
+		ids = append(ids, id)
+	}
+
+	err := a.cache.AddToSet(context.Background(), seenKey(user.ID), SeenTTL, members...)
+	if err != nil {
+		logrus.Errorf("error remembering seen looks: %v", err)
+	}
+
+	err = database.DB().Transaction(func(tx *gorm.DB) error {
+		for key, r := range rollups {
+			err := tx.Exec(impressionRollupSql, key.lookID, key.surface, r.impressions, r.dwellMs, r.positionSum).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error rolling up impressions: %v", err)
+	}
+
+	// Gorse knows looks by their slugs
+	var slugs []string
+	err = database.DB().Model(&models.Look{}).Where("id IN ?", ids).Pluck("slug", &slugs).Error
+	if err != nil {
+		logrus.Warnf("error getting slugs of seen looks: %v", err)
+		return nil
+	}
+	err = gorse.ReadMany(strconv.Itoa(int(user.ID)), slugs)
+	if err != nil {
+		logrus.Warnf("error submitting impressions to gorse: %v", err)
+	}
+
+	return nil
+}
+
+// downrankSeen moves looks user has already seen to the end,
+// keeping the order within seen and unseen looks
+func (a *Adviser) downrankSeen(userID uint, looks []*models.Look) []*models.Look {
+	members, err := a.cache.SetMembers(context.Background(), seenKey(userID))
+	if err != nil {
+		logrus.Errorf("error getting seen looks: %v", err)
+		return looks
+	}
+	if len(members) == 0 {
+		return looks
+	}
+
+	seen := make(map[uint]bool, len(members))
+	for _, m := range members {
+		id, err := strconv.ParseUint(m, 10, 64)
+		if err == nil {
+			seen[uint(id)] = true
+		}
+	}
+
+	sort.SliceStable(looks, func(i, j int) bool {
+		return !seen[looks[i].ID] && seen[looks[j].ID]
+	})
+	return looks
+}
+
+func seenKey(userID uint) string {
+	return fmt.Sprintf("seen:%v", userID)
+}
@@ -0,0 +1,167 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package adviser
+
+import (
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"strings"
+)
+
+// Items of user's wardrobe used in the given looks
+const wardrobeReasonsSql = `SELECT li.look_id, wi.id AS item_id, wi.name AS item_name FROM look_items li
+    JOIN users_wardrobe uw ON uw.wardrobe_item_id = li.wardrobe_item_id AND uw.user_id = ?
+    JOIN wardrobe_items wi ON wi.id = li.wardrobe_item_id
+    WHERE li.look_id IN ?
+    ORDER BY li.look_id, wi.id`
+
+// Liked look sharing the most items with every given look
+const likedReasonsSql = `SELECT DISTINCT ON (li2.look_id) li2.look_id, ll.look_id AS liked_id, looks.name AS liked_name
+    FROM liked_looks ll
+        JOIN look_items li1 ON li1.look_id = ll.look_id
+        JOIN look_items li2 ON li2.wardrobe_item_id = li1.wardrobe_item_id AND li2.look_id <> ll.look_id
+        JOIN looks ON looks.id = ll.look_id AND looks.deleted_at IS NULL
+    WHERE ll.user_id = ? AND li2.look_id IN ?
+    GROUP BY li2.look_id, ll.look_id, looks.name
+    ORDER BY li2.look_id, count(*) DESC, ll.look_id DESC`
+
+// Followed topic every given look belongs to
+const topicReasonsSql = `SELECT DISTINCT ON (tl.look_id) tl.look_id, t.id AS topic_id, t.name AS topic_name
+    FROM topic_looks tl
+        JOIN saved_topics st ON st.topic_id = tl.topic_id AND st.user_id = ?
+        JOIN topics t ON t.id = tl.topic_id
+    WHERE tl.look_id IN ?
+    ORDER BY tl.look_id, t.id`
+
+// addReason adds the same reason to every look
+func addReason(looks []*models.Look, reason models.LookReason) {
+	for _, look := range looks {
+		r := reason
+		look.AddReason(&r)
+	}
+}
+
+func lookIDs(looks []*models.Look) []uint {
+	ids := make([]uint, len(looks))
+	for i, look := range looks {
+		ids[i] = look.ID
+	}
+	return ids
+}
+
+// addWardrobeReasons names user's items every look is made of
+func addWardrobeReasons(user *models.User, looks []*models.Look) error {
+	if len(looks) == 0 {
+		return nil
+	}
+
+	var rows []struct {
+		LookID   uint
+		ItemID   uint
+		ItemName string
+	}
+	err := database.DB().Raw(wardrobeReasonsSql, user.ID, lookIDs(looks)).Scan(&rows).Error
+	if err != nil {
+		return fmt.Errorf("error getting wardrobe reasons: %v", err)
+	}
+
+	reasons := make(map[uint]*models.LookReason)
+	names := make(map[uint][]string)
+	for _, row := range rows {
+		r, ok := reasons[row.LookID]
+		if !ok {
+			r = &models.LookReason{Type: models.ReasonWardrobe}
+			reasons[row.LookID] = r
+		}
+		r.ItemIDs = append(r.ItemIDs, row.ItemID)
+		names[row.LookID] = append(names[row.LookID], "«"+row.ItemName+"»")
+	}
+
+	for _, look := range looks {
+		if r, ok := reasons[look.ID]; ok {
+			r.Label = "Потому что у вас есть " + strings.Join(names[look.ID], ", ")
+			look.AddReason(r)
+		}
+	}
+	return nil
+}
+
+// addLikedReasons names the liked look every look
+// has the most in common with, if there is any
+func addLikedReasons(user *models.User, looks []*models.Look) error {
+	if len(looks) == 0 {
+		return nil
+	}
+
+	var rows []struct {
+		LookID    uint
+		LikedID   uint
+		LikedName string
+	}
+	err := database.DB().Raw(likedReasonsSql, user.ID, lookIDs(looks)).Scan(&rows).Error
+	if err != nil {
+		return fmt.Errorf("error getting liked reasons: %v", err)
+	}
+
+	byLook := make(map[uint]*models.LookReason, len(rows))
+	for _, row := range rows {
+		byLook[row.LookID] = &models.LookReason{
+			Type:   models.ReasonLiked,
+			LookID: row.LikedID,
+			Label:  fmt.Sprintf("Потому что вам понравился образ «%v»", row.LikedName),
+		}
+	}
+	for _, look := range looks {
+		if r, ok := byLook[look.ID]; ok {
+			look.AddReason(r)
+		}
+	}
+	return nil
+}
+
+// addTopicReasons names the followed topic every look belongs to
+func addTopicReasons(user *models.User, looks []*models.Look) error {
+	if len(looks) == 0 {
+		return nil
+	}
+
+	var rows []struct {
+		LookID    uint
+		TopicID   uint
+		TopicName string
+	}
+	err := database.DB().Raw(topicReasonsSql, user.ID, lookIDs(looks)).Scan(&rows).Error
+	if err != nil {
+		return fmt.Errorf("error getting topic reasons: %v", err)
+	}
+
+	byLook := make(map[uint]*models.LookReason, len(rows))
+	for _, row := range rows {
+		byLook[row.LookID] = &models.LookReason{
+			Type:    models.ReasonTopic,
+			TopicID: row.TopicID,
+			Label:   fmt.Sprintf("Из темы «%v», на которую вы подписаны", row.TopicName),
+		}
+	}
+	for _, look := range looks {
+		if r, ok := byLook[look.ID]; ok {
+			look.AddReason(r)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package adviser
+
+import (
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/parasource/papaya-api/pkg/weather"
+)
+
+// Request describes a single feed page we need to
+// recommend looks for
+type Request struct {
+	User *models.User
+	Page int
+	// Offsets are numbers of looks already taken from every
+	// source, nil for clients paginating with page numbers
+	Offsets map[string]int
+	// Exclude holds look ids that must never be returned,
+	// for example disliked or already seen looks
+	Exclude map[uint]struct{}
+	// Season is the current season at user's location,
+	// looks for other seasons are filtered out
+	Season string
+	// Weather is optional weather at user's location
+	Weather *weather.Conditions
+}
+
+func (r *Request) Excluded(id uint) bool {
+	if r.Exclude == nil {
+		return false
+	}
+	_, ok := r.Exclude[id]
+	return ok
+}
+
+// Recommender is a single source of looks for the feed.
+// Sources are composed together by the Blender. Looks
+// may hold nil for candidates, that can't be served,
+// so that offsets of the source still count them
+type Recommender interface {
+	Name() string
+	Recommend(req *Request, n int, offset int) ([]*models.Look, error)
+}
+
+// Conditional is implemented by sources, that only make sense
+// for some users. Blender gives their share of the page to
+// other sources, when they are not applicable
+type Conditional interface {
+	Applicable(req *Request) bool
+}
@@ -0,0 +1,250 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package adviser
+
+import (
+	"context"
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/parasource/papaya-api/pkg/gorse"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"time"
+)
+
+const (
+	DefaultSimilarWeights = "items=3,categories=2,topics=1,neighbors=4"
+
+	// SimilarCacheTTL is how long similar looks are kept
+	// per look. They only change when content is edited
+	SimilarCacheTTL = time.Hour
+	// SimilarDegradedTTL is how long similar looks ranked
+	// without gorse are kept, so that neighbors are back soon
+	SimilarDegradedTTL = time.Minute
+	// similarPopularFactor is how many more popular looks are
+	// fetched to fill similar ones, some are excluded
+	similarPopularFactor = 3
+
+	similarCandidatesLimit = 200
+)
+
+// Counts of wardrobe items, categories and topics
+// candidate look shares with the original one
+const similarLooksTemplate = `select s.look_id,
+       sum(s.items) as items, sum(s.categories) as categories, sum(s.topics) as topics
+    from (
+        select li2.look_id, count(*) as items, 0 as categories, 0 as topics
+            from look_items li1 join look_items li2 on li1.wardrobe_item_id = li2.wardrobe_item_id
+            where li1.look_id = ? and li2.look_id <> li1.look_id
+            group by li2.look_id
+        union all
+        select lc2.look_id, 0, count(*), 0
+            from look_categories lc1 join look_categories lc2 on lc1.category_id = lc2.category_id
+            where lc1.look_id = ? and lc2.look_id <> lc1.look_id
+            group by lc2.look_id
+        union all
+        select tl2.look_id, 0, 0, count(*)
+            from topic_looks tl1 join topic_looks tl2 on tl1.topic_id = tl2.topic_id
+            where tl1.look_id = ? and tl2.look_id <> tl1.look_id
+            group by tl2.look_id
+    ) s join looks on looks.id = s.look_id
+    WHERE looks.sex = ?
+      AND looks.deleted_at IS NULL
+    GROUP BY s.look_id
+    ORDER BY items DESC, categories DESC, topics DESC
+    LIMIT ?;`
+
+// SimilarityFeatures describes how much a candidate
+// look has in common with the original one
+type SimilarityFeatures struct {
+	LookID     uint    `json:"look_id"`
+	Items      int     `json:"items"`
+	Categories int     `json:"categories"`
+	Topics     int     `json:"topics"`
+	Neighbor   float64 `json:"neighbor"`
+}
+
+// ScoreFunc ranks a candidate, the higher the more similar
+type ScoreFunc func(f SimilarityFeatures) float64
+
+// LinearScore returns a ScoreFunc that sums features
+// multiplied by weights named items, categories,
+// topics and neighbors
+func LinearScore(weights map[string]float64) ScoreFunc {
+	return func(f SimilarityFeatures) float64 {
+		return weights["items"]*float64(f.Items) +
+			weights["categories"]*float64(f.Categories) +
+			weights["topics"]*float64(f.Topics) +
+			weights["neighbors"]*f.Neighbor
+	}
+}
+
+type scoredLook struct {
+	ID    uint    `json:"id"`
+	Score float64 `json:"score"`
+}
+
+type similarRanking struct {
+	Looks []scoredLook `json:"looks"`
+	// Degraded is set when gorse neighbors were unavailable
+	Degraded bool `json:"degraded"`
+}
+
+// Similar returns up to n looks similar to the given one,
+// excluding looks user has disliked. If there are not
+// enough of them, popular looks fill the rest
+func (a *Adviser) Similar(user *models.User, look *models.Look, n int) ([]*models.Look, error) {
+	ctx := context.Background()
+
+	var ranking similarRanking
+	ranked := false
+	key := fmt.Sprintf("similar:v2:%v:%v", look.ID, user.Sex)
+	err := a.cache.Remember(ctx, key, SimilarCacheTTL, &ranking, func() (interface{}, error) {
+		ranked = true
+		return a.rankSimilar(look, user.Sex)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if ranked && ranking.Degraded {
+		err = a.cache.Set(ctx, key, &ranking, SimilarDegradedTTL)
+		if err != nil {
+			logrus.Errorf("error shortening similar looks ttl: %v", err)
+		}
+	}
+
+	var disliked []uint
+	err = database.DB().Raw("SELECT look_id FROM disliked_looks WHERE user_id = ?", user.ID).Scan(&disliked).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting disliked looks: %v", err)
+	}
+	exclude := map[uint]struct{}{look.ID: {}}
+	for _, id := range disliked {
+		exclude[id] = struct{}{}
+	}
+
+	var ids []uint
+	for _, s := range ranking.Looks {
+		if len(ids) == n {
+			break
+		}
+		if _, ok := exclude[s.ID]; ok {
+			continue
+		}
+		ids = append(ids, s.ID)
+	}
+
+	var looks []*models.Look
+	if len(ids) > 0 {
+		err = database.DB().Where("id in ?", ids).Find(&looks).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	byID := make(map[uint]*models.Look, len(looks))
+	for _, l := range looks {
+		byID[l.ID] = l
+	}
+	result := make([]*models.Look, 0, n)
+	for _, id := range ids {
+		if l, ok := byID[id]; ok {
+			result = append(result, l)
+			exclude[id] = struct{}{}
+		}
+	}
+
+	// Looks with nothing in common with others still get
+	// something to show
+	if len(result) < n {
+		popular, _, err := a.Popular(user, similarPopularFactor*n)
+		if err != nil {
+			logrus.Errorf("error getting popular looks for similar: %v", err)
+		}
+		for _, l := range popular {
+			if len(result) == n {
+				break
+			}
+			if _, ok := exclude[l.ID]; ok {
+				continue
+			}
+			exclude[l.ID] = struct{}{}
+			result = append(result, l)
+		}
+	}
+	return result, nil
+}
+
+func (a *Adviser) rankSimilar(look *models.Look, sex string) (*similarRanking, error) {
+	var features []SimilarityFeatures
+	err := database.DB().Raw(similarLooksTemplate, look.ID, look.ID, look.ID, sex, similarCandidatesLimit).Scan(&features).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting similar looks candidates: %v", err)
+	}
+
+	byID := make(map[uint]SimilarityFeatures, len(features))
+	for _, f := range features {
+		byID[f.LookID] = f
+	}
+
+	// Gorse neighbors are optional, similar looks
+	// still work from our own data without them
+	ranking := &similarRanking{}
+	neighbors, err := gorse.ItemNeighbors(look.Slug, similarCandidatesLimit/4)
+	if err != nil {
+		logrus.Warnf("error getting look neighbors from gorse: %v", err)
+		ranking.Degraded = true
+	}
+	if len(neighbors) > 0 {
+		slugs := make([]string, 0, len(neighbors))
+		scores := make(map[string]float64, len(neighbors))
+		for _, n := range neighbors {
+			slugs = append(slugs, n.ID)
+			scores[n.ID] = n.Score
+		}
+
+		var neighborLooks []*models.Look
+		err = database.DB().Select("id", "slug").Where("slug in ?", slugs).Where("sex = ?", sex).Find(&neighborLooks).Error
+		if err != nil {
+			return nil, fmt.Errorf("error getting neighbor looks: %v", err)
+		}
+		for _, l := range neighborLooks {
+			f := byID[l.ID]
+			f.LookID = l.ID
+			f.Neighbor = scores[l.Slug]
+			byID[l.ID] = f
+		}
+	}
+
+	ranked := make([]scoredLook, 0, len(byID))
+	for id, f := range byID {
+		if id == look.ID {
+			continue
+		}
+		ranked = append(ranked, scoredLook{ID: id, Score: a.similarScore(f)})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Score == ranked[j].Score {
+			return ranked[i].ID > ranked[j].ID
+		}
+		return ranked[i].Score > ranked[j].Score
+	})
+
+	ranking.Looks = ranked
+	return ranking, nil
+}
@@ -0,0 +1,303 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package adviser
+
+import (
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/parasource/papaya-api/pkg/gorse"
+	"github.com/parasource/papaya-api/pkg/mood"
+	"github.com/sirupsen/logrus"
+	"strconv"
+	"strings"
+)
+
+const (
+	SourceGorse    = "gorse"
+	SourceWardrobe = "wardrobe"
+	SourcePopular  = "popular"
+	SourceFresh    = "fresh"
+	SourceTopics   = "topics"
+	SourceMood     = "mood"
+	SourceTrending = "trending"
+)
+
+const feedWardrobeRecommendationTemplate = `select looks.* from looks
+    join look_items li on looks.id = li.look_id
+    right join users_wardrobe uw on li.wardrobe_item_id = uw.wardrobe_item_id
+               WHERE uw.user_id = ?
+                 AND looks.id NOT IN (SELECT saved_looks.look_id FROM saved_looks WHERE saved_looks.user_id = ?)
+                 AND looks.id NOT IN (SELECT disliked_looks.look_id FROM disliked_looks WHERE disliked_looks.user_id = ?)
+                 AND looks.sex = ?
+                 AND looks.deleted_at IS NULL
+               GROUP BY looks.id ORDER BY looks.id DESC LIMIT ? OFFSET ?;`
+
+const feedTopicsRecommendationTemplate = `select looks.* from looks
+    join topic_looks tl on looks.id = tl.look_id
+    join saved_topics st on tl.topic_id = st.topic_id
+               WHERE st.user_id = ?
+                 AND looks.sex = ?
+                 AND looks.deleted_at IS NULL
+               GROUP BY looks.id ORDER BY looks.id DESC LIMIT ? OFFSET ?;`
+
+// Likes and saves are weighted down by look's age,
+// so fresh popular looks go before old popular ones
+const feedTrendingRecommendationTemplate = `select looks.* from looks
+    left join (
+        select look_id, count(*) as c from liked_looks group by look_id
+        union all
+        select look_id, count(*) * 2 as c from saved_looks group by look_id
+    ) p on p.look_id = looks.id
+               WHERE looks.sex = ?
+                 AND looks.deleted_at IS NULL
+               GROUP BY looks.id
+               ORDER BY coalesce(sum(p.c), 0) / power(extract(epoch from now() - looks.created_at) / 86400 + 2, 1.5) DESC, looks.id DESC
+               LIMIT ? OFFSET ?;`
+
+var popularReason = models.LookReason{Type: models.ReasonPopular, Label: "Популярное"}
+
+// NewSource returns a recommender by its name,
+// or nil if there is no such source
+func NewSource(name string) Recommender {
+	switch name {
+	case SourceGorse:
+		return &GorseSource{}
+	case SourceWardrobe:
+		return &WardrobeSource{}
+	case SourcePopular:
+		return &PopularSource{}
+	case SourceFresh:
+		return &FreshSource{}
+	case SourceTopics:
+		return &TopicsSource{}
+	case SourceMood:
+		return &MoodSource{}
+	case SourceTrending:
+		return &TrendingSource{}
+	}
+	return nil
+}
+
+// GorseSource returns personal recommendations from gorse
+type GorseSource struct{}
+
+func (s *GorseSource) Name() string {
+	return SourceGorse
+}
+
+func (s *GorseSource) Recommend(req *Request, n int, offset int) ([]*models.Look, error) {
+	slugs, err := gorse.RecommendForUserAndCategory(strconv.Itoa(int(req.User.ID)), req.User.Sex, n, offset)
+	if err != nil {
+		return nil, err
+	}
+	candidates, err := looksBySlugs(slugs)
+	if err != nil {
+		return nil, err
+	}
+	looks := found(candidates)
+
+	// Gorse doesn't tell why it recommends a look, so
+	// we look for a liked look it has most in common with
+	err = addLikedReasons(req.User, looks)
+	if err != nil {
+		logrus.Warnf("error explaining gorse recommendations: %v", err)
+	}
+	for _, look := range looks {
+		if len(look.Reasons) == 0 {
+			look.AddReason(&models.LookReason{Type: models.ReasonGorse, Label: "Подобрали для вас"})
+		}
+	}
+	return candidates, nil
+}
+
+// WardrobeSource returns looks, which contain at
+// least one item from user's wardrobe
+type WardrobeSource struct{}
+
+func (s *WardrobeSource) Name() string {
+	return SourceWardrobe
+}
+
+func (s *WardrobeSource) Recommend(req *Request, n int, offset int) ([]*models.Look, error) {
+	var looks []*models.Look
+	err := database.DB().Raw(feedWardrobeRecommendationTemplate, req.User.ID, req.User.ID, req.User.ID, req.User.Sex, n, offset).Scan(&looks).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, look := range looks {
+		look.IsFromWardrobe = true
+	}
+	err = addWardrobeReasons(req.User, looks)
+	if err != nil {
+		logrus.Warnf("error explaining wardrobe recommendations: %v", err)
+	}
+	return looks, nil
+}
+
+// PopularSource returns looks, that are popular among
+// users of the same sex
+type PopularSource struct{}
+
+func (s *PopularSource) Name() string {
+	return SourcePopular
+}
+
+func (s *PopularSource) Recommend(req *Request, n int, offset int) ([]*models.Look, error) {
+	// Gorse doesn't paginate popular items, so we
+	// request everything up to the page and cut it
+	slugs, err := gorse.RecommendPopular(req.User.Sex, offset+n)
+	if err != nil {
+		return nil, err
+	}
+	if offset >= len(slugs) {
+		return nil, nil
+	}
+	candidates, err := looksBySlugs(slugs[offset:])
+	if err != nil {
+		return nil, err
+	}
+	addReason(found(candidates), popularReason)
+	return candidates, nil
+}
+
+// TrendingSource returns recently popular looks computed from
+// likes and saves in our database. It doesn't depend on gorse,
+// so it's used as a fallback when gorse is unavailable
+type TrendingSource struct{}
+
+func (s *TrendingSource) Name() string {
+	return SourceTrending
+}
+
+func (s *TrendingSource) Recommend(req *Request, n int, offset int) ([]*models.Look, error) {
+	var looks []*models.Look
+	err := database.DB().Raw(feedTrendingRecommendationTemplate, req.User.Sex, n, offset).Scan(&looks).Error
+	if err != nil {
+		return nil, err
+	}
+	addReason(looks, popularReason)
+	return looks, nil
+}
+
+// FreshSource returns the most recently published looks
+type FreshSource struct{}
+
+func (s *FreshSource) Name() string {
+	return SourceFresh
+}
+
+func (s *FreshSource) Recommend(req *Request, n int, offset int) ([]*models.Look, error) {
+	var looks []*models.Look
+	err := database.DB().Where("sex = ?", req.User.Sex).Order("created_at DESC").Limit(n).Offset(offset).Find(&looks).Error
+	if err != nil {
+		return nil, err
+	}
+	addReason(looks, models.LookReason{Type: models.ReasonNew, Label: "Новинка"})
+	return looks, nil
+}
+
+// TopicsSource returns looks from topics user follows
+type TopicsSource struct{}
+
+func (s *TopicsSource) Name() string {
+	return SourceTopics
+}
+
+func (s *TopicsSource) Recommend(req *Request, n int, offset int) ([]*models.Look, error) {
+	var looks []*models.Look
+	err := database.DB().Raw(feedTopicsRecommendationTemplate, req.User.ID, req.User.Sex, n, offset).Scan(&looks).Error
+	if err != nil {
+		return nil, err
+	}
+	err = addTopicReasons(req.User, looks)
+	if err != nil {
+		logrus.Warnf("error explaining topics recommendations: %v", err)
+	}
+	return looks, nil
+}
+
+// MoodSource returns looks matching user's current mood
+type MoodSource struct{}
+
+func (s *MoodSource) Name() string {
+	return SourceMood
+}
+
+func (s *MoodSource) Applicable(req *Request) bool {
+	return mood.Get(req.User.Mood) != nil
+}
+
+func (s *MoodSource) Recommend(req *Request, n int, offset int) ([]*models.Look, error) {
+	m := mood.Get(req.User.Mood)
+	if m == nil {
+		return nil, nil
+	}
+
+	filter, args := m.LookFilter()
+	args = append(args, req.User.Sex, n, offset)
+
+	var looks []*models.Look
+	err := database.DB().Raw(`SELECT looks.* FROM looks WHERE `+filter+`
+		AND looks.sex = ? AND looks.deleted_at IS NULL
+		ORDER BY looks.id DESC LIMIT ? OFFSET ?`, args...).Scan(&looks).Error
+	if err != nil {
+		return nil, err
+	}
+	addReason(looks, models.LookReason{
+		Type:  models.ReasonMood,
+		Mood:  m.Slug,
+		Label: fmt.Sprintf("Под ваше настроение: %v", strings.ToLower(m.Name)),
+	})
+	return looks, nil
+}
+
+// looksBySlugs loads looks keeping the order of slugs. Looks
+// missing from the database are left nil, so that offsets
+// of sources paging by slugs move past them
+func looksBySlugs(slugs []string) ([]*models.Look, error) {
+	if len(slugs) == 0 {
+		return nil, nil
+	}
+
+	var looks []*models.Look
+	err := database.DB().Where("slug in ?", slugs).Find(&looks).Error
+	if err != nil {
+		return nil, err
+	}
+
+	bySlug := make(map[string]*models.Look, len(looks))
+	for _, look := range looks {
+		bySlug[look.Slug] = look
+	}
+	ordered := make([]*models.Look, len(slugs))
+	for i, slug := range slugs {
+		ordered[i] = bySlug[slug]
+	}
+	return ordered, nil
+}
+
+// found returns looks skipping missing ones
+func found(looks []*models.Look) []*models.Look {
+	present := make([]*models.Look, 0, len(looks))
+	for _, look := range looks {
+		if look != nil {
+			present = append(present, look)
+		}
+	}
+	return present
+}
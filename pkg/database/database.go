@@ -17,10 +17,13 @@
 package database
 
 import (
+	"fmt"
 	"github.com/parasource/papaya-api/pkg/database/models"
 	"github.com/sirupsen/logrus"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"regexp"
+	"strings"
 	"time"
 )
 
@@ -35,9 +38,62 @@ const (
 	CREATE INDEX IF NOT EXISTS idx_tsv_topics ON topics USING gin(tsv);
 	CREATE INDEX IF NOT EXISTS idx_tsv_wardrobe_items ON wardrobe_items USING gin(tsv);
 
+	ALTER TABLE today_looks ADD COLUMN IF NOT EXISTS sex text;
+
 	CREATE INDEX IF NOT EXISTS idx_search_records ON search_records (lower(query) text_pattern_ops);
+	CREATE INDEX IF NOT EXISTS idx_search_records_created_at ON search_records (created_at);
 	CREATE INDEX IF NOT EXISTS idx_wardrobe_items_name ON wardrobe_items (lower(wardrobe_items.name) text_pattern_ops);
 
+	/* ------------------ */
+	/* TYPO TOLERANCE     */
+
+	CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+	CREATE INDEX IF NOT EXISTS idx_trgm_looks_name ON looks USING gin (lower(name) gin_trgm_ops);
+	CREATE INDEX IF NOT EXISTS idx_trgm_topics_name ON topics USING gin (lower(name) gin_trgm_ops);
+	CREATE INDEX IF NOT EXISTS idx_trgm_wardrobe_items_name ON wardrobe_items USING gin (lower(name) gin_trgm_ops);
+	CREATE INDEX IF NOT EXISTS idx_trgm_brands_name ON brands USING gin (lower(name) gin_trgm_ops);
+
+	/* ------------------ */
+	/* MATERIALISED VIEWS */
+
+	--- Number of items in every look, used for wardrobe insights
+	CREATE MATERIALIZED VIEW IF NOT EXISTS look_item_counts AS
+	SELECT looks.id AS look_id, looks.sex, lower(coalesce(looks.season, '')) AS season, count(li.wardrobe_item_id) AS items
+	FROM looks JOIN look_items li ON li.look_id = looks.id
+	WHERE looks.deleted_at IS NULL
+	GROUP BY looks.id;
+
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_look_item_counts ON look_item_counts (look_id);
+	CREATE INDEX IF NOT EXISTS idx_look_item_counts_sex ON look_item_counts (sex);
+
+	--- Words of searchable names, misspelled queries are corrected to them
+	CREATE MATERIALIZED VIEW IF NOT EXISTS search_words AS
+	SELECT word, ndoc FROM ts_stat($$
+	    SELECT to_tsvector('simple', name) FROM wardrobe_items
+	    UNION ALL SELECT to_tsvector('simple', name) FROM looks WHERE deleted_at IS NULL
+	    UNION ALL SELECT to_tsvector('simple', name) FROM topics WHERE deleted_at IS NULL
+	    UNION ALL SELECT to_tsvector('simple', name) FROM brands WHERE deleted_at IS NULL
+	$$)
+	WHERE length(word) >= 3;
+
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_search_words ON search_words (word);
+	CREATE INDEX IF NOT EXISTS idx_trgm_search_words ON search_words USING gin (word gin_trgm_ops);
+
+	--- Queries of the last month by sex of users searching them. Score decays
+	--- exponentially, so a search counts half as much every three days
+	CREATE MATERIALIZED VIEW IF NOT EXISTS search_trends AS
+	SELECT sr.query, coalesce(u.sex, '') AS sex,
+	    sum(exp(ln(0.5) * extract(epoch FROM now() - sr.created_at) / 259200)) AS score,
+	    count(DISTINCT sr.user_id) AS users
+	FROM search_records sr JOIN users u ON u.id = sr.user_id
+	WHERE sr.created_at > now() - interval '30 day' AND sr.deleted_at IS NULL
+	    AND coalesce(sr.results, 1) > 0 AND length(sr.query) BETWEEN 2 AND 100
+	GROUP BY sr.query, coalesce(u.sex, '');
+
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_search_trends ON search_trends (query, sex);
+	CREATE INDEX IF NOT EXISTS idx_search_trends_prefix ON search_trends (query text_pattern_ops);
+
 	/* ------------------- */
 	/* UPDATE TSV TRIGGERS */
 
@@ -137,19 +193,49 @@ func New(cfg Config) error {
 		logrus.Fatalf("error migrating: %v", err)
 	}
 
-	// Running database setup script
-	err = db.Exec(setupScript).Error
-	if err != nil {
-		logrus.Errorf("error running sql setup script: %v", err)
-	} else {
-		logrus.Infof("database setup script run successfully")
+	// Running database setup script one statement at
+	// a time, so that we know which one has failed
+	for _, statement := range splitStatements(setupScript) {
+		err = db.Exec(statement).Error
+		if err != nil {
+			return fmt.Errorf("error running sql setup statement %q: %v", firstLine(statement), err)
+		}
 	}
+	logrus.Infof("database setup script run successfully")
 
 	conn = db
 
 	return nil
 }
 
+// statementEnd is a semicolon ending a line, statements
+// of setup scripts never have one inside
+var statementEnd = regexp.MustCompile(`;[ \t]*(\n|$)`)
+
+// splitStatements splits sql script into statements,
+// leaving out the ones with nothing but comments
+func splitStatements(script string) []string {
+	var statements []string
+	for _, statement := range statementEnd.Split(script, -1) {
+		if firstLine(statement) != "" {
+			statements = append(statements, strings.TrimSpace(statement))
+		}
+	}
+	return statements
+}
+
+// firstLine returns the first line of statement,
+// that is not a comment
+func firstLine(statement string) string {
+	for _, line := range strings.Split(statement, "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "--") && !strings.HasPrefix(line, "/*") {
+			return line
+		}
+	}
+	return ""
+}
+
 func DB() *gorm.DB {
 	return conn
 }
@@ -180,6 +266,13 @@ func GetUser(id uint) *models.User {
 	return &user
 }
 
+// IsTimezone reports whether postgres knows the time zone
+func IsTimezone(name string) (bool, error) {
+	var known bool
+	err := conn.Raw("SELECT EXISTS (SELECT 1 FROM pg_timezone_names WHERE name = ?)", name).Scan(&known).Error
+	return known, err
+}
+
 func CreateUser(user *models.User) {
 	conn.Create(user)
 }
@@ -197,6 +290,16 @@ func migrate(db *gorm.DB) error {
 		&models.Article{},
 		&models.Alert{},
 		&models.EmailSubscription{},
+		&models.TodayLookHistory{},
+		&models.Experiment{},
+		&models.ExperimentVariant{},
+		&models.ExperimentExposure{},
+		&models.Event{},
+		&models.EventDailyRollup{},
+		&models.LookImpressionRollup{},
+		&models.SearchSynonymGroup{},
+		&models.SearchSynonym{},
+		&models.SearchBlockedTerm{},
 	)
 	if err != nil {
 		return err
@@ -0,0 +1,62 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package database
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	script := `
+	/* ------ */
+	/* TABLES */
+
+	CREATE TABLE a (id int);
+	--- Second one
+	CREATE TABLE b (
+	    id int
+	);
+	CREATE MATERIALIZED VIEW c AS SELECT * FROM ts_stat($$ SELECT 'a;b' $$)
+	`
+	statements := splitStatements(script)
+	if len(statements) != 3 {
+		t.Fatalf("got %v statements, want 3: %q", len(statements), statements)
+	}
+	if statements[0] != "/* ------ */\n\t/* TABLES */\n\n\tCREATE TABLE a (id int)" {
+		t.Errorf("got first statement %q", statements[0])
+	}
+	if firstLine(statements[1]) != "CREATE TABLE b (" {
+		t.Errorf("got second statement %q", statements[1])
+	}
+	if !strings.HasSuffix(statements[2], "$$)") {
+		t.Errorf("got third statement %q", statements[2])
+	}
+}
+
+func TestSplitSetupScript(t *testing.T) {
+	for _, statement := range splitStatements(setupScript) {
+		line := firstLine(statement)
+		if !strings.HasPrefix(line, "CREATE") && !strings.HasPrefix(line, "DROP") &&
+			!strings.HasPrefix(line, "UPDATE") && !strings.HasPrefix(line, "ALTER") {
+			t.Errorf("statement starts with %q", line)
+		}
+		if strings.HasSuffix(statement, ";") {
+			t.Errorf("statement %q is not split", line)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package models
+
+import "time"
+
+// Event is a single user interaction. Events
+// are append-only and never updated
+type Event struct {
+	ID         uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt  time.Time `json:"created_at" gorm:"index;index:idx_events_user_created,priority:2"`
+	UserID     uint      `json:"user_id" gorm:"index;index:idx_events_user_created,priority:1"`
+	Type       string    `json:"type" gorm:"index"`
+	EntityType string    `json:"entity_type"`
+	EntityID   uint      `json:"entity_id"`
+	// Context is a json object with anything
+	// else worth knowing about the event
+	Context    string `json:"context" gorm:"type:jsonb;default:'{}'"`
+	Platform   string `json:"platform"`
+	AppVersion string `json:"app_version"`
+}
+
+// EventDailyRollup is the number of events and distinct users per
+// day, type and entity. Type "*" with no entity counts all events
+type EventDailyRollup struct {
+	Date       time.Time `json:"date" gorm:"type:date;primaryKey"`
+	Type       string    `json:"type" gorm:"primaryKey"`
+	EntityType string    `json:"entity_type" gorm:"primaryKey"`
+	EntityID   uint      `json:"entity_id" gorm:"primaryKey;autoIncrement:false"`
+	Events     int       `json:"events"`
+	Users      int       `json:"users"`
+}
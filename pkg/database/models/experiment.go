@@ -0,0 +1,52 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+type Experiment struct {
+	gorm.Model
+	Key string `json:"key" gorm:"uniqueIndex"`
+	// Salt makes bucketing of different experiments independent,
+	// changing it reshuffles users between variants
+	Salt     string              `json:"salt"`
+	Enabled  bool                `json:"enabled"`
+	Variants []ExperimentVariant `json:"variants"`
+}
+
+type ExperimentVariant struct {
+	gorm.Model
+	ExperimentID uint    `json:"experiment_id"`
+	Name         string  `json:"name"`
+	Weight       float64 `json:"weight"`
+	// Params is a json object of string values,
+	// that tells consumers what to do differently
+	Params string `json:"params"`
+}
+
+// ExperimentExposure is logged the first time user
+// is shown something depending on a variant
+type ExperimentExposure struct {
+	ID            uint      `json:"id" gorm:"primaryKey"`
+	CreatedAt     time.Time `json:"created_at"`
+	UserID        uint      `json:"user_id" gorm:"uniqueIndex:idx_experiment_exposure"`
+	ExperimentKey string    `json:"experiment_key" gorm:"uniqueIndex:idx_experiment_exposure"`
+	Variant       string    `json:"variant" gorm:"uniqueIndex:idx_experiment_exposure"`
+}
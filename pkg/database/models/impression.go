@@ -0,0 +1,31 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package models
+
+import "time"
+
+// LookImpressionRollup is the number of times a look was
+// shown on a surface of the app per day, total dwell time
+// and sum of positions, so that average position is known
+type LookImpressionRollup struct {
+	Date        time.Time `json:"date" gorm:"type:date;primaryKey"`
+	LookID      uint      `json:"look_id" gorm:"primaryKey;autoIncrement:false"`
+	Surface     string    `json:"surface" gorm:"primaryKey"`
+	Impressions int       `json:"impressions"`
+	DwellMs     int64     `json:"dwell_ms"`
+	PositionSum int64     `json:"position_sum" gorm:"not null;default:0"`
+}
@@ -45,4 +45,37 @@ type Look struct {
 	User   *User `json:"user"`
 
 	IsFromWardrobe bool `gorm:"-" json:"isFromWardrobe"`
+	// Reasons explain why the look was recommended
+	Reasons []*LookReason `gorm:"-" json:"reasons,omitempty"`
+}
+
+const (
+	ReasonWardrobe = "wardrobe"
+	ReasonLiked    = "liked"
+	ReasonTopic    = "topic"
+	ReasonMood     = "mood"
+	ReasonPopular  = "popular"
+	ReasonNew      = "new"
+	ReasonGorse    = "recommended"
+)
+
+// LookReason is a single reason a look was recommended for. Only
+// fields relevant to the Type are set, Label is ready to be shown
+type LookReason struct {
+	Type    string `json:"type"`
+	ItemIDs []uint `json:"item_ids,omitempty"`
+	LookID  uint   `json:"look_id,omitempty"`
+	TopicID uint   `json:"topic_id,omitempty"`
+	Mood    string `json:"mood,omitempty"`
+	Label   string `json:"label"`
+}
+
+// AddReason adds the reason unless look already has one of the same type
+func (l *Look) AddReason(reason *LookReason) {
+	for _, r := range l.Reasons {
+		if r.Type == reason.Type {
+			return
+		}
+	}
+	l.Reasons = append(l.Reasons, reason)
 }
@@ -16,11 +16,25 @@
 
 package models
 
-import "gorm.io/gorm"
+import (
+	"gorm.io/gorm"
+	"time"
+)
 
 type SearchRecord struct {
 	gorm.Model
 	Query   string `json:"query"`
-	UserID  uint   `json:"user_id"`
+	UserID  uint   `json:"user_id" gorm:"index"`
 	Visible bool   `json:"visible"`
+
+	// Results and latency are not known for searches
+	// recorded before they were tracked
+	Results   *int64 `json:"-"`
+	LatencyMs *int64 `json:"-"`
+
+	// The first result user opened
+	ClickedType     string     `json:"-"`
+	ClickedID       uint       `json:"-"`
+	ClickedPosition int        `json:"-"`
+	ClickedAt       *time.Time `json:"-"`
 }
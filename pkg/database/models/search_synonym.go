@@ -0,0 +1,32 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package models
+
+import "gorm.io/gorm"
+
+// SearchSynonymGroup is a set of terms, that mean the same thing
+// in search, like "джинсы", "jeans" and "деним"
+type SearchSynonymGroup struct {
+	gorm.Model
+	Terms []*SearchSynonym `json:"terms"`
+}
+
+type SearchSynonym struct {
+	ID                   uint   `json:"-" gorm:"primaryKey"`
+	SearchSynonymGroupID uint   `json:"-" gorm:"index"`
+	Term                 string `json:"term" gorm:"index"`
+}
@@ -0,0 +1,33 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package models
+
+import (
+	"gorm.io/gorm"
+	"time"
+)
+
+// TodayLookHistory keeps every look of the day user
+// has been shown, one per sex per day in user's time zone
+type TodayLookHistory struct {
+	gorm.Model
+	UserID uint      `json:"user_id" gorm:"uniqueIndex:idx_today_look_history"`
+	Sex    string    `json:"sex" gorm:"uniqueIndex:idx_today_look_history"`
+	Date   time.Time `json:"date" gorm:"type:date;uniqueIndex:idx_today_look_history"`
+	LookID uint      `json:"look_id"`
+	Look   *Look     `json:"look"`
+}
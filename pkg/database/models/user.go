@@ -19,8 +19,14 @@ package models
 import (
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"time"
+	_ "time/tzdata"
 )
 
+// DefaultTimezone is assumed for users,
+// that didn't tell us their own
+const DefaultTimezone = "Europe/Moscow"
+
 type User struct {
 	gorm.Model
 	Name      string `json:"name"`
@@ -32,6 +38,11 @@ type User struct {
 	Sex    string `json:"sex"`
 	Age    int    `json:"age"`
 	Avatar string `json:"avatar"`
+	// Timezone is an IANA time zone name, like Europe/Moscow
+	Timezone string `json:"timezone"`
+	// Hemisphere is either north or south, we need
+	// it to know which season it is for user
+	Hemisphere string `json:"hemisphere"`
 
 	Wardrobe []*WardrobeItem `json:"wardrobe" gorm:"many2many:users_wardrobe;"`
 	Mood     string          `json:"mood"`
@@ -55,6 +66,18 @@ func NewUser(email string, name string, password string) *User {
 	}
 }
 
+// Location returns user's time zone, or the default one
+// if it's not set or unknown. Local is never user's zone
+func (u *User) Location() *time.Location {
+	if u.Timezone != "" && u.Timezone != "Local" {
+		if loc, err := time.LoadLocation(u.Timezone); err == nil {
+			return loc
+		}
+	}
+	loc, _ := time.LoadLocation(DefaultTimezone)
+	return loc
+}
+
 func (u *User) CheckPasswordHash(password string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
 	return err == nil
@@ -0,0 +1,215 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package embeddings
+
+import (
+	"context"
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+// backfillBatch is how many images are embedded at once
+const backfillBatch = 50
+
+// embedTimeout limits loading and embedding a single image
+const embedTimeout = 30 * time.Second
+
+// Rows without embeddings, or with the image changed since it was embedded
+const missingSql = `SELECT t.id, t.image FROM %[1]v t
+	LEFT JOIN image_embeddings e ON e.entity_type = ? AND e.entity_id = t.id
+	WHERE t.image <> '' AND (e.entity_id IS NULL OR e.image <> t.image) %[2]v
+	ORDER BY t.id LIMIT ?`
+
+const upsertSql = `INSERT INTO image_embeddings (entity_type, entity_id, image, embedding, updated_at)
+	VALUES (?, ?, ?, ?::vector, now())
+	ON CONFLICT (entity_type, entity_id) DO UPDATE
+	SET image = excluded.image, embedding = excluded.embedding, updated_at = excluded.updated_at`
+
+// Texts of looks are their names, descriptions, names of
+// their topics and tags of their items. Only looks without
+// embeddings, or with the text changed, are returned
+const missingTextsSql = `SELECT d.id, d.text FROM (
+	    SELECT l.id, concat_ws(E'\n', l.name, l."desc",
+	        (SELECT string_agg(t.name, ', ') FROM topic_looks tl JOIN topics t ON t.id = tl.topic_id
+	         WHERE tl.look_id = l.id AND t.deleted_at IS NULL),
+	        (SELECT string_agg(wi.tags, ', ') FROM look_items li JOIN wardrobe_items wi ON wi.id = li.wardrobe_item_id
+	         WHERE li.look_id = l.id AND wi.tags <> '')
+	    ) AS text
+	    FROM looks l WHERE l.deleted_at IS NULL
+	) d
+	LEFT JOIN text_embeddings e ON e.entity_type = ? AND e.entity_id = d.id
+	WHERE e.entity_id IS NULL OR e.text_hash <> md5(d.text)
+	ORDER BY d.id LIMIT ?`
+
+const upsertTextSql = `INSERT INTO text_embeddings (entity_type, entity_id, text_hash, embedding, updated_at)
+	VALUES (?, ?, md5(?), ?::vector, now())
+	ON CONFLICT (entity_type, entity_id) DO UPDATE
+	SET text_hash = excluded.text_hash, embedding = excluded.embedding, updated_at = excluded.updated_at`
+
+type missingText struct {
+	ID   uint
+	Text string
+}
+
+type missingImage struct {
+	ID    uint
+	Image string
+}
+
+// sources are tables with images to embed by entity
+var sources = []struct {
+	entity string
+	table  string
+	where  string
+}{
+	{EntityLook, "looks", "AND t.deleted_at IS NULL"},
+	{EntityItem, "wardrobe_items", ""},
+}
+
+// Backfill embeds up to limit images of looks and wardrobe items
+// and texts of looks, that have no embeddings yet, and returns how
+// many were processed. Images and texts, that fail to load or
+// embed, are skipped until they change. Texts failing to embed
+// altogether don't stop images from being embedded
+func (e *Embeddings) Backfill(limit int) (int, error) {
+	processed, err := e.backfillTexts(limit)
+	if err != nil {
+		logrus.Errorf("error backfilling text embeddings: %v", err)
+	}
+
+	for _, source := range sources {
+		if processed >= limit {
+			break
+		}
+
+		var missing []*missingImage
+		err := database.DB().Raw(fmt.Sprintf(missingSql, source.table, source.where), source.entity, limit-processed).
+			Scan(&missing).Error
+		if err != nil {
+			return processed, fmt.Errorf("error getting %v without embeddings: %v", source.table, err)
+		}
+
+		for _, m := range missing {
+			var vector interface{}
+			embedding, err := e.embedImage(m.Image)
+			if err != nil {
+				logrus.Warnf("error embedding image of %v %v: %v", source.entity, m.ID, err)
+			} else {
+				vector = Vector(embedding)
+			}
+
+			err = database.DB().Exec(upsertSql, source.entity, m.ID, m.Image, vector).Error
+			if err != nil {
+				return processed, fmt.Errorf("error saving embedding: %v", err)
+			}
+			processed++
+		}
+	}
+	return processed, nil
+}
+
+// backfillTexts embeds texts of looks at once. If the batch fails,
+// texts are embedded one by one and the ones failing alone are
+// stored without embedding. If all of them fail, the batch is
+// tried again with the next backfill
+func (e *Embeddings) backfillTexts(limit int) (int, error) {
+	var missing []*missingText
+	err := database.DB().Raw(missingTextsSql, EntityLook, limit).Scan(&missing).Error
+	if err != nil {
+		return 0, fmt.Errorf("error getting looks without text embeddings: %v", err)
+	}
+	if len(missing) == 0 {
+		return 0, nil
+	}
+
+	texts := make([]string, len(missing))
+	for i, m := range missing {
+		texts[i] = m.Text
+	}
+	vectors := make([]interface{}, len(missing))
+	embeddings, err := e.embedTexts(texts)
+	if err == nil {
+		for i := range missing {
+			vectors[i] = Vector(embeddings[i])
+		}
+	} else {
+		failed := 0
+		for i, m := range missing {
+			embeddings, err = e.embedTexts(texts[i : i+1])
+			if err != nil {
+				logrus.Warnf("error embedding text of look %v: %v", m.ID, err)
+				failed++
+				continue
+			}
+			vectors[i] = Vector(embeddings[0])
+		}
+		if failed == len(missing) {
+			return 0, fmt.Errorf("error embedding texts of looks: %v", err)
+		}
+	}
+
+	for i, m := range missing {
+		err = database.DB().Exec(upsertTextSql, EntityLook, m.ID, m.Text, vectors[i]).Error
+		if err != nil {
+			return i, fmt.Errorf("error saving text embedding: %v", err)
+		}
+	}
+	return len(missing), nil
+}
+
+func (e *Embeddings) embedTexts(texts []string) ([][]float32, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), embedTimeout)
+	defer cancel()
+	embeddings, err := e.EmbedTexts(ctx, texts)
+	if err == nil && len(embeddings) != len(texts) {
+		err = fmt.Errorf("got %v embeddings of %v texts", len(embeddings), len(texts))
+	}
+	return embeddings, err
+}
+
+// BackfillAll embeds images in batches until there
+// are none left or Stop is called
+func (e *Embeddings) BackfillAll() error {
+	for {
+		select {
+		case <-e.stop:
+			return nil
+		default:
+		}
+
+		n, err := e.Backfill(backfillBatch)
+		if err != nil {
+			return err
+		}
+		if n < backfillBatch {
+			return nil
+		}
+	}
+}
+
+func (e *Embeddings) embedImage(url string) ([]float32, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), embedTimeout)
+	defer cancel()
+
+	image, err := e.images.load(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return e.Embed(ctx, image)
+}
@@ -0,0 +1,194 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package embeddings
+
+import (
+	"context"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+var instance *Embeddings
+
+const (
+	ProviderHTTP = "http"
+	ProviderFake = "fake"
+
+	// ImageDimensions and TextDimensions are sizes of embeddings,
+	// providers should return vectors of exactly these sizes
+	ImageDimensions = 512
+	TextDimensions  = 768
+)
+
+// Entities, that have images embedded
+const (
+	EntityLook = "look"
+	EntityItem = "wardrobe_item"
+)
+
+// Embeddings are stored with pgvector, the extension
+// is created here, so that it isn't required unless
+// embeddings are enabled
+const setupSql = `
+	CREATE EXTENSION IF NOT EXISTS vector;
+
+	--- Embedding is null if the image couldn't be embedded,
+	--- it's tried again once the image changes
+	CREATE TABLE IF NOT EXISTS image_embeddings (
+	    entity_type text NOT NULL,
+	    entity_id bigint NOT NULL,
+	    image text NOT NULL,
+	    embedding vector(512),
+	    updated_at timestamptz NOT NULL DEFAULT now(),
+	    PRIMARY KEY (entity_type, entity_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_image_embeddings ON image_embeddings USING hnsw (embedding vector_cosine_ops);
+
+	--- Text is embedded again once its hash changes, embedding
+	--- is null if the text alone couldn't be embedded
+	CREATE TABLE IF NOT EXISTS text_embeddings (
+	    entity_type text NOT NULL,
+	    entity_id bigint NOT NULL,
+	    text_hash text NOT NULL,
+	    embedding vector(768),
+	    updated_at timestamptz NOT NULL DEFAULT now(),
+	    PRIMARY KEY (entity_type, entity_id)
+	);
+	CREATE INDEX IF NOT EXISTS idx_text_embeddings ON text_embeddings USING hnsw (embedding vector_cosine_ops);
+`
+
+// Provider turns images and texts into vectors, similar images
+// and texts close in meaning are close by cosine distance
+type Provider interface {
+	EmbedImage(ctx context.Context, image []byte) ([]float32, error)
+	// EmbedText returns embeddings in the same order as texts
+	EmbedText(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// Store keeps embeddings and finds the closest ones
+type Store interface {
+	// Setup creates tables embeddings are kept in
+	Setup() error
+	// Similar returns entities of the type, which embeddings
+	// kept in the table are the closest to the embedding
+	Similar(table string, entity string, embedding []float32, sex string, limit int) ([]*Match, error)
+}
+
+type Config struct {
+	// Provider is either http or fake,
+	// leave it empty to disable embeddings
+	Provider string
+	// Address is the base url of http provider
+	Address string
+	// ImagesAddress is prepended to relative image paths
+	ImagesAddress string
+	// BackfillInterval is how often images, that
+	// aren't embedded yet, are looked for
+	BackfillInterval time.Duration
+}
+
+// Embeddings embeds images of looks and wardrobe items and texts
+// of looks, and finds the ones similar to a photo or a query
+type Embeddings struct {
+	cfg      Config
+	provider Provider
+	store    Store
+	images   *imageLoader
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// New creates embeddings with the configured provider.
+// Nil is returned if embeddings are disabled
+func New(cfg Config) (*Embeddings, error) {
+	return NewWithStore(cfg, dbStore{})
+}
+
+// NewWithStore creates embeddings kept in the given store
+func NewWithStore(cfg Config, store Store) (*Embeddings, error) {
+	var p Provider
+	switch cfg.Provider {
+	case "":
+		instance = nil
+		return nil, nil
+	case ProviderHTTP:
+		if cfg.Address == "" {
+			return nil, fmt.Errorf("embeddings provider address is not set")
+		}
+		p = NewHTTPProvider(cfg.Address)
+	case ProviderFake:
+		p = NewFakeProvider()
+	default:
+		return nil, fmt.Errorf("unknown embeddings provider: %v", cfg.Provider)
+	}
+
+	if cfg.BackfillInterval <= 0 {
+		cfg.BackfillInterval = 10 * time.Minute
+	}
+
+	err := store.Setup()
+	if err != nil {
+		return nil, fmt.Errorf("error setting up embeddings: %v", err)
+	}
+
+	e := &Embeddings{
+		cfg:      cfg,
+		provider: p,
+		store:    store,
+		images:   newImageLoader(cfg.ImagesAddress),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	instance = e
+	return e, nil
+}
+
+// Get returns configured embeddings, or nil
+// if they are disabled
+func Get() *Embeddings {
+	return instance
+}
+
+// Run embeds images and texts of new and changed
+// looks and wardrobe items until Stop is called
+func (e *Embeddings) Run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.cfg.BackfillInterval)
+	defer ticker.Stop()
+
+	for {
+		err := e.BackfillAll()
+		if err != nil {
+			logrus.Errorf("error backfilling embeddings: %v", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-e.stop:
+			return
+		}
+	}
+}
+
+func (e *Embeddings) Stop() {
+	close(e.stop)
+	<-e.done
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package embeddings
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"strings"
+	"unicode"
+)
+
+// FakeProvider makes embeddings out of hashes, so that the same
+// image always gets the same vector and texts sharing words are
+// close. It is meant for local development and tests, as it finds
+// exact copies of images and knows nothing about meaning of words
+type FakeProvider struct{}
+
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{}
+}
+
+func (p *FakeProvider) EmbedImage(ctx context.Context, image []byte) ([]float32, error) {
+	return fakeEmbedding(image, ImageDimensions), nil
+}
+
+// EmbedText hashes trigrams of words into the vector, the more
+// trigrams texts share, the closer their embeddings are
+func (p *FakeProvider) EmbedText(ctx context.Context, texts []string) ([][]float32, error) {
+	result := make([][]float32, len(texts))
+	for i, text := range texts {
+		embedding := make([]float32, TextDimensions)
+		for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		}) {
+			runes := []rune(" " + word + " ")
+			for j := 0; j+3 <= len(runes); j++ {
+				h := fnv.New32a()
+				h.Write([]byte(string(runes[j : j+3])))
+				x := h.Sum32()
+				// The highest bit is the sign, so
+				// that collisions cancel out
+				if x&(1<<31) != 0 {
+					embedding[x%TextDimensions]--
+				} else {
+					embedding[x%TextDimensions]++
+				}
+			}
+		}
+		result[i] = normalize(embedding)
+	}
+	return result, nil
+}
+
+// fakeEmbedding spreads hashes of the data over
+// the vector and normalizes it to unit length
+func fakeEmbedding(data []byte, dimensions int) []float32 {
+	embedding := make([]float32, dimensions)
+	sum := sha256.Sum256(data)
+	for i := range embedding {
+		if i%8 == 0 && i > 0 {
+			sum = sha256.Sum256(sum[:])
+		}
+		x := binary.BigEndian.Uint32(sum[(i%8)*4:])
+		embedding[i] = float32(x)/math.MaxUint32*2 - 1
+	}
+	return normalize(embedding)
+}
+
+// normalize scales the vector to unit length, zero vector is kept
+func normalize(embedding []float32) []float32 {
+	var norm float64
+	for _, x := range embedding {
+		norm += float64(x) * float64(x)
+	}
+	if norm == 0 {
+		return embedding
+	}
+	norm = math.Sqrt(norm)
+	for i := range embedding {
+		embedding[i] = float32(float64(embedding[i]) / norm)
+	}
+	return embedding
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package embeddings
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTPProvider gets embeddings from a model server. Images are
+// posted as they are to /embed/image, the server responds with
+// {"embedding": [...]}. Texts are posted to /embed/text as
+// {"texts": [...]}, the server responds with {"embeddings": [...]}
+type HTTPProvider struct {
+	c       *http.Client
+	baseUrl string
+}
+
+func NewHTTPProvider(address string) *HTTPProvider {
+	return &HTTPProvider{
+		c: &http.Client{
+			Timeout: 10 * time.Second,
+		},
+		baseUrl: strings.TrimRight(address, "/"),
+	}
+}
+
+func (p *HTTPProvider) EmbedImage(ctx context.Context, image []byte) ([]float32, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", p.baseUrl+"/embed/image", bytes.NewReader(image))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", http.DetectContentType(image))
+
+	var body struct {
+		Embedding []float32 `json:"embedding"`
+	}
+	err = p.do(req, &body)
+	if err != nil {
+		return nil, err
+	}
+	return body.Embedding, nil
+}
+
+func (p *HTTPProvider) EmbedText(ctx context.Context, texts []string) ([][]float32, error) {
+	data, err := json.Marshal(map[string]interface{}{"texts": texts})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", p.baseUrl+"/embed/text", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	var body struct {
+		Embeddings [][]float32 `json:"embeddings"`
+	}
+	err = p.do(req, &body)
+	if err != nil {
+		return nil, err
+	}
+	if len(body.Embeddings) != len(texts) {
+		return nil, fmt.Errorf("got %v embeddings for %v texts", len(body.Embeddings), len(texts))
+	}
+	return body.Embeddings, nil
+}
+
+func (p *HTTPProvider) do(req *http.Request, v interface{}) error {
+	res, err := p.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return fmt.Errorf("wrong status code - %v", res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
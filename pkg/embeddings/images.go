@@ -0,0 +1,76 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package embeddings
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MaxImageSize is the largest image, that is embedded
+const MaxImageSize = 10 << 20
+
+// imageLoader downloads images of looks and wardrobe items
+type imageLoader struct {
+	c       *http.Client
+	baseUrl string
+}
+
+func newImageLoader(address string) *imageLoader {
+	return &imageLoader{
+		c: &http.Client{
+			Timeout: 20 * time.Second,
+		},
+		baseUrl: strings.TrimRight(address, "/"),
+	}
+}
+
+func (l *imageLoader) load(ctx context.Context, image string) ([]byte, error) {
+	url := image
+	if !strings.HasPrefix(image, "http://") && !strings.HasPrefix(image, "https://") {
+		if l.baseUrl == "" {
+			return nil, fmt.Errorf("images address is not set for %v", image)
+		}
+		url = l.baseUrl + "/" + strings.TrimLeft(image, "/")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := l.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("wrong status code - %v", res.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, MaxImageSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxImageSize {
+		return nil, fmt.Errorf("image is larger than %v bytes", MaxImageSize)
+	}
+	return data, nil
+}
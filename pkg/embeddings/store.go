@@ -0,0 +1,136 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package embeddings
+
+import (
+	"context"
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database"
+	"strconv"
+	"strings"
+)
+
+// Entities of the type closest to the embedding, only ones of
+// the sex are returned, unless it is empty. Rows, that are
+// deleted or have no embedding, are skipped
+const similarSql = `SELECT e.entity_id AS id, 1 - (e.embedding <=> ?::vector) AS similarity
+	FROM %[1]v e JOIN %[2]v t ON t.id = e.entity_id
+	WHERE e.entity_type = ? AND e.embedding IS NOT NULL AND (? = '' OR t.sex = ?) %[3]v
+	ORDER BY e.embedding <=> ?::vector LIMIT ?`
+
+// MinTextSimilarity is how similar a look should be to the
+// query to be found by meaning, less similar ones are noise
+const MinTextSimilarity = 0.35
+
+// dbStore keeps embeddings in the database with pgvector
+type dbStore struct{}
+
+func (dbStore) Setup() error {
+	return database.DB().Exec(setupSql).Error
+}
+
+func (dbStore) Similar(table string, entity string, embedding []float32, sex string, limit int) ([]*Match, error) {
+	var rows, where string
+	for _, source := range sources {
+		if source.entity == entity {
+			rows, where = source.table, source.where
+		}
+	}
+	if rows == "" {
+		return nil, fmt.Errorf("unknown entity %v", entity)
+	}
+
+	v := Vector(embedding)
+	matches := []*Match{}
+	err := database.DB().Raw(fmt.Sprintf(similarSql, table, rows, where), v, entity, sex, sex, v, limit).Scan(&matches).Error
+	if err != nil {
+		return nil, fmt.Errorf("error finding similar %v: %v", rows, err)
+	}
+	return matches, nil
+}
+
+type Match struct {
+	ID uint `json:"id"`
+	// Similarity is cosine similarity, 1 is the same image
+	Similarity float64 `json:"similarity"`
+}
+
+// Embed returns embedding of the image
+func (e *Embeddings) Embed(ctx context.Context, image []byte) ([]float32, error) {
+	embedding, err := e.provider.EmbedImage(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+	if len(embedding) != ImageDimensions {
+		return nil, fmt.Errorf("wrong embedding size - %v", len(embedding))
+	}
+	return embedding, nil
+}
+
+// EmbedTexts returns embeddings of the texts in the same order
+func (e *Embeddings) EmbedTexts(ctx context.Context, texts []string) ([][]float32, error) {
+	embeddings, err := e.provider.EmbedText(ctx, texts)
+	if err != nil {
+		return nil, err
+	}
+	for _, embedding := range embeddings {
+		if len(embedding) != TextDimensions {
+			return nil, fmt.Errorf("wrong embedding size - %v", len(embedding))
+		}
+	}
+	return embeddings, nil
+}
+
+// Similar returns looks or wardrobe items, which
+// images are the closest to the embedding
+func (e *Embeddings) Similar(entity string, embedding []float32, sex string, limit int) ([]*Match, error) {
+	return e.store.Similar("image_embeddings", entity, embedding, sex, limit)
+}
+
+// SimilarText returns looks, which texts are the closest in meaning
+// to the query. Looks less similar than MinTextSimilarity are skipped
+func (e *Embeddings) SimilarText(ctx context.Context, query string, sex string, limit int) ([]*Match, error) {
+	embeddings, err := e.EmbedTexts(ctx, []string{query})
+	if err != nil {
+		return nil, fmt.Errorf("error embedding query: %v", err)
+	}
+
+	matches, err := e.store.Similar("text_embeddings", EntityLook, embeddings[0], sex, limit)
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range matches {
+		if m.Similarity < MinTextSimilarity {
+			return matches[:i], nil
+		}
+	}
+	return matches, nil
+}
+
+// Vector formats the embedding as pgvector text
+func Vector(embedding []float32) string {
+	var b strings.Builder
+	b.WriteByte('[')
+	for i, x := range embedding {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(strconv.FormatFloat(float64(x), 'f', -1, 32))
+	}
+	b.WriteByte(']')
+	return b.String()
+}
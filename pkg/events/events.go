@@ -0,0 +1,216 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package events
+
+import (
+	"encoding/json"
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+var instance *Recorder
+
+// Event types
+const (
+	TypeView      = "view"
+	TypeRead      = "read"
+	TypeLike      = "like"
+	TypeUnlike    = "unlike"
+	TypeDislike   = "dislike"
+	TypeUndislike = "undislike"
+	TypeSave      = "save"
+	TypeUnsave    = "unsave"
+	TypeFollow    = "follow"
+	TypeUnfollow  = "unfollow"
+	TypeSearch    = "search"
+	TypeClick     = "click"
+
+	TypeImpression   = "impression"
+	TypeSetWardrobe  = "set_wardrobe"
+	TypeSetMood      = "set_mood"
+	TypeOutfits      = "outfits"
+	TypeCompleteLook = "complete_look"
+	TypeTodayLook    = "today_look"
+)
+
+// Entity types
+const (
+	EntityLook    = "look"
+	EntityTopic   = "topic"
+	EntityArticle = "article"
+	EntityItem    = "wardrobe_item"
+	EntityBrand   = "brand"
+)
+
+type Config struct {
+	// BufferSize is how many events may wait to be written,
+	// events are dropped once the buffer is full
+	BufferSize int
+	// BatchSize is the max number of events written at once
+	BatchSize int
+	// FlushInterval is how often buffered events are written
+	FlushInterval time.Duration
+	// RollupInterval is how often daily rollups are recomputed
+	RollupInterval time.Duration
+}
+
+// Recorder writes events to the database in batches,
+// so that handlers never wait for it
+type Recorder struct {
+	cfg    Config
+	events chan *models.Event
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func New(cfg Config) *Recorder {
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 10000
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 500
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = 5 * time.Second
+	}
+	if cfg.RollupInterval <= 0 {
+		cfg.RollupInterval = time.Hour
+	}
+
+	instance = &Recorder{
+		cfg:    cfg,
+		events: make(chan *models.Event, cfg.BufferSize),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	return instance
+}
+
+func Get() *Recorder {
+	if instance == nil {
+		New(Config{})
+	}
+	return instance
+}
+
+// Event is what handlers know about an interaction,
+// the recorder adds the time
+type Event struct {
+	UserID     uint
+	Type       string
+	EntityType string
+	EntityID   uint
+	Context    map[string]interface{}
+	Platform   string
+	AppVersion string
+}
+
+// Record queues the event to be written
+func (r *Recorder) Record(e Event) {
+	context := "{}"
+	if len(e.Context) > 0 {
+		data, err := json.Marshal(e.Context)
+		if err != nil {
+			logrus.Errorf("error encoding event context: %v", err)
+		} else {
+			context = string(data)
+		}
+	}
+
+	event := &models.Event{
+		CreatedAt:  time.Now(),
+		UserID:     e.UserID,
+		Type:       e.Type,
+		EntityType: e.EntityType,
+		EntityID:   e.EntityID,
+		Context:    context,
+		Platform:   e.Platform,
+		AppVersion: e.AppVersion,
+	}
+
+	select {
+	case r.events <- event:
+	default:
+		logrus.Warnf("events buffer is full, dropping %v event of user %v", e.Type, e.UserID)
+	}
+}
+
+// Run writes queued events and recomputes rollups
+// until Stop is called
+func (r *Recorder) Run() {
+	defer close(r.done)
+
+	flush := time.NewTicker(r.cfg.FlushInterval)
+	defer flush.Stop()
+	rollup := time.NewTicker(r.cfg.RollupInterval)
+	defer rollup.Stop()
+
+	// Rollups might be missing for a while after a
+	// restart, so they are recomputed right away
+	r.rollup()
+
+	batch := make([]*models.Event, 0, r.cfg.BatchSize)
+	write := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := database.DB().CreateInBatches(batch, r.cfg.BatchSize).Error
+		if err != nil {
+			logrus.Errorf("error writing %v events: %v", len(batch), err)
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case event := <-r.events:
+			batch = append(batch, event)
+			if len(batch) >= r.cfg.BatchSize {
+				write()
+			}
+		case <-flush.C:
+			write()
+		case <-rollup.C:
+			r.rollup()
+		case <-r.stop:
+			// Writing what's left before shutting down
+			for {
+				select {
+				case event := <-r.events:
+					batch = append(batch, event)
+				default:
+					write()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (r *Recorder) rollup() {
+	err := Rollup(time.Now())
+	if err != nil {
+		logrus.Errorf("error rolling up events: %v", err)
+	}
+}
+
+func (r *Recorder) Stop() {
+	close(r.stop)
+	<-r.done
+}
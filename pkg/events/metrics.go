@@ -0,0 +1,155 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package events
+
+import (
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+// Counts per day, type and entity, plus a "*" row per day with
+// all events. Anonymous events have user id 0 and are not users
+const rollupSql = `INSERT INTO event_daily_rollups (date, type, entity_type, entity_id, events, users)
+	SELECT created_at::date, type, entity_type, entity_id, count(*), count(DISTINCT nullif(user_id, 0))
+	FROM events WHERE created_at >= ?::date AND created_at < ?::date + 1
+	GROUP BY 1, 2, 3, 4
+	UNION ALL
+	SELECT created_at::date, '*', '', 0, count(*), count(DISTINCT nullif(user_id, 0))
+	FROM events WHERE created_at >= ?::date AND created_at < ?::date + 1
+	GROUP BY 1
+	ON CONFLICT (date, type, entity_type, entity_id) DO UPDATE
+	    SET events = excluded.events, users = excluded.users`
+
+const dauSql = `SELECT date, users FROM event_daily_rollups
+	WHERE type = '*' AND date > current_date - ?::int
+	ORDER BY date`
+
+// Users by the day of their first event, and how many of
+// them came back the next day, in a week and in a month
+const retentionSql = `WITH first AS (
+	    SELECT user_id, min(created_at)::date AS day FROM events
+	    WHERE user_id <> 0 GROUP BY user_id
+	), active AS (
+	    SELECT DISTINCT user_id, created_at::date AS day FROM events
+	    WHERE user_id <> 0 AND created_at >= current_date - ?::int
+	)
+	SELECT first.day AS cohort, count(DISTINCT first.user_id) AS users,
+	       count(DISTINCT a1.user_id) AS day1, count(DISTINCT a7.user_id) AS day7, count(DISTINCT a30.user_id) AS day30
+	FROM first
+	    LEFT JOIN active a1 ON a1.user_id = first.user_id AND a1.day = first.day + 1
+	    LEFT JOIN active a7 ON a7.user_id = first.user_id AND a7.day = first.day + 7
+	    LEFT JOIN active a30 ON a30.user_id = first.user_id AND a30.day = first.day + 30
+	WHERE first.day > current_date - ?::int
+	GROUP BY first.day ORDER BY first.day`
+
+const engagementSql = `SELECT entity_id,
+	    coalesce(sum(events) FILTER (WHERE type IN ('view', 'read')), 0) AS views,
+	    coalesce(sum(events) FILTER (WHERE type = 'like'), 0) AS likes,
+	    coalesce(sum(events) FILTER (WHERE type = 'dislike'), 0) AS dislikes,
+	    coalesce(sum(events) FILTER (WHERE type IN ('save', 'follow')), 0) AS saves
+	FROM event_daily_rollups
+	WHERE entity_type = ? AND date > current_date - ?::int
+	GROUP BY entity_id
+	ORDER BY views DESC, entity_id
+	LIMIT ?`
+
+// MaxEngagementEntities is how many of the most viewed
+// entities of every type are reported
+const MaxEngagementEntities = 50
+
+type DailyUsers struct {
+	Date  time.Time `json:"date"`
+	Users int       `json:"users"`
+}
+
+type Cohort struct {
+	Cohort time.Time `json:"cohort"`
+	Users  int       `json:"users"`
+	Day1   int       `json:"day1"`
+	Day7   int       `json:"day7"`
+	Day30  int       `json:"day30"`
+}
+
+type Engagement struct {
+	EntityID uint    `json:"entity_id"`
+	Views    int     `json:"views"`
+	Likes    int     `json:"likes"`
+	Dislikes int     `json:"dislikes"`
+	Saves    int     `json:"saves"`
+	LikeRate float64 `json:"like_rate" gorm:"-"`
+	SaveRate float64 `json:"save_rate" gorm:"-"`
+}
+
+type Metrics struct {
+	DAU        []*DailyUsers            `json:"dau"`
+	Retention  []*Cohort                `json:"retention"`
+	Engagement map[string][]*Engagement `json:"engagement"`
+}
+
+// Rollup recomputes rollups of the day of t and the day before,
+// as late events of yesterday might still be arriving
+func Rollup(t time.Time) error {
+	from := t.AddDate(0, 0, -1).Format(dateLayout)
+	to := t.Format(dateLayout)
+
+	err := database.DB().Exec(rollupSql, from, to, from, to).Error
+	if err != nil {
+		return fmt.Errorf("error computing daily rollups: %v", err)
+	}
+	return nil
+}
+
+// GetMetrics returns DAU, retention of users, who joined,
+// and engagement per look, topic and article for the last days
+func GetMetrics(days int) (*Metrics, error) {
+	metrics := &Metrics{
+		DAU:        []*DailyUsers{},
+		Retention:  []*Cohort{},
+		Engagement: make(map[string][]*Engagement),
+	}
+
+	err := database.DB().Raw(dauSql, days).Scan(&metrics.DAU).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting dau: %v", err)
+	}
+
+	// Retention of the last cohorts needs 30 more days of activity
+	err = database.DB().Raw(retentionSql, days+30, days).Scan(&metrics.Retention).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting retention: %v", err)
+	}
+
+	for _, entity := range []string{EntityLook, EntityTopic, EntityArticle} {
+		engagement := []*Engagement{}
+		err = database.DB().Raw(engagementSql, entity, days, MaxEngagementEntities).Scan(&engagement).Error
+		if err != nil {
+			return nil, fmt.Errorf("error getting %v engagement: %v", entity, err)
+		}
+		for _, e := range engagement {
+			if e.Views > 0 {
+				e.LikeRate = float64(e.Likes) / float64(e.Views)
+				e.SaveRate = float64(e.Saves) / float64(e.Views)
+			}
+		}
+		metrics.Engagement[entity] = engagement
+	}
+
+	return metrics, nil
+}
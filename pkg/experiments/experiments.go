@@ -0,0 +1,376 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package experiments
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+var instance *Experiments
+
+// Experiments known to the code
+const (
+	// Feed variants may set "feed_weights"
+	// in adviser.ParseWeights format
+	Feed = "feed"
+	// Search variants may set "ranking", either "wardrobe"
+	// or "text", and "semantic", either "on" or "off"
+	Search = "search"
+)
+
+// buckets is the resolution of user bucketing
+const buckets = 10000
+
+// maxExposed is how many logged exposures are remembered,
+// forgotten ones are just logged again, which is a no-op
+const maxExposed = 100000
+
+type Variant struct {
+	Name   string            `json:"name"`
+	Weight float64           `json:"weight"`
+	Params map[string]string `json:"params"`
+}
+
+// Param returns variant param, or def if it's not set
+func (v *Variant) Param(name string, def string) string {
+	if v == nil {
+		return def
+	}
+	if value, ok := v.Params[name]; ok && value != "" {
+		return value
+	}
+	return def
+}
+
+type Experiment struct {
+	Key      string     `json:"key"`
+	Salt     string     `json:"salt"`
+	Variants []*Variant `json:"variants"`
+}
+
+type Config struct {
+	// Experiments is a json array of experiments. Experiments
+	// in the database override ones with the same key
+	Experiments string
+	// ReloadInterval is how often experiments
+	// are reloaded from the database
+	ReloadInterval time.Duration
+}
+
+// Store keeps experiments and exposures of users to their variants
+type Store interface {
+	// Experiments returns stored experiments with their variants
+	Experiments() ([]*models.Experiment, error)
+	// Expose logs exposure of the user to the variant,
+	// exposures logged before are kept
+	Expose(userID uint, key string, variant string) error
+}
+
+// dbStore keeps experiments in the database
+type dbStore struct{}
+
+func (dbStore) Experiments() ([]*models.Experiment, error) {
+	var stored []*models.Experiment
+	err := database.DB().Preload("Variants", func(db *gorm.DB) *gorm.DB {
+		return db.Order("id")
+	}).Find(&stored).Error
+	return stored, err
+}
+
+func (dbStore) Expose(userID uint, key string, variant string) error {
+	return database.DB().Exec(`INSERT INTO experiment_exposures (created_at, user_id, experiment_key, variant)
+		VALUES (now(), ?, ?, ?) ON CONFLICT DO NOTHING`, userID, key, variant).Error
+}
+
+// Experiments assigns users to variants of running experiments
+// and logs the first exposure of user to every variant
+type Experiments struct {
+	cfg   Config
+	store Store
+
+	mu       sync.RWMutex
+	fromCfg  map[string]*Experiment
+	running  map[string]*Experiment
+	loadedAt time.Time
+
+	// Exposures recently logged by this instance
+	exposed *exposures
+}
+
+func New(cfg Config) (*Experiments, error) {
+	return NewWithStore(cfg, dbStore{})
+}
+
+// NewWithStore creates experiments kept in the given store
+func NewWithStore(cfg Config, store Store) (*Experiments, error) {
+	if cfg.ReloadInterval <= 0 {
+		cfg.ReloadInterval = time.Minute
+	}
+
+	e := &Experiments{
+		cfg:     cfg,
+		store:   store,
+		fromCfg: make(map[string]*Experiment),
+		exposed: newExposures(maxExposed),
+	}
+
+	if cfg.Experiments != "" {
+		var experiments []*Experiment
+		err := json.Unmarshal([]byte(cfg.Experiments), &experiments)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing experiments: %v", err)
+		}
+		for _, experiment := range experiments {
+			if experiment.Salt == "" {
+				experiment.Salt = experiment.Key
+			}
+			e.fromCfg[experiment.Key] = experiment
+		}
+	}
+	e.running = e.fromCfg
+
+	instance = e
+	return e, nil
+}
+
+func Get() *Experiments {
+	if instance == nil {
+		New(Config{})
+	}
+	return instance
+}
+
+// Assign returns user's variant of the experiment, or nil
+// if it's not running. The same user always gets the same
+// variant, as long as experiment's salt and variants don't change
+func (e *Experiments) Assign(user *models.User, key string) *Variant {
+	experiment := e.experiment(key)
+	if experiment == nil {
+		return nil
+	}
+
+	variant := experiment.bucket(user.ID)
+	if variant != nil {
+		e.expose(user.ID, key, variant.Name)
+	}
+	return variant
+}
+
+func (e *Experiments) experiment(key string) *Experiment {
+	e.reload()
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.running[key]
+}
+
+func (x *Experiment) bucket(userID uint) *Variant {
+	var total float64
+	for _, v := range x.Variants {
+		if v.Weight > 0 {
+			total += v.Weight
+		}
+	}
+	if total == 0 {
+		return nil
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(fmt.Sprintf("%v:%v", x.Salt, userID)))
+	point := float64(h.Sum32()%buckets) / buckets * total
+
+	for _, v := range x.Variants {
+		if v.Weight <= 0 {
+			continue
+		}
+		if point < v.Weight {
+			return v
+		}
+		point -= v.Weight
+	}
+	return x.Variants[len(x.Variants)-1]
+}
+
+func (e *Experiments) expose(userID uint, key string, variant string) {
+	id := fmt.Sprintf("%v:%v:%v", userID, key, variant)
+	if !e.exposed.add(id) {
+		return
+	}
+
+	err := e.store.Expose(userID, key, variant)
+	if err != nil {
+		logrus.Errorf("error logging experiment exposure: %v", err)
+		e.exposed.remove(id)
+	}
+}
+
+// exposures is a set of exposure ids, that forgets
+// the least recently used ones once it's full
+type exposures struct {
+	mu    sync.Mutex
+	max   int
+	order *list.List
+	items map[string]*list.Element
+}
+
+func newExposures(max int) *exposures {
+	return &exposures{
+		max:   max,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// add reports whether id wasn't in the set yet
+func (x *exposures) add(id string) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if el, ok := x.items[id]; ok {
+		x.order.MoveToFront(el)
+		return false
+	}
+	x.items[id] = x.order.PushFront(id)
+	if x.order.Len() > x.max {
+		oldest := x.order.Back()
+		x.order.Remove(oldest)
+		delete(x.items, oldest.Value.(string))
+	}
+	return true
+}
+
+func (x *exposures) remove(id string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	if el, ok := x.items[id]; ok {
+		x.order.Remove(el)
+		delete(x.items, id)
+	}
+}
+
+// reload merges enabled experiments from the database
+// with the configured ones, once ReloadInterval passes
+func (e *Experiments) reload() {
+	e.mu.RLock()
+	fresh := time.Since(e.loadedAt) < e.cfg.ReloadInterval
+	e.mu.RUnlock()
+	if fresh {
+		return
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if time.Since(e.loadedAt) < e.cfg.ReloadInterval {
+		return
+	}
+	// Even if loading fails we don't want to retry on every request
+	e.loadedAt = time.Now()
+
+	stored, err := e.store.Experiments()
+	if err != nil {
+		logrus.Errorf("error loading experiments: %v", err)
+		return
+	}
+
+	running := make(map[string]*Experiment, len(e.fromCfg)+len(stored))
+	for key, experiment := range e.fromCfg {
+		running[key] = experiment
+	}
+	for _, s := range stored {
+		if !s.Enabled {
+			delete(running, s.Key)
+			continue
+		}
+
+		experiment := &Experiment{Key: s.Key, Salt: s.Salt}
+		if experiment.Salt == "" {
+			experiment.Salt = s.Key
+		}
+		for _, sv := range s.Variants {
+			v := &Variant{Name: sv.Name, Weight: sv.Weight}
+			if sv.Params != "" {
+				err = json.Unmarshal([]byte(sv.Params), &v.Params)
+				if err != nil {
+					logrus.Errorf("error parsing params of variant %v of experiment %v: %v", sv.Name, s.Key, err)
+				}
+			}
+			experiment.Variants = append(experiment.Variants, v)
+		}
+		running[s.Key] = experiment
+	}
+	e.running = running
+}
+
+// Interactions of exposed users after their latest exposure. Users,
+// that were moved between variants, count for the latest one only
+const resultsSql = `WITH latest AS (
+	    SELECT DISTINCT ON (user_id) user_id, variant, created_at
+	    FROM experiment_exposures
+	    WHERE experiment_key = ?
+	    ORDER BY user_id, created_at DESC
+	)
+	SELECT x.variant, count(DISTINCT x.user_id) AS users,
+	    count(e.id) FILTER (WHERE e.type = 'view' AND e.entity_type = 'look') AS views,
+	    count(e.id) FILTER (WHERE e.type = 'like' AND e.entity_type = 'look') AS likes,
+	    count(e.id) FILTER (WHERE e.type = 'save' AND e.entity_type = 'look') AS saves,
+	    count(e.id) FILTER (WHERE e.type = 'read') AS reads
+	FROM latest x
+	    LEFT JOIN events e ON e.user_id = x.user_id AND e.created_at >= x.created_at
+	GROUP BY x.variant ORDER BY x.variant`
+
+type Result struct {
+	Variant  string  `json:"variant"`
+	Users    int     `json:"users"`
+	Views    int     `json:"views"`
+	Likes    int     `json:"likes"`
+	Saves    int     `json:"saves"`
+	Reads    int     `json:"reads"`
+	LikeRate float64 `json:"like_rate" gorm:"-"`
+	SaveRate float64 `json:"save_rate" gorm:"-"`
+	ReadRate float64 `json:"read_rate" gorm:"-"`
+}
+
+// Results compares like and save rates per viewed look
+// and reads per user between variants of the experiment
+func Results(key string) ([]*Result, error) {
+	results := []*Result{}
+	err := database.DB().Raw(resultsSql, key).Scan(&results).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting experiment results: %v", err)
+	}
+
+	for _, r := range results {
+		if r.Views > 0 {
+			r.LikeRate = float64(r.Likes) / float64(r.Views)
+			r.SaveRate = float64(r.Saves) / float64(r.Views)
+		}
+		if r.Users > 0 {
+			r.ReadRate = float64(r.Reads) / float64(r.Users)
+		}
+	}
+	return results, nil
+}
@@ -0,0 +1,108 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package experiments
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestExposuresBounded(t *testing.T) {
+	x := newExposures(2)
+	if !x.add("a") || !x.add("b") {
+		t.Fatal("new exposures are not added")
+	}
+	if x.add("a") {
+		t.Error("exposure is added twice")
+	}
+
+	// b is the least recently used now
+	x.add("c")
+	if len(x.items) != 2 || x.order.Len() != 2 {
+		t.Fatalf("got %v exposures, want 2", len(x.items))
+	}
+	if !x.add("b") {
+		t.Error("least recently used exposure is kept")
+	}
+	if x.add("c") {
+		t.Error("recent exposure is forgotten")
+	}
+
+	x.remove("c")
+	if !x.add("c") {
+		t.Error("removed exposure is kept")
+	}
+}
+
+func TestExposuresMany(t *testing.T) {
+	x := newExposures(100)
+	for i := 0; i < 1000; i++ {
+		x.add(fmt.Sprint(i))
+	}
+	if len(x.items) != 100 || x.order.Len() != 100 {
+		t.Errorf("got %v exposures, want 100", len(x.items))
+	}
+}
+
+func TestBucketStable(t *testing.T) {
+	x := &Experiment{Key: "feed", Salt: "feed", Variants: []*Variant{
+		{Name: "control", Weight: 1},
+		{Name: "treatment", Weight: 1},
+	}}
+	for id := uint(1); id <= 100; id++ {
+		if a, b := x.bucket(id), x.bucket(id); a != b {
+			t.Fatalf("user %v got %v, then %v", id, a.Name, b.Name)
+		}
+	}
+
+	// Another salt buckets users anew
+	salted := &Experiment{Key: "feed", Salt: "feed-2", Variants: x.Variants}
+	var moved int
+	for id := uint(1); id <= 1000; id++ {
+		if x.bucket(id) != salted.bucket(id) {
+			moved++
+		}
+	}
+	if moved < 400 || moved > 600 {
+		t.Errorf("%v of 1000 users moved to another variant, want about half", moved)
+	}
+}
+
+func TestBucketWeights(t *testing.T) {
+	x := &Experiment{Key: "search", Salt: "search", Variants: []*Variant{
+		{Name: "a", Weight: 0.7},
+		{Name: "b", Weight: 0.2},
+		{Name: "off", Weight: 0},
+		{Name: "c", Weight: 0.1},
+	}}
+
+	const users = 20000
+	counts := make(map[string]int)
+	for id := uint(1); id <= users; id++ {
+		counts[x.bucket(id).Name]++
+	}
+	for _, v := range x.Variants {
+		share := float64(counts[v.Name]) / users
+		if share < v.Weight-0.02 || share > v.Weight+0.02 {
+			t.Errorf("variant %v got %.3f of users, want %v", v.Name, share, v.Weight)
+		}
+	}
+
+	if (&Experiment{Variants: []*Variant{{Name: "off"}}}).bucket(1) != nil {
+		t.Error("experiment without weights assigns users")
+	}
+}
@@ -19,17 +19,23 @@ package gorse
 import (
 	"bytes"
 	"encoding/json"
-	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 )
 
 var instance *Gorse
 
+const (
+	breakerThreshold = 5
+	breakerCooldown  = 30 * time.Second
+)
+
 type Gorse struct {
 	c       *http.Client
 	baseUrl string
+	breaker *Breaker
 }
 
 func New(url string, timeoutS int) {
@@ -39,122 +45,123 @@ func New(url string, timeoutS int) {
 	instance = &Gorse{
 		c:       c,
 		baseUrl: url,
+		breaker: NewBreaker(breakerThreshold, breakerCooldown),
 	}
 }
 
-func InsertItem(item *Item) error {
-	var err error
+// Available reports whether gorse is considered healthy
+func Available() bool {
+	return instance != nil && !instance.breaker.Open()
+}
 
-	itemBytes, _ := item.Marshal()
+// do sends request through the circuit breaker and decodes
+// response into dst, if it's not nil
+func (g *Gorse) do(method string, path string, body interface{}, dst interface{}) error {
+	if g == nil || !g.breaker.Allow() {
+		return ErrUnavailable
+	}
 
-	url := fmt.Sprintf("http://%v/api/item", instance.baseUrl)
-	res, err := instance.c.Post(url, "application/json", bytes.NewReader(itemBytes))
-	if res.StatusCode != 200 {
-		return errors.New(fmt.Sprintf("wrong status code - %v", res.StatusCode))
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
 	}
 
-	return err
-}
+	req, err := http.NewRequest(method, fmt.Sprintf("http://%v%v", g.baseUrl, path), reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
 
-func Read(userId, itemId string) error {
-	var err error
+	res, err := g.c.Do(req)
+	if err != nil {
+		g.breaker.Failure()
+		return err
+	}
+	defer res.Body.Close()
 
-	r := FeedbackRequest{
-		UserID:       userId,
-		ItemID:       itemId,
-		Timestamp:    time.Now(),
-		FeedbackType: "read",
+	if res.StatusCode >= 500 {
+		g.breaker.Failure()
+		return fmt.Errorf("wrong status code - %v", res.StatusCode)
 	}
-	rBytes, _ := json.Marshal([]FeedbackRequest{r})
+	g.breaker.Success()
 
-	url := fmt.Sprintf("http://%v/api/feedback", instance.baseUrl)
-	res, err := instance.c.Post(url, "application/json", bytes.NewReader(rBytes))
 	if res.StatusCode != 200 {
 		return fmt.Errorf("wrong status code - %v", res.StatusCode)
 	}
-
-	return err
+	if dst != nil {
+		return json.NewDecoder(res.Body).Decode(dst)
+	}
+	return nil
 }
 
-func Star(userId, itemId string) error {
-	var err error
-
+// Users are identified by their ids and items,
+// which are looks, by their slugs
+func feedback(userId, itemId, feedbackType string) error {
 	r := FeedbackRequest{
 		UserID:       userId,
 		ItemID:       itemId,
 		Timestamp:    time.Now(),
-		FeedbackType: "star",
+		FeedbackType: feedbackType,
 	}
-	rBytes, _ := json.Marshal([]FeedbackRequest{r})
-
-	url := fmt.Sprintf("http://%v/api/feedback", instance.baseUrl)
-	res, err := instance.c.Post(url, "application/json", bytes.NewReader(rBytes))
-	if res.StatusCode != 200 {
-		return fmt.Errorf("wrong status code - %v", res.StatusCode)
-	}
-
-	return err
+	return instance.do("POST", "/api/feedback", []FeedbackRequest{r}, nil)
 }
 
-func Unstar(userId, itemID string) error {
-	var err error
-
-	url := fmt.Sprintf("http://%v/api/feedback/%v/%v/%v", instance.baseUrl, "star", userId, itemID)
-	req, _ := http.NewRequest("DELETE", url, nil)
-	res, err := instance.c.Do(req)
-	if res.StatusCode != 200 {
-		return fmt.Errorf("wrong status code - %v", res.StatusCode)
-	}
+func deleteFeedback(feedbackType, userId, itemID string) error {
+	return instance.do("DELETE", fmt.Sprintf("/api/feedback/%v/%v/%v", feedbackType, userId, itemID), nil, nil)
+}
 
-	return err
+func InsertItem(item *Item) error {
+	return instance.do("POST", "/api/item", item, nil)
 }
 
-func Dislike(userId, itemId string) error {
-	var err error
+func Read(userId, itemId string) error {
+	return feedback(userId, itemId, "read")
+}
 
-	r := FeedbackRequest{
-		UserID:       userId,
-		ItemID:       itemId,
-		Timestamp:    time.Now(),
-		FeedbackType: "dislike",
+// ReadMany sends read feedback for many items at once
+func ReadMany(userId string, itemIds []string) error {
+	if len(itemIds) == 0 {
+		return nil
 	}
-	rBytes, _ := json.Marshal([]FeedbackRequest{r})
 
-	url := fmt.Sprintf("http://%v/api/feedback", instance.baseUrl)
-	res, err := instance.c.Post(url, "application/json", bytes.NewReader(rBytes))
-	if res.StatusCode != 200 {
-		return fmt.Errorf("wrong status code - %v", res.StatusCode)
+	now := time.Now()
+	r := make([]FeedbackRequest, len(itemIds))
+	for i, itemId := range itemIds {
+		r[i] = FeedbackRequest{
+			UserID:       userId,
+			ItemID:       itemId,
+			Timestamp:    now,
+			FeedbackType: "read",
+		}
 	}
+	return instance.do("POST", "/api/feedback", r, nil)
+}
 
-	return err
+func Star(userId, itemId string) error {
+	return feedback(userId, itemId, "star")
 }
 
-func Undislike(userId, itemID string) error {
-	var err error
+func Unstar(userId, itemID string) error {
+	return deleteFeedback("star", userId, itemID)
+}
 
-	url := fmt.Sprintf("http://%v/api/feedback/%v/%v/%v", instance.baseUrl, "dislike", userId, itemID)
-	req, _ := http.NewRequest("DELETE", url, nil)
-	res, err := instance.c.Do(req)
-	if res.StatusCode != 200 {
-		return fmt.Errorf("wrong status code - %v", res.StatusCode)
-	}
+func Dislike(userId, itemId string) error {
+	return feedback(userId, itemId, "dislike")
+}
 
-	return err
+func Undislike(userId, itemID string) error {
+	return deleteFeedback("dislike", userId, itemID)
 }
 
 func RecommendForUser(userID string, n int, offset int) ([]string, error) {
 	var items []string
-
-	url := fmt.Sprintf("http://%v/api/recommend/%v?n=%v&offset=%v", instance.baseUrl, userID, n, offset)
-	res, err := instance.c.Get(url)
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("wrong status code - %v", res.StatusCode)
-	}
-	if err != nil {
-		return nil, err
-	}
-
-	err = json.NewDecoder(res.Body).Decode(&items)
+	err := instance.do("GET", fmt.Sprintf("/api/recommend/%v?n=%v&offset=%v", userID, n, offset), nil, &items)
 	return items, err
 }
 
@@ -163,69 +170,35 @@ func RecommendPopular(sex string, n int) ([]string, error) {
 		ID string `json:"Id"`
 	}
 
-	url := fmt.Sprintf("http://%v/api/popular/%v?n=%v", instance.baseUrl, sex, n)
-	res, err := instance.c.Get(url)
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("wrong status code - %v", res.StatusCode)
-	}
+	err := instance.do("GET", fmt.Sprintf("/api/popular/%v?n=%v", sex, n), nil, &items)
 	if err != nil {
 		return nil, err
 	}
 
-	err = json.NewDecoder(res.Body).Decode(&items)
-
 	var resItems []string
 	for _, item := range items {
 		resItems = append(resItems, item.ID)
 	}
-	return resItems, err
+	return resItems, nil
 }
 
 func RecommendForUserAndCategory(userID string, category string, n int, offset int) ([]string, error) {
 	var items []string
-
-	url := fmt.Sprintf("http://%v/api/recommend/%v/%v?n=%v&offset=%v", instance.baseUrl, userID, category, n, offset)
-	res, err := instance.c.Get(url)
-	if res.StatusCode != 200 {
-		return nil, fmt.Errorf("wrong status code - %v", res.StatusCode)
-	}
-	if err != nil {
-		return nil, err
-	}
-
-	err = json.NewDecoder(res.Body).Decode(&items)
+	err := instance.do("GET", fmt.Sprintf("/api/recommend/%v/%v?n=%v&offset=%v", userID, category, n, offset), nil, &items)
 	return items, err
 }
 
 func Like(userId, itemID string) error {
-	var err error
-
-	r := FeedbackRequest{
-		UserID:       userId,
-		ItemID:       itemID,
-		Timestamp:    time.Now(),
-		FeedbackType: "like",
-	}
-	rBytes, _ := json.Marshal([]FeedbackRequest{r})
-
-	url := fmt.Sprintf("http://%v/api/feedback", instance.baseUrl)
-	res, err := instance.c.Post(url, "application/json", bytes.NewReader(rBytes))
-	if res.StatusCode != 200 {
-		return fmt.Errorf("wrong status code - %v", res.StatusCode)
-	}
-
-	return err
+	return feedback(userId, itemID, "like")
 }
 
 func Unlike(userId, itemID string) error {
-	var err error
-
-	url := fmt.Sprintf("http://%v/api/feedback/%v/%v/%v", instance.baseUrl, "like", userId, itemID)
-	req, _ := http.NewRequest("DELETE", url, nil)
-	res, err := instance.c.Do(req)
-	if res.StatusCode != 200 {
-		return fmt.Errorf("wrong status code - %v", res.StatusCode)
-	}
+	return deleteFeedback("like", userId, itemID)
+}
 
-	return err
+// ItemNeighbors returns items similar to the given one, most similar first
+func ItemNeighbors(itemID string, n int) ([]Score, error) {
+	var items []Score
+	err := instance.do("GET", fmt.Sprintf("/api/item/%v/neighbors?n=%v", itemID, n), nil, &items)
+	return items, err
 }
@@ -43,3 +43,8 @@ type User struct {
 func (p User) Marshal() ([]byte, error) {
 	return json.Marshal(p)
 }
+
+type Score struct {
+	ID    string  `json:"Id"`
+	Score float64 `json:"Score"`
+}
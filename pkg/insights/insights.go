@@ -0,0 +1,236 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package insights
+
+import (
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/sirupsen/logrus"
+	"time"
+)
+
+var instance *Insights
+
+const MaxGaps = 10
+
+// Looks with the number of items user owns, look_item_counts
+// is a materialised view refreshed by Run
+const ownedLooksCte = `WITH owned AS (
+	SELECT li.look_id, count(*) AS owned FROM look_items li
+	    JOIN users_wardrobe uw ON uw.wardrobe_item_id = li.wardrobe_item_id
+	WHERE uw.user_id = ?
+	GROUP BY li.look_id
+), coverage AS (
+	SELECT lic.look_id, lic.season, lic.items, coalesce(owned.owned, 0) AS owned
+	FROM look_item_counts lic LEFT JOIN owned ON owned.look_id = lic.look_id
+	WHERE lic.sex = ?
+)
+`
+
+const coverageColumns = `count(*) AS total,
+	count(*) FILTER (WHERE coverage.owned = coverage.items) AS full,
+	count(*) FILTER (WHERE coverage.owned > 0 AND coverage.owned < coverage.items) AS partial`
+
+const totalCoverageSql = ownedLooksCte + `SELECT 'all' AS key, 'all' AS name, ` + coverageColumns + ` FROM coverage`
+
+const seasonCoverageSql = ownedLooksCte + `SELECT coverage.season AS key, coverage.season AS name, ` + coverageColumns + `
+	FROM coverage GROUP BY coverage.season ORDER BY total DESC`
+
+const categoryCoverageSql = ownedLooksCte + `SELECT c.slug AS key, c.name, ` + coverageColumns + `
+	FROM coverage
+	    JOIN look_categories lc ON lc.look_id = coverage.look_id
+	    JOIN categories c ON c.id = lc.category_id AND c.deleted_at IS NULL
+	GROUP BY c.slug, c.name ORDER BY total DESC`
+
+// Items, that are the only missing piece of most looks
+const gapsSql = ownedLooksCte + `SELECT li.wardrobe_item_id AS item_id, count(*) AS unlocks
+	FROM coverage JOIN look_items li ON li.look_id = coverage.look_id
+	WHERE coverage.items - coverage.owned = 1
+	  AND li.wardrobe_item_id NOT IN (SELECT wardrobe_item_id FROM users_wardrobe WHERE user_id = ?)
+	GROUP BY li.wardrobe_item_id
+	ORDER BY unlocks DESC, li.wardrobe_item_id
+	LIMIT ?`
+
+type Config struct {
+	// RefreshInterval is how often materialised
+	// counts of look items are refreshed
+	RefreshInterval time.Duration
+}
+
+// Insights computes how much of the catalogue
+// user can wear with their own wardrobe
+type Insights struct {
+	cfg  Config
+	stop chan struct{}
+}
+
+// Coverage is the number of looks user can wear fully or partially
+type Coverage struct {
+	Key          string  `json:"key"`
+	Name         string  `json:"name"`
+	Total        int     `json:"total"`
+	Full         int     `json:"full"`
+	Partial      int     `json:"partial"`
+	FullShare    float64 `json:"full_share" gorm:"-"`
+	PartialShare float64 `json:"partial_share" gorm:"-"`
+}
+
+// Gap is an item user doesn't own yet, that
+// would make Unlocks more looks fully wearable
+type Gap struct {
+	Item    *models.WardrobeItem `json:"item"`
+	Unlocks int                  `json:"unlocks"`
+}
+
+type Report struct {
+	Total      *Coverage   `json:"total"`
+	Seasons    []*Coverage `json:"seasons"`
+	Categories []*Coverage `json:"categories"`
+	Gaps       []*Gap      `json:"gaps"`
+}
+
+func New(cfg Config) *Insights {
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = 15 * time.Minute
+	}
+
+	instance = &Insights{
+		cfg:  cfg,
+		stop: make(chan struct{}),
+	}
+	return instance
+}
+
+func Get() *Insights {
+	if instance == nil {
+		New(Config{})
+	}
+	return instance
+}
+
+// Run refreshes materialised counts until Stop is called
+func (i *Insights) Run() {
+	ticker := time.NewTicker(i.cfg.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := i.Refresh()
+			if err != nil {
+				logrus.Errorf("error refreshing look item counts: %v", err)
+			}
+		case <-i.stop:
+			return
+		}
+	}
+}
+
+func (i *Insights) Stop() {
+	close(i.stop)
+}
+
+func (i *Insights) Refresh() error {
+	return database.DB().Exec("REFRESH MATERIALIZED VIEW CONCURRENTLY look_item_counts").Error
+}
+
+// ForUser returns wardrobe coverage of looks for user's sex
+// per category and season, and items worth buying next
+func (i *Insights) ForUser(user *models.User) (*Report, error) {
+	report := &Report{
+		Seasons:    []*Coverage{},
+		Categories: []*Coverage{},
+	}
+
+	var total []*Coverage
+	err := database.DB().Raw(totalCoverageSql, user.ID, user.Sex).Scan(&total).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting total coverage: %v", err)
+	}
+	report.Total = &Coverage{Key: "all", Name: "all"}
+	if len(total) > 0 {
+		report.Total = total[0]
+	}
+
+	err = database.DB().Raw(seasonCoverageSql, user.ID, user.Sex).Scan(&report.Seasons).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting coverage by season: %v", err)
+	}
+
+	err = database.DB().Raw(categoryCoverageSql, user.ID, user.Sex).Scan(&report.Categories).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting coverage by category: %v", err)
+	}
+
+	shares(report.Total)
+	shares(report.Seasons...)
+	shares(report.Categories...)
+
+	report.Gaps, err = gaps(user)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+func shares(coverage ...*Coverage) {
+	for _, c := range coverage {
+		if c.Total > 0 {
+			c.FullShare = float64(c.Full) / float64(c.Total)
+			c.PartialShare = float64(c.Partial) / float64(c.Total)
+		}
+	}
+}
+
+func gaps(user *models.User) ([]*Gap, error) {
+	var counts []struct {
+		ItemID  uint
+		Unlocks int
+	}
+	err := database.DB().Raw(gapsSql, user.ID, user.Sex, user.ID, MaxGaps).Scan(&counts).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting wardrobe gaps: %v", err)
+	}
+
+	result := make([]*Gap, 0, len(counts))
+	if len(counts) == 0 {
+		return result, nil
+	}
+
+	ids := make([]uint, len(counts))
+	for i, c := range counts {
+		ids[i] = c.ItemID
+	}
+	var items []*models.WardrobeItem
+	err = database.DB().Preload("Urls.Brand").Preload("WardrobeCategory").Where("id IN ?", ids).Find(&items).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting wardrobe gap items: %v", err)
+	}
+	byID := make(map[uint]*models.WardrobeItem, len(items))
+	for _, item := range items {
+		byID[item.ID] = item
+	}
+
+	for _, c := range counts {
+		if item, ok := byID[c.ItemID]; ok {
+			result = append(result, &Gap{Item: item, Unlocks: c.Unlocks})
+		}
+	}
+	return result, nil
+}
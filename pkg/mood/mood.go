@@ -0,0 +1,204 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package mood
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Mood is one of the moods user can pick in the app. Looks
+// match a mood if they belong to one of its categories or
+// topics, or contain an item with one of its tags, those
+// are set by Configure
+type Mood struct {
+	Slug  string `json:"slug"`
+	Name  string `json:"name"`
+	Emoji string `json:"emoji"`
+	Color string `json:"color"`
+
+	Categories []string `json:"-"`
+	Topics     []string `json:"-"`
+	Tags       []string `json:"-"`
+}
+
+var moods = []*Mood{
+	{
+		Slug:  "calm",
+		Name:  "Спокойное",
+		Emoji: "😌",
+		Color: "#A7C4BC",
+	},
+	{
+		Slug:  "confident",
+		Name:  "Уверенное",
+		Emoji: "😎",
+		Color: "#2E2E2E",
+	},
+	{
+		Slug:  "romantic",
+		Name:  "Романтичное",
+		Emoji: "🥰",
+		Color: "#F4B6C2",
+	},
+	{
+		Slug:  "playful",
+		Name:  "Игривое",
+		Emoji: "🤪",
+		Color: "#FFC857",
+	},
+	{
+		Slug:  "cozy",
+		Name:  "Уютное",
+		Emoji: "☕️",
+		Color: "#C8A27C",
+	},
+	{
+		Slug:  "energetic",
+		Name:  "Энергичное",
+		Emoji: "⚡️",
+		Color: "#3DA5D9",
+	},
+}
+
+var bySlug = func() map[string]*Mood {
+	m := make(map[string]*Mood, len(moods))
+	for _, mood := range moods {
+		m[mood.Slug] = mood
+	}
+	return m
+}()
+
+// Rules are what makes a look match a mood
+type Rules struct {
+	Categories []string `json:"categories"`
+	Topics     []string `json:"topics"`
+	Tags       []string `json:"tags"`
+}
+
+type Config struct {
+	// Rules is a json object of mood slugs to their Rules,
+	// moods missing from it match no looks
+	Rules string
+}
+
+// Configure sets rules of moods, it's meant to be
+// called once before moods are used
+func Configure(cfg Config) error {
+	rules := make(map[string]*Rules)
+	if cfg.Rules != "" {
+		err := json.Unmarshal([]byte(cfg.Rules), &rules)
+		if err != nil {
+			return fmt.Errorf("error parsing mood rules: %v", err)
+		}
+	}
+	for slug := range rules {
+		if _, ok := bySlug[slug]; !ok {
+			return fmt.Errorf("unknown mood %v", slug)
+		}
+	}
+
+	for _, m := range moods {
+		r := rules[m.Slug]
+		if r == nil {
+			r = &Rules{}
+		}
+		m.Categories, m.Topics, m.Tags = r.Categories, r.Topics, r.Tags
+	}
+	return nil
+}
+
+// All returns every mood in the order they are shown in the app
+func All() []*Mood {
+	return moods
+}
+
+// Get returns mood by its slug, or nil if there is no such mood.
+// Users may still have free-form moods from older app versions
+func Get(slug string) *Mood {
+	return bySlug[strings.ToLower(strings.TrimSpace(slug))]
+}
+
+// HasRules reports whether any look can match the mood
+func (m *Mood) HasRules() bool {
+	return len(m.Categories) > 0 || len(m.Topics) > 0 || len(m.Tags) > 0
+}
+
+// LookFilter returns sql condition matching looks of the
+// mood, looks table must be available as "looks"
+func (m *Mood) LookFilter() (string, []interface{}) {
+	conds := []string{
+		`looks.id IN (SELECT lc.look_id FROM look_categories lc JOIN categories c ON c.id = lc.category_id WHERE c.slug IN ?)`,
+		`looks.id IN (SELECT tl.look_id FROM topic_looks tl JOIN topics t ON t.id = tl.topic_id WHERE t.slug IN ?)`,
+	}
+	args := []interface{}{m.Categories, m.Topics}
+
+	if len(m.Tags) > 0 {
+		quoted := make([]string, len(m.Tags))
+		for i, tag := range m.Tags {
+			quoted[i] = regexp.QuoteMeta(tag)
+		}
+		conds = append(conds, `looks.id IN (SELECT li.look_id FROM look_items li JOIN wardrobe_items wi ON wi.id = li.wardrobe_item_id WHERE wi.tags ~* ?)`)
+		args = append(args, strings.Join(quoted, "|"))
+	}
+
+	return "(" + strings.Join(conds, " OR ") + ")", args
+}
+
+// MatchingLooks returns which of the given looks match the mood
+func (m *Mood) MatchingLooks(ids []uint) (map[uint]bool, error) {
+	matching := make(map[uint]bool)
+	if len(ids) == 0 {
+		return matching, nil
+	}
+
+	filter, args := m.LookFilter()
+	var matched []uint
+	err := database.DB().Raw("SELECT looks.id FROM looks WHERE looks.id IN ? AND "+filter, append([]interface{}{ids}, args...)...).
+		Scan(&matched).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range matched {
+		matching[id] = true
+	}
+	return matching, nil
+}
+
+// Boost moves looks matching the mood to the front,
+// keeping the order within both groups
+func (m *Mood) Boost(looks []*models.Look) ([]*models.Look, error) {
+	ids := make([]uint, len(looks))
+	for i, look := range looks {
+		ids[i] = look.ID
+	}
+	matching, err := m.MatchingLooks(ids)
+	if err != nil {
+		return looks, err
+	}
+
+	sort.SliceStable(looks, func(i, j int) bool {
+		return matching[looks[i].ID] && !matching[looks[j].ID]
+	})
+	return looks, nil
+}
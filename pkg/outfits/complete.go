@@ -0,0 +1,120 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package outfits
+
+import (
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"sort"
+)
+
+// MaxSubstitutes is how many of user's own items
+// we suggest instead of every missing one
+const MaxSubstitutes = 3
+
+// Completion tells which items of a look user already owns,
+// and what to wear or buy instead of the rest
+type Completion struct {
+	Owned   []*models.WardrobeItem `json:"owned"`
+	Missing []*MissingItem         `json:"missing"`
+}
+
+type MissingItem struct {
+	Item *models.WardrobeItem `json:"item"`
+	// Substitutes are user's items of the same wardrobe
+	// category, the more shared tags the closer
+	Substitutes []*Substitute `json:"substitutes"`
+	// Links are shops the item can be bought at
+	Links []models.ItemURL `json:"links"`
+}
+
+type Substitute struct {
+	Item       *models.WardrobeItem `json:"item"`
+	SharedTags []string             `json:"shared_tags"`
+}
+
+// CompleteLook diffs look items against user's wardrobe. Look must
+// have its Items preloaded with WardrobeCategory and Urls.Brand
+func CompleteLook(user *models.User, look *models.Look) (*Completion, error) {
+	var wardrobe []*models.WardrobeItem
+	err := database.DB().Preload("WardrobeCategory").
+		Joins("JOIN users_wardrobe uw ON uw.wardrobe_item_id = wardrobe_items.id").
+		Where("uw.user_id = ?", user.ID).
+		Find(&wardrobe).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting user's wardrobe: %v", err)
+	}
+
+	owned := make(map[uint]bool, len(wardrobe))
+	byCategory := make(map[uint][]*models.WardrobeItem)
+	for _, item := range wardrobe {
+		owned[item.ID] = true
+		byCategory[item.WardrobeCategoryID] = append(byCategory[item.WardrobeCategoryID], item)
+	}
+
+	completion := &Completion{
+		Owned:   []*models.WardrobeItem{},
+		Missing: []*MissingItem{},
+	}
+	for _, item := range look.Items {
+		if owned[item.ID] {
+			completion.Owned = append(completion.Owned, item)
+			continue
+		}
+
+		links := item.Urls
+		if links == nil {
+			links = []models.ItemURL{}
+		}
+		completion.Missing = append(completion.Missing, &MissingItem{
+			Item:        item,
+			Substitutes: substitutes(item, byCategory[item.WardrobeCategoryID]),
+			Links:       links,
+		})
+	}
+
+	return completion, nil
+}
+
+// substitutes ranks candidates by the number of tags they
+// share with the item, keeping the closest ones. Candidates
+// sharing no tags are not substitutes
+func substitutes(item *models.WardrobeItem, candidates []*models.WardrobeItem) []*Substitute {
+	tags := tagSet(item)
+
+	result := make([]*Substitute, 0, len(candidates))
+	for _, candidate := range candidates {
+		s := &Substitute{Item: candidate, SharedTags: []string{}}
+		for _, tag := range Tags(candidate) {
+			if tags[tag] {
+				s.SharedTags = append(s.SharedTags, tag)
+			}
+		}
+		if len(s.SharedTags) > 0 {
+			result = append(result, s)
+		}
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return len(result[i].SharedTags) > len(result[j].SharedTags)
+	})
+	if len(result) > MaxSubstitutes {
+		result = result[:MaxSubstitutes]
+	}
+	return result
+}
@@ -0,0 +1,40 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package outfits
+
+import (
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"testing"
+)
+
+func TestSubstitutes(t *testing.T) {
+	item := &models.WardrobeItem{ID: 1, Tags: "denim, blue, slim"}
+	candidates := []*models.WardrobeItem{
+		{ID: 2, Tags: "cotton, black"},
+		{ID: 3, Tags: "denim, black"},
+		{ID: 4, Tags: "denim; blue"},
+		{ID: 5},
+	}
+
+	result := substitutes(item, candidates)
+	if len(result) != 2 {
+		t.Fatalf("got %v substitutes, want the 2 sharing tags", len(result))
+	}
+	if result[0].Item.ID != 4 || len(result[0].SharedTags) != 2 || result[1].Item.ID != 3 {
+		t.Errorf("got %v with %v first, then %v", result[0].Item.ID, result[0].SharedTags, result[1].Item.ID)
+	}
+}
@@ -0,0 +1,270 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package outfits
+
+import (
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/parasource/papaya-api/pkg/season"
+	"github.com/sirupsen/logrus"
+	"math"
+	"sort"
+)
+
+const (
+	DefaultLimit = 5
+	MaxLimit     = 20
+
+	// maxRepeats is how many generated outfits
+	// may share the same top, bottom or dress
+	maxRepeats = 2
+	// categoryPairsThreshold is how many looks two categories must
+	// share before we mention it in explanations
+	categoryPairsThreshold = 3
+)
+
+// Outfit is a complete outfit made of user's own items
+type Outfit struct {
+	// Items are keyed by slot
+	Items        map[string]*models.WardrobeItem `json:"items"`
+	Score        float64                         `json:"score"`
+	Explanations []string                        `json:"explanations"`
+	// Missing are slots user has no items for
+	Missing []string `json:"missing,omitempty"`
+}
+
+type Options struct {
+	Limit int
+	// Outerwear adds outerwear regardless of the season
+	Outerwear bool
+}
+
+// Generate proposes up to opts.Limit outfits made of user's wardrobe,
+// best first. Items are scored pairwise by how often they, and their
+// categories, are used together in looks, and by stylist's rules
+func Generate(user *models.User, opts Options) ([]*Outfit, error) {
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultLimit
+	}
+	if opts.Limit > MaxLimit {
+		opts.Limit = MaxLimit
+	}
+
+	var items []*models.WardrobeItem
+	err := database.DB().Preload("WardrobeCategory").
+		Joins("JOIN users_wardrobe uw ON uw.wardrobe_item_id = wardrobe_items.id").
+		Where("uw.user_id = ?", user.ID).
+		Where("wardrobe_items.sex = ? OR wardrobe_items.sex = 'unisex'", user.Sex).
+		Find(&items).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting user's wardrobe: %v", err)
+	}
+
+	bySlot := make(map[string][]*models.WardrobeItem)
+	ids := make([]uint, 0, len(items))
+	for _, item := range items {
+		slot := SlotOf(item)
+		if slot == "" {
+			continue
+		}
+		bySlot[slot] = append(bySlot[slot], item)
+		ids = append(ids, item.ID)
+	}
+
+	var bases [][]*models.WardrobeItem
+	for _, top := range bySlot[SlotTop] {
+		for _, bottom := range bySlot[SlotBottom] {
+			bases = append(bases, []*models.WardrobeItem{top, bottom})
+		}
+	}
+	for _, dress := range bySlot[SlotDress] {
+		bases = append(bases, []*models.WardrobeItem{dress})
+	}
+	if len(bases) == 0 {
+		return nil, nil
+	}
+
+	s, err := newScorer(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	current := season.ForUser(user)
+	withOuterwear := opts.Outerwear || current != season.Summer
+
+	candidates := make([]*Outfit, 0, len(bases))
+	for _, base := range bases {
+		outfit := s.complete(base, bySlot, withOuterwear)
+		if current != season.Summer && outfit.Items[SlotOuterwear] != nil {
+			outfit.Explanations = append(outfit.Explanations, "Добавили верхнюю одежду по сезону")
+		}
+		candidates = append(candidates, outfit)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+
+	// Keep results diverse, so that the best top
+	// doesn't end up in every outfit
+	used := make(map[uint]int)
+	var result []*Outfit
+	for _, outfit := range candidates {
+		if len(result) == opts.Limit {
+			break
+		}
+		if overused(outfit, used) {
+			continue
+		}
+		for _, slot := range []string{SlotTop, SlotBottom, SlotDress} {
+			if item := outfit.Items[slot]; item != nil {
+				used[item.ID]++
+			}
+		}
+		result = append(result, outfit)
+	}
+
+	return result, nil
+}
+
+func overused(outfit *Outfit, used map[uint]int) bool {
+	for _, slot := range []string{SlotTop, SlotBottom, SlotDress} {
+		if item := outfit.Items[slot]; item != nil && used[item.ID] >= maxRepeats {
+			return true
+		}
+	}
+	return false
+}
+
+// scorer scores pairs of items and explains the score
+type scorer struct {
+	items      pairs
+	categories pairs
+}
+
+func newScorer(ids []uint) (*scorer, error) {
+	items, err := itemPairs(ids)
+	if err != nil {
+		return nil, err
+	}
+
+	// Outfits can still be built from rules alone
+	categories, err := categoryPairs()
+	if err != nil {
+		logrus.Warnf("error getting categories co-occurrence: %v", err)
+		categories = pairs{}
+	}
+
+	return &scorer{items: items, categories: categories}, nil
+}
+
+func (s *scorer) pair(a, b *models.WardrobeItem) (float64, []string) {
+	var (
+		score   float64
+		reasons []string
+	)
+
+	if n := s.items.get(a.ID, b.ID); n > 0 {
+		score += 2 * math.Log1p(float64(n))
+		reasons = append(reasons, fmt.Sprintf("«%v» и «%v» вместе встречались в образах %v раз", a.Name, b.Name, n))
+	}
+
+	if a.WardrobeCategoryID != b.WardrobeCategoryID {
+		if n := s.categories.get(a.WardrobeCategoryID, b.WardrobeCategoryID); n > 0 {
+			score += math.Log1p(float64(n)) / 2
+			if n >= categoryPairsThreshold {
+				reasons = append(reasons, fmt.Sprintf("«%v» часто носят с «%v»", a.WardrobeCategory.Name, b.WardrobeCategory.Name))
+			}
+		}
+	}
+
+	for _, rule := range applyRules(a, b) {
+		score += rule.Score
+		reasons = append(reasons, rule.Reason)
+	}
+
+	return score, reasons
+}
+
+// best picks the item of the slot, that goes best with
+// items already in the outfit
+func (s *scorer) best(outfit []*models.WardrobeItem, options []*models.WardrobeItem) *models.WardrobeItem {
+	var (
+		best      *models.WardrobeItem
+		bestScore = math.Inf(-1)
+	)
+	for _, option := range options {
+		var score float64
+		for _, item := range outfit {
+			ps, _ := s.pair(option, item)
+			score += ps
+		}
+		if score > bestScore {
+			best, bestScore = option, score
+		}
+	}
+	return best
+}
+
+// complete adds shoes and optionally outerwear to the base of
+// the outfit, and scores it as the mean score of all pairs
+func (s *scorer) complete(base []*models.WardrobeItem, bySlot map[string][]*models.WardrobeItem, withOuterwear bool) *Outfit {
+	outfit := &Outfit{Items: make(map[string]*models.WardrobeItem)}
+
+	items := append([]*models.WardrobeItem{}, base...)
+	slots := []string{SlotShoes}
+	if withOuterwear {
+		slots = append(slots, SlotOuterwear)
+	}
+	for _, slot := range slots {
+		item := s.best(items, bySlot[slot])
+		if item == nil {
+			outfit.Missing = append(outfit.Missing, slot)
+			continue
+		}
+		items = append(items, item)
+	}
+
+	for _, item := range items {
+		outfit.Items[SlotOf(item)] = item
+	}
+
+	var (
+		total float64
+		n     int
+		seen  = make(map[string]bool)
+	)
+	for i := 0; i < len(items); i++ {
+		for j := i + 1; j < len(items); j++ {
+			score, reasons := s.pair(items[i], items[j])
+			total += score
+			n++
+			for _, reason := range reasons {
+				if !seen[reason] {
+					seen[reason] = true
+					outfit.Explanations = append(outfit.Explanations, reason)
+				}
+			}
+		}
+	}
+	if n > 0 {
+		outfit.Score = total / float64(n)
+	}
+
+	return outfit
+}
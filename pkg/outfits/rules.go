@@ -0,0 +1,102 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package outfits
+
+import "github.com/parasource/papaya-api/pkg/database/models"
+
+// Rule is a stylist's rule for a pair of items. It applies when
+// one item has any of tags A and the other has any of tags B.
+// Empty B matches any item. Negative score means items clash
+type Rule struct {
+	Name   string
+	A      []string
+	B      []string
+	Score  float64
+	Reason string
+}
+
+var Rules = []Rule{
+	{
+		Name:   "sport-formal",
+		A:      []string{"спортивный", "кроссовки"},
+		B:      []string{"костюм", "пиджак", "классический"},
+		Score:  -3,
+		Reason: "Спортивные вещи не сочетаются с классикой",
+	},
+	{
+		Name:   "evening-sport",
+		A:      []string{"вечерний", "шелк", "кружево"},
+		B:      []string{"спортивный"},
+		Score:  -2,
+		Reason: "Вечерние вещи не носят со спортивными",
+	},
+	{
+		Name:   "print-print",
+		A:      []string{"принт"},
+		B:      []string{"принт"},
+		Score:  -1,
+		Reason: "Два принта в одном образе спорят друг с другом",
+	},
+	{
+		Name:   "cozy-knit",
+		A:      []string{"вязаный", "кашемир", "трикотаж"},
+		B:      []string{"джинсы", "деним"},
+		Score:  1,
+		Reason: "Трикотаж отлично сочетается с денимом",
+	},
+	{
+		Name:   "basic",
+		A:      []string{"базовый"},
+		Score:  0.5,
+		Reason: "Базовые вещи сочетаются со всем",
+	},
+}
+
+func (r *Rule) matches(a, b map[string]bool) bool {
+	return hasAny(a, r.A) && (len(r.B) == 0 || hasAny(b, r.B))
+}
+
+// applyRules returns rules applying to the pair of items in any order
+func applyRules(a, b *models.WardrobeItem) []*Rule {
+	tagsA, tagsB := tagSet(a), tagSet(b)
+
+	var applied []*Rule
+	for i := range Rules {
+		r := &Rules[i]
+		if r.matches(tagsA, tagsB) || r.matches(tagsB, tagsA) {
+			applied = append(applied, r)
+		}
+	}
+	return applied
+}
+
+func tagSet(item *models.WardrobeItem) map[string]bool {
+	set := make(map[string]bool)
+	for _, tag := range Tags(item) {
+		set[tag] = true
+	}
+	return set
+}
+
+func hasAny(set map[string]bool, tags []string) bool {
+	for _, tag := range tags {
+		if set[tag] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package outfits
+
+import (
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"strings"
+	"unicode"
+)
+
+// Slots of an outfit. A dress takes both top and bottom
+const (
+	SlotTop       = "top"
+	SlotBottom    = "bottom"
+	SlotDress     = "dress"
+	SlotShoes     = "shoes"
+	SlotOuterwear = "outerwear"
+)
+
+// SlotCategories are values of WardrobeCategory.ParentCategory
+// belonging to every slot. Categories not listed here, like
+// accessories, never make it into generated outfits
+var SlotCategories = map[string][]string{
+	SlotTop:       {"tops", "top", "верх", "футболки", "рубашки", "свитеры"},
+	SlotBottom:    {"bottoms", "bottom", "низ", "брюки", "джинсы", "юбки", "шорты"},
+	SlotDress:     {"dresses", "dress", "платья", "комбинезоны"},
+	SlotShoes:     {"shoes", "обувь"},
+	SlotOuterwear: {"outerwear", "верхняя одежда"},
+}
+
+var slotByCategory = func() map[string]string {
+	m := make(map[string]string)
+	for slot, categories := range SlotCategories {
+		for _, c := range categories {
+			m[c] = slot
+		}
+	}
+	return m
+}()
+
+// SlotOf returns outfit slot of the item, or empty
+// string if the item doesn't fit any slot
+func SlotOf(item *models.WardrobeItem) string {
+	if slot, ok := slotByCategory[strings.ToLower(strings.TrimSpace(item.WardrobeCategory.ParentCategory))]; ok {
+		return slot
+	}
+	// Some categories have no parent, then
+	// the category itself might tell the slot
+	return slotByCategory[strings.ToLower(strings.TrimSpace(item.WardrobeCategory.Slug))]
+}
+
+// Tags splits item tags, they are stored as a free-form
+// string separated by commas or spaces
+func Tags(item *models.WardrobeItem) []string {
+	return strings.FieldsFunc(strings.ToLower(item.Tags), func(r rune) bool {
+		return r == ',' || r == ';' || unicode.IsSpace(r)
+	})
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package outfits
+
+import (
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database"
+	"sync"
+	"time"
+)
+
+// CategoryStatsTTL is how long category co-occurrence is kept in
+// memory. It's mined from all looks and changes very slowly
+var CategoryStatsTTL = time.Hour
+
+// How many times two of the given items were used in the same look
+const itemPairsSql = `SELECT li1.wardrobe_item_id AS a, li2.wardrobe_item_id AS b, count(*) AS count
+	FROM look_items li1
+	    JOIN look_items li2 ON li1.look_id = li2.look_id AND li1.wardrobe_item_id < li2.wardrobe_item_id
+	    JOIN looks ON looks.id = li1.look_id AND looks.deleted_at IS NULL
+	WHERE li1.wardrobe_item_id IN ? AND li2.wardrobe_item_id IN ?
+	GROUP BY 1, 2`
+
+// How many looks contain items of both wardrobe categories
+const categoryPairsSql = `SELECT wi1.wardrobe_category_id AS a, wi2.wardrobe_category_id AS b, count(DISTINCT li1.look_id) AS count
+	FROM look_items li1
+	    JOIN look_items li2 ON li1.look_id = li2.look_id
+	    JOIN wardrobe_items wi1 ON wi1.id = li1.wardrobe_item_id
+	    JOIN wardrobe_items wi2 ON wi2.id = li2.wardrobe_item_id
+	    JOIN looks ON looks.id = li1.look_id AND looks.deleted_at IS NULL
+	WHERE wi1.wardrobe_category_id < wi2.wardrobe_category_id
+	GROUP BY 1, 2`
+
+type pairCount struct {
+	A     uint
+	B     uint
+	Count int
+}
+
+// pairs counts co-occurrence of unordered pairs of ids
+type pairs map[[2]uint]int
+
+func newPairs(counts []pairCount) pairs {
+	p := make(pairs, len(counts))
+	for _, c := range counts {
+		p[pairKey(c.A, c.B)] = c.Count
+	}
+	return p
+}
+
+func (p pairs) get(a, b uint) int {
+	return p[pairKey(a, b)]
+}
+
+func pairKey(a, b uint) [2]uint {
+	if a > b {
+		a, b = b, a
+	}
+	return [2]uint{a, b}
+}
+
+var categoryStats struct {
+	mu       sync.Mutex
+	pairs    pairs
+	loadedAt time.Time
+}
+
+// categoryPairs returns co-occurrence of wardrobe categories,
+// reloading it from the database once it gets stale
+func categoryPairs() (pairs, error) {
+	categoryStats.mu.Lock()
+	defer categoryStats.mu.Unlock()
+
+	if categoryStats.pairs != nil && time.Since(categoryStats.loadedAt) < CategoryStatsTTL {
+		return categoryStats.pairs, nil
+	}
+
+	var counts []pairCount
+	err := database.DB().Raw(categoryPairsSql).Scan(&counts).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting wardrobe categories co-occurrence: %v", err)
+	}
+
+	categoryStats.pairs = newPairs(counts)
+	categoryStats.loadedAt = time.Now()
+	return categoryStats.pairs, nil
+}
+
+func itemPairs(ids []uint) (pairs, error) {
+	var counts []pairCount
+	err := database.DB().Raw(itemPairsSql, ids, ids).Scan(&counts).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting wardrobe items co-occurrence: %v", err)
+	}
+	return newPairs(counts), nil
+}
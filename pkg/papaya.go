@@ -21,8 +21,18 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/parasource/papaya-api/api/v1"
 	v2 "github.com/parasource/papaya-api/api/v2"
+	"github.com/parasource/papaya-api/api/v2/middleware"
+	"github.com/parasource/papaya-api/pkg/adviser"
 	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/embeddings"
+	"github.com/parasource/papaya-api/pkg/events"
+	"github.com/parasource/papaya-api/pkg/experiments"
 	"github.com/parasource/papaya-api/pkg/gorse"
+	"github.com/parasource/papaya-api/pkg/insights"
+	"github.com/parasource/papaya-api/pkg/mood"
+	"github.com/parasource/papaya-api/pkg/search"
+	"github.com/parasource/papaya-api/pkg/today"
+	"github.com/parasource/papaya-api/pkg/weather"
 	"github.com/sirupsen/logrus"
 	"net"
 )
@@ -36,14 +46,43 @@ type Config struct {
 	HttpPort        string `json:"http_port"`
 	AdviserHost     string `json:"adviser_host"`
 	AdviserPort     string `json:"adviser_port"`
+	FeedWeights     string `json:"feed_weights"`
+	SimilarWeights  string `json:"similar_weights"`
+	RedisAddress    string `json:"redis_address"`
+	RedisPassword   string `json:"redis_password"`
+	RedisDatabase   int    `json:"redis_database"`
+	WeatherProvider string `json:"weather_provider"`
+	WeatherAddress  string `json:"weather_address"`
+	WeatherFixture  string `json:"weather_fixture"`
+	Experiments     string `json:"experiments"`
+	MoodRules       string `json:"mood_rules"`
+
+	SearchBackend     string `json:"search_backend"`
+	SearchAddress     string `json:"search_address"`
+	SearchAPIKey      string `json:"-"`
+	SearchIndexPrefix string `json:"search_index_prefix"`
+
+	EmbeddingsProvider      string `json:"embeddings_provider"`
+	EmbeddingsAddress       string `json:"embeddings_address"`
+	EmbeddingsImagesAddress string `json:"embeddings_images_address"`
+
+	AdminToken      string `json:"-"`
+	CursorSecret    string `json:"-"`
 	ShutdownTimeout int    `json:"shutdown_timeout"`
 }
 
 type Papaya struct {
 	cfg Config
 
-	r       *gin.Engine
-	adviser *gorse.Gorse
+	r        *gin.Engine
+	adviser  *gorse.Gorse
+	today    *today.Rotator
+	insights *insights.Insights
+	search   *search.Search
+	events   *events.Recorder
+
+	// embeddings are nil if visual and semantic search are disabled
+	embeddings *embeddings.Embeddings
 }
 
 func NewPapaya(cfg Config, dbCfg database.Config) (*Papaya, error) {
@@ -68,10 +107,86 @@ func NewPapaya(cfg Config, dbCfg database.Config) (*Papaya, error) {
 	adviserUrl := net.JoinHostPort(cfg.AdviserHost, cfg.AdviserPort)
 	gorse.New(adviserUrl, 3)
 
+	_, err = adviser.New(adviser.Config{
+		FeedWeights:    cfg.FeedWeights,
+		SimilarWeights: cfg.SimilarWeights,
+		Redis: adviser.RedisConfig{
+			Address:  cfg.RedisAddress,
+			Password: cfg.RedisPassword,
+			Database: cfg.RedisDatabase,
+		},
+		CursorSecret: cfg.CursorSecret,
+	})
+	if err != nil {
+		logrus.Fatalf("error creating adviser: %v", err)
+	}
+
+	// Weather is optional, feed falls back to season only
+	_, err = weather.New(weather.Config{
+		Provider: cfg.WeatherProvider,
+		Address:  cfg.WeatherAddress,
+		Fixture:  cfg.WeatherFixture,
+	})
+	if err != nil {
+		logrus.Errorf("error creating weather provider: %v", err)
+	}
+
+	_, err = experiments.New(experiments.Config{
+		Experiments: cfg.Experiments,
+	})
+	if err != nil {
+		logrus.Fatalf("error creating experiments: %v", err)
+	}
+
+	err = mood.Configure(mood.Config{
+		Rules: cfg.MoodRules,
+	})
+	if err != nil {
+		logrus.Fatalf("error configuring moods: %v", err)
+	}
+
+	middleware.AdminToken = cfg.AdminToken
+
+	d.events = events.New(events.Config{})
+	d.today = today.New(today.Config{})
+	d.insights = insights.New(insights.Config{})
+	d.search, err = search.New(search.Config{
+		Backend:     cfg.SearchBackend,
+		Address:     cfg.SearchAddress,
+		APIKey:      cfg.SearchAPIKey,
+		IndexPrefix: cfg.SearchIndexPrefix,
+	})
+	if err != nil {
+		logrus.Fatalf("error creating search: %v", err)
+	}
+
+	// Visual and semantic search are optional, they need pgvector
+	d.embeddings, err = embeddings.New(embeddings.Config{
+		Provider:      cfg.EmbeddingsProvider,
+		Address:       cfg.EmbeddingsAddress,
+		ImagesAddress: cfg.EmbeddingsImagesAddress,
+	})
+	if err != nil {
+		logrus.Errorf("error creating embeddings: %v", err)
+	}
+
 	return d, nil
 }
 
 func (p *Papaya) Start() error {
+	go p.events.Run()
+	defer p.events.Stop()
+	go p.today.Run()
+	defer p.today.Stop()
+	go p.insights.Run()
+	defer p.insights.Stop()
+	go p.search.Run()
+	defer p.search.Stop()
+	if p.embeddings != nil {
+		go p.embeddings.Run()
+		defer p.embeddings.Stop()
+	}
+
 	err := p.r.Run(net.JoinHostPort(p.cfg.HttpHost, p.cfg.HttpPort))
 	if err != nil {
 		logrus.Fatalf("error running gin: %v", err)
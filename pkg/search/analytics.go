@@ -0,0 +1,155 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"strings"
+	"time"
+)
+
+// MaxReportQueries is how many queries every list of the report has
+const MaxReportQueries = 50
+
+// minLowCTRSearches is how many times a query should be searched
+// for, before its click-through rate is worth reporting
+const minLowCTRSearches = 5
+
+const recentSearches = `FROM search_records WHERE created_at > now() - ?::int * interval '1 day'`
+
+const summarySql = `SELECT count(*) AS searches, count(DISTINCT user_id) AS users,
+	    count(*) FILTER (WHERE results = 0) AS zero_results, count(clicked_at) AS clicks,
+	    coalesce(percentile_cont(0.5) WITHIN GROUP (ORDER BY latency_ms), 0) AS latency_p50,
+	    coalesce(percentile_cont(0.95) WITHIN GROUP (ORDER BY latency_ms), 0) AS latency_p95
+	` + recentSearches
+
+const queryStatsColumns = `SELECT query, count(*) AS searches, count(DISTINCT user_id) AS users,
+	    coalesce(avg(results), 0) AS avg_results, count(clicked_at) AS clicks,
+	    count(clicked_at)::float / count(*) AS ctr, coalesce(avg(latency_ms), 0) AS avg_latency_ms
+	`
+
+const topQueriesSql = queryStatsColumns + recentSearches + `
+	GROUP BY query ORDER BY searches DESC, query LIMIT ?`
+
+const zeroResultQueriesSql = queryStatsColumns + recentSearches + ` AND results = 0
+	GROUP BY query ORDER BY searches DESC, query LIMIT ?`
+
+// Queries, that find something, but users rarely open it
+const lowCTRQueriesSql = queryStatsColumns + recentSearches + ` AND results > 0
+	GROUP BY query HAVING count(*) >= ?
+	ORDER BY ctr, searches DESC, query LIMIT ?`
+
+const clickSql = `UPDATE search_records
+	SET clicked_type = ?, clicked_id = ?, clicked_position = ?, clicked_at = now()
+	WHERE id = ? AND user_id = ? AND clicked_at IS NULL`
+
+type Summary struct {
+	Searches    int     `json:"searches"`
+	Users       int     `json:"users"`
+	ZeroResults int     `json:"zero_results"`
+	ZeroRate    float64 `json:"zero_rate" gorm:"-"`
+	Clicks      int     `json:"clicks"`
+	CTR         float64 `json:"ctr" gorm:"-"`
+	LatencyP50  float64 `json:"latency_p50_ms"`
+	LatencyP95  float64 `json:"latency_p95_ms"`
+}
+
+type QueryStats struct {
+	Query        string  `json:"query"`
+	Searches     int     `json:"searches"`
+	Users        int     `json:"users"`
+	AvgResults   float64 `json:"avg_results"`
+	Clicks       int     `json:"clicks"`
+	CTR          float64 `json:"ctr"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+}
+
+type Report struct {
+	Summary           *Summary      `json:"summary"`
+	TopQueries        []*QueryStats `json:"top_queries"`
+	ZeroResultQueries []*QueryStats `json:"zero_result_queries"`
+	LowCTRQueries     []*QueryStats `json:"low_ctr_queries"`
+}
+
+// Record adds the search to user's history along
+// with the number of results and how long it took
+func Record(userID uint, query string, results int64, latency time.Duration) (*models.SearchRecord, error) {
+	sr := newRecord(userID, query, results, latency)
+	err := database.DB().Create(sr).Error
+	if err != nil {
+		return nil, fmt.Errorf("error recording search: %v", err)
+	}
+	return sr, nil
+}
+
+func newRecord(userID uint, query string, results int64, latency time.Duration) *models.SearchRecord {
+	latencyMs := latency.Milliseconds()
+	return &models.SearchRecord{
+		Query:     strings.ToLower(query),
+		UserID:    userID,
+		Visible:   true,
+		Results:   &results,
+		LatencyMs: &latencyMs,
+	}
+}
+
+// RecordClick remembers the result user opened from the search.
+// Only the first click of every search is kept
+func RecordClick(userID uint, searchID uint, entityType string, entityID uint, position int) error {
+	err := database.DB().Exec(clickSql, entityType, entityID, position, searchID, userID).Error
+	if err != nil {
+		return fmt.Errorf("error recording search click: %v", err)
+	}
+	return nil
+}
+
+// GetReport returns the most frequent queries, queries finding
+// nothing and queries, which results are rarely opened
+func GetReport(days int) (*Report, error) {
+	report := &Report{
+		Summary:           &Summary{},
+		TopQueries:        []*QueryStats{},
+		ZeroResultQueries: []*QueryStats{},
+		LowCTRQueries:     []*QueryStats{},
+	}
+
+	err := database.DB().Raw(summarySql, days).Scan(report.Summary).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting search summary: %v", err)
+	}
+	if report.Summary.Searches > 0 {
+		report.Summary.ZeroRate = float64(report.Summary.ZeroResults) / float64(report.Summary.Searches)
+		report.Summary.CTR = float64(report.Summary.Clicks) / float64(report.Summary.Searches)
+	}
+
+	err = database.DB().Raw(topQueriesSql, days, MaxReportQueries).Scan(&report.TopQueries).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting top queries: %v", err)
+	}
+	err = database.DB().Raw(zeroResultQueriesSql, days, MaxReportQueries).Scan(&report.ZeroResultQueries).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting zero result queries: %v", err)
+	}
+	err = database.DB().Raw(lowCTRQueriesSql, days, minLowCTRSearches, MaxReportQueries).Scan(&report.LowCTRQueries).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting low ctr queries: %v", err)
+	}
+
+	return report, nil
+}
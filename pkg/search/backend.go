@@ -0,0 +1,135 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/parasource/papaya-api/pkg/mood"
+	"time"
+)
+
+// Backends
+const (
+	BackendPostgres    = "postgres"
+	BackendMeilisearch = "meilisearch"
+)
+
+// Sections lists all sections, section names
+// are also names of tables they are searched in
+var Sections = []string{SectionLooks, SectionTopics, SectionWardrobe, SectionBrands, SectionArticles}
+
+// Backend finds documents matching a query. Documents are loaded
+// from the database by ids of hits, so backends only keep what's
+// needed to match, rank and filter them
+type Backend interface {
+	// Search returns the best hits of every section and
+	// facets of matching looks and wardrobe items
+	Search(q *Query) (*Hits, error)
+	// Looks returns a page of looks matching the query or
+	// containing wardrobe items, that match it
+	Looks(q *LooksQuery) (*LookHits, error)
+	// Suggest corrects a misspelled query, it returns
+	// an empty string if there is nothing to correct
+	Suggest(text string) (string, error)
+	// Index adds or replaces documents of the section with
+	// the ids, documents of deleted rows are removed
+	Index(section string, ids []uint) error
+	// Reindex rebuilds documents of all sections
+	Reindex() error
+}
+
+type Hit struct {
+	ID   uint
+	Rank float32
+}
+
+type SectionHits struct {
+	Hits []Hit
+	// Count is the number of all matching documents
+	Count int64
+}
+
+type Hits struct {
+	Sections map[string]*SectionHits
+	Facets   map[string][]*FacetValue
+}
+
+// Orders of looks search
+const (
+	// OrderWardrobe puts looks with matching wardrobe items first
+	OrderWardrobe = "wardrobe"
+	// OrderText ranks looks by text only
+	OrderText = "text"
+)
+
+// maxLookItems is how many best matching wardrobe
+// items make looks containing them match
+const maxLookItems = 5
+
+type LooksQuery struct {
+	Text string
+	Sex  string
+	// Mood, if it's set, leaves only looks matching it
+	Mood   *mood.Mood
+	Order  string
+	Offset int
+	Limit  int
+}
+
+type LookHits struct {
+	// Looks are hits of the page
+	Looks []Hit
+	// Items are wardrobe items, that made looks match
+	Items []Hit
+	// Total is the number of all matching looks
+	Total int64
+}
+
+// loader is implemented by backends keeping whole rows,
+// so that hits are not loaded from the database
+type loader interface {
+	Load(section string, hits []Hit) (interface{}, error)
+}
+
+// recorder is implemented by backends keeping search
+// history, it's kept in the database otherwise
+type recorder interface {
+	Record(userID uint, query string, results int64, latency time.Duration) (*models.SearchRecord, error)
+}
+
+func newBackend(cfg Config) (Backend, error) {
+	switch cfg.Backend {
+	case "", BackendPostgres:
+		return &Postgres{}, nil
+	case BackendMeilisearch:
+		if cfg.Address == "" {
+			return nil, fmt.Errorf("meilisearch address is not set")
+		}
+		return NewMeilisearch(cfg.Address, cfg.APIKey, cfg.IndexPrefix), nil
+	}
+	return nil, fmt.Errorf("unknown search backend %v", cfg.Backend)
+}
+
+func isSection(name string) bool {
+	for _, section := range Sections {
+		if section == name {
+			return true
+		}
+	}
+	return false
+}
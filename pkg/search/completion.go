@@ -0,0 +1,292 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/parasource/papaya-api/pkg/outfits"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// MaxCompletions is the most completions returned at once
+const MaxCompletions = 20
+
+// completerInterval is how often changed wardrobe items
+// are checked for and names are put into tries again
+const completerInterval = time.Minute
+
+type Span struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type Completion struct {
+	Text string `json:"text"`
+	// Highlights are spans of the text matching the query, in runes
+	Highlights []Span `json:"highlights"`
+	// Score is the number of names and tags completed
+	Score int `json:"score"`
+}
+
+// trieNode is a rune of names, tokens of a name are
+// separated by spaces. Prepositions joined with the next
+// word have spaces in them too, but don't end a token
+type trieNode struct {
+	children map[rune]*trieNode
+	// count is the number of names passing through the node
+	count int
+	// end is set if a token ends at the node
+	end bool
+}
+
+func newTrieNode() *trieNode {
+	return &trieNode{children: make(map[rune]*trieNode)}
+}
+
+func (n *trieNode) insert(tokens []Token) {
+	n.count++
+	for i, t := range tokens {
+		if i > 0 {
+			n = n.child(' ')
+		}
+		for _, r := range t.Text {
+			n = n.child(r)
+		}
+		n.end = true
+	}
+}
+
+func (n *trieNode) child(r rune) *trieNode {
+	c, ok := n.children[r]
+	if !ok {
+		c = newTrieNode()
+		n.children[r] = c
+	}
+	c.count++
+	return c
+}
+
+func (n *trieNode) find(text string) *trieNode {
+	for _, r := range text {
+		n = n.children[r]
+		if n == nil {
+			return nil
+		}
+	}
+	return n
+}
+
+// collect adds the prefix completed to the end of every
+// token, that continues it. Next tokens are not followed
+func (n *trieNode) collect(prefix []rune, out *[]*Completion) {
+	if n.end {
+		*out = append(*out, &Completion{Text: string(prefix), Score: n.count})
+	}
+	for r, c := range n.children {
+		if r == ' ' && n.end {
+			continue
+		}
+		c.collect(append(prefix[:len(prefix):len(prefix)], r), out)
+	}
+}
+
+// complete returns the text with its last token completed
+// and, if the token is complete already, with the next one
+func (n *trieNode) complete(text string) []*Completion {
+	if text == "" {
+		return nil
+	}
+	node := n.find(text)
+	if node == nil {
+		return nil
+	}
+
+	var completions []*Completion
+	node.collect([]rune(text), &completions)
+	if next := node.children[' ']; node.end && next != nil {
+		next.collect([]rune(text+" "), &completions)
+	}
+
+	// The text itself is not a completion
+	for i, c := range completions {
+		if c.Text == text {
+			completions = append(completions[:i], completions[i+1:]...)
+			break
+		}
+	}
+	return completions
+}
+
+// completer keeps a trie of wardrobe item names and
+// tags for every sex, and one for all of them
+type completer struct {
+	mu    sync.RWMutex
+	tries map[string]*trieNode
+	// dirty is set when wardrobe items change
+	dirty int32
+}
+
+const completerSql = `SELECT name, tags, sex FROM wardrobe_items`
+
+func (c *completer) build() error {
+	var items []*models.WardrobeItem
+	err := database.DB().Raw(completerSql).Scan(&items).Error
+	if err != nil {
+		return fmt.Errorf("error loading wardrobe items: %v", err)
+	}
+
+	all := newTrieNode()
+	tries := map[string]*trieNode{"": all}
+	for _, item := range items {
+		t := tries[item.Sex]
+		if t == nil {
+			t = newTrieNode()
+			tries[item.Sex] = t
+		}
+
+		if tokens := Tokenize(item.Name); len(tokens) > 0 {
+			all.insert(tokens)
+			t.insert(tokens)
+		}
+		for _, tag := range outfits.Tags(item) {
+			if tokens := Tokenize(tag); len(tokens) > 0 {
+				all.insert(tokens)
+				t.insert(tokens)
+			}
+		}
+	}
+
+	c.mu.Lock()
+	c.tries = tries
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *completer) markDirty() {
+	atomic.StoreInt32(&c.dirty, 1)
+}
+
+// rebuild builds tries again if items changed since the last time
+func (c *completer) rebuild() error {
+	if !atomic.CompareAndSwapInt32(&c.dirty, 1, 0) {
+		return nil
+	}
+	err := c.build()
+	if err != nil {
+		c.markDirty()
+	}
+	return err
+}
+
+func (c *completer) complete(sex string, text string, limit int) []*Completion {
+	c.mu.RLock()
+	t := c.tries[sex]
+	if t == nil {
+		t = c.tries[""]
+	}
+	c.mu.RUnlock()
+	if t == nil {
+		return []*Completion{}
+	}
+
+	tokens := Tokenize(text)
+	if len(tokens) == 0 {
+		return []*Completion{}
+	}
+	query := joinTokens(tokens)
+
+	completions := t.complete(query)
+	highlight(completions, tokens)
+	rank(completions)
+	// Synonyms and transliterations of the query
+	// are completed too, but go after it
+	for _, v := range Variants(query) {
+		if len(completions) >= limit {
+			break
+		}
+		vTokens := Tokenize(v)
+		if len(vTokens) == 0 {
+			continue
+		}
+		more := t.complete(joinTokens(vTokens))
+		highlight(more, vTokens)
+		rank(more)
+		completions = append(completions, more...)
+	}
+
+	result := []*Completion{}
+	seen := make(map[string]bool)
+	for _, c := range completions {
+		if len(result) >= limit {
+			break
+		}
+		if !seen[c.Text] {
+			seen[c.Text] = true
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// highlight marks words of completions matching the query
+// tokens. Completions start with the joined tokens, so spans
+// are the same for all of them
+func highlight(completions []*Completion, tokens []Token) {
+	var spans []Span
+	offset := 0
+	for _, t := range tokens {
+		// Both words of a joined preposition are highlighted
+		for _, word := range strings.Split(t.Text, " ") {
+			length := utf8.RuneCountInString(word)
+			spans = append(spans, Span{Start: offset, End: offset + length})
+			offset += length + 1
+		}
+	}
+	for _, c := range completions {
+		c.Highlights = spans
+	}
+}
+
+// rank puts completions of more names first
+func rank(completions []*Completion) {
+	sort.Slice(completions, func(i, j int) bool {
+		a, b := completions[i], completions[j]
+		if a.Score != b.Score {
+			return a.Score > b.Score
+		}
+		if len(a.Text) != len(b.Text) {
+			return len(a.Text) < len(b.Text)
+		}
+		return a.Text < b.Text
+	})
+}
+
+// Complete returns wardrobe item names and tags starting with
+// the text, ranked by how many of them are completed
+func (s *Search) Complete(sex string, text string, limit int) []*Completion {
+	if limit <= 0 || limit > MaxCompletions {
+		limit = MaxCompletions
+	}
+	return s.completer.complete(sex, text, limit)
+}
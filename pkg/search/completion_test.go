@@ -0,0 +1,91 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// testCompleter completes the names and nothing else
+func testCompleter(names ...string) *completer {
+	t := newTrieNode()
+	for _, name := range names {
+		if tokens := Tokenize(name); len(tokens) > 0 {
+			t.insert(tokens)
+		}
+	}
+	return &completer{tries: map[string]*trieNode{"": t}}
+}
+
+func TestComplete(t *testing.T) {
+	c := testCompleter("Куртка с капюшоном", "Куртка кожаная", "Кожаная куртка", "Кеды")
+
+	completions := c.complete("male", "Курт", 10)
+	if len(completions) != 1 || completions[0].Text != "куртка" || completions[0].Score != 2 {
+		t.Fatalf("got %+v, want куртка of 2 names", completions)
+	}
+	if h := completions[0].Highlights; len(h) != 1 || h[0] != (Span{Start: 0, End: 4}) {
+		t.Errorf("got highlights %+v", h)
+	}
+
+	// Complete token is followed by the next one
+	var texts []string
+	for _, c := range c.complete("", "куртка", 10) {
+		texts = append(texts, c.Text)
+	}
+	if strings.Join(texts, ", ") != "куртка кожаная, куртка с капюшоном" {
+		t.Errorf("got %q", texts)
+	}
+
+	// Synonyms are completed too
+	completions = testCompleter("Кеды белые").complete("", "сникерсы", 10)
+	if len(completions) != 1 || completions[0].Text != "кеды белые" {
+		t.Errorf("got %+v, want completed synonym", completions)
+	}
+}
+
+func FuzzComplete(f *testing.F) {
+	f.Add("Куртка с капюшоном", "курт", 10)
+	f.Add("Куртка с капюшоном", "куртка с", 1)
+	f.Add("", "", 0)
+	f.Add("в", "в", 5)
+	f.Add("a\xffb", "\xff", 3)
+	f.Add("Кеды", "kedy", 10)
+	f.Fuzz(func(t *testing.T, name string, text string, limit int) {
+		if limit <= 0 || limit > MaxCompletions {
+			limit = MaxCompletions
+		}
+		c := testCompleter(name, "Куртка кожаная", "Кеды белые")
+
+		completions := c.complete("", text, limit)
+		if len(completions) > limit {
+			t.Fatalf("got %v completions, limit is %v", len(completions), limit)
+		}
+		for _, completion := range completions {
+			length := utf8.RuneCountInString(completion.Text)
+			end := 0
+			for _, span := range completion.Highlights {
+				if span.Start < end || span.End < span.Start || span.End > length {
+					t.Fatalf("highlight %+v of %q out of %v runes after %v", span, completion.Text, length, end)
+				}
+				end = span.End
+			}
+		}
+	})
+}
@@ -0,0 +1,62 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is an opaque position in search results
+type Cursor struct {
+	Offset int `json:"o"`
+	// Query is the corrected query, if the one user
+	// typed found nothing, later pages search it too
+	Query string `json:"q,omitempty"`
+}
+
+func DecodeCursor(s string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c Cursor
+	err = json.Unmarshal(data, &c)
+	if err != nil || c.Offset < 0 {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+func (c *Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// Next returns encoded cursor of the page following the one
+// with n results, or an empty string if it was the last one
+func (c *Cursor) Next(n int, total int64) string {
+	offset := c.Offset + n
+	if n == 0 || int64(offset) >= total {
+		return ""
+	}
+	return (&Cursor{Offset: offset, Query: c.Query}).Encode()
+}
@@ -0,0 +1,46 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import "testing"
+
+func TestCursorNext(t *testing.T) {
+	c := &Cursor{Offset: 20, Query: "джинсы"}
+
+	next, err := DecodeCursor(c.Next(20, 100))
+	if err != nil {
+		t.Fatalf("error decoding cursor: %v", err)
+	}
+	if next.Offset != 40 || next.Query != "джинсы" {
+		t.Errorf("got offset %v query %q, want 40 and the corrected query", next.Offset, next.Query)
+	}
+
+	if s := c.Next(20, 40); s != "" {
+		t.Errorf("got cursor %q after the last page", s)
+	}
+	if s := c.Next(0, 100); s != "" {
+		t.Errorf("got cursor %q after an empty page", s)
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	for _, s := range []string{"!!", "bm90IGpzb24", (&Cursor{Offset: -1}).Encode()} {
+		if _, err := DecodeCursor(s); err != ErrInvalidCursor {
+			t.Errorf("%q: got %v, want ErrInvalidCursor", s, err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/season"
+	"sort"
+)
+
+// Facets are counted over filtered results, so counts
+// always tell how many results are left after picking a value
+
+var seasonNames = map[string]string{
+	season.Winter: "Зима",
+	season.Spring: "Весна",
+	season.Summer: "Лето",
+	season.Autumn: "Осень",
+	season.Demi:   "Демисезон",
+	season.All:    "Всесезон",
+}
+
+func categoryFacet(q *Query) ([]*FacetValue, error) {
+	looks := textMatcher("looks", q.tsQuery).ids(looksScopes(q)...)
+
+	values := []*FacetValue{}
+	err := database.DB().Table("look_categories lc").
+		Select("c.slug AS key, c.name AS name, count(DISTINCT lc.look_id) AS count").
+		Joins("JOIN categories c ON c.id = lc.category_id AND c.deleted_at IS NULL").
+		Where("lc.look_id IN (?)", looks).
+		Group("c.slug, c.name").
+		Order("count DESC, c.slug").
+		Limit(maxFacetValues).
+		Scan(&values).Error
+	if err != nil {
+		return nil, fmt.Errorf("error counting category facet: %v", err)
+	}
+	return values, nil
+}
+
+func seasonFacet(q *Query) ([]*FacetValue, error) {
+	var rows []*FacetValue
+	err := textMatcher("looks", q.tsQuery).rows(looksScopes(q)...).
+		Select("lower(trim(looks.season)) AS key, count(*) AS count").
+		Where("trim(coalesce(looks.season, '')) <> ''").
+		Group("lower(trim(looks.season))").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("error counting season facet: %v", err)
+	}
+
+	counts := make(map[string]int, len(rows))
+	for _, r := range rows {
+		counts[r.Key] += r.Count
+	}
+	return seasonValues(counts), nil
+}
+
+// seasonValues merges counts of season synonyms into their
+// canonical seasons, so that keys can be sent back as filters
+func seasonValues(counts map[string]int) []*FacetValue {
+	merged := make(map[string]*FacetValue)
+	values := []*FacetValue{}
+	for key, count := range counts {
+		key = season.Normalize(key)
+		if key == "" {
+			continue
+		}
+		v, ok := merged[key]
+		if !ok {
+			v = &FacetValue{Key: key, Name: key}
+			if name, ok := seasonNames[key]; ok {
+				v.Name = name
+			}
+			merged[key] = v
+			values = append(values, v)
+		}
+		v.Count += count
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Count != values[j].Count {
+			return values[i].Count > values[j].Count
+		}
+		return values[i].Key < values[j].Key
+	})
+	if len(values) > maxFacetValues {
+		values = values[:maxFacetValues]
+	}
+	return values
+}
+
+func brandFacet(q *Query) ([]*FacetValue, error) {
+	items := textMatcher("wardrobe_items", q.tsQuery).ids(itemsScopes(q)...)
+
+	values := []*FacetValue{}
+	err := database.DB().Table("item_urls iu").
+		Select("b.slug AS key, b.name AS name, count(DISTINCT iu.item_id) AS count").
+		Joins("JOIN brands b ON b.id = iu.brand_id AND b.deleted_at IS NULL").
+		Where("iu.deleted_at IS NULL AND iu.item_id IN (?)", items).
+		Group("b.slug, b.name").
+		Order("count DESC, b.slug").
+		Limit(maxFacetValues).
+		Scan(&values).Error
+	if err != nil {
+		return nil, fmt.Errorf("error counting brand facet: %v", err)
+	}
+	return values, nil
+}
+
+func wardrobeCategoryFacet(q *Query) ([]*FacetValue, error) {
+	values := []*FacetValue{}
+	err := textMatcher("wardrobe_items", q.tsQuery).rows(itemsScopes(q)...).
+		Select("wc.slug AS key, wc.name AS name, count(*) AS count").
+		Joins("JOIN wardrobe_categories wc ON wc.id = wardrobe_items.wardrobe_category_id AND wc.deleted_at IS NULL").
+		Group("wc.slug, wc.name").
+		Order("count DESC, wc.slug").
+		Limit(maxFacetValues).
+		Scan(&values).Error
+	if err != nil {
+		return nil, fmt.Errorf("error counting wardrobe category facet: %v", err)
+	}
+	return values, nil
+}
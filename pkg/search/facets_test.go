@@ -0,0 +1,36 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import "testing"
+
+func TestSeasonValues(t *testing.T) {
+	values := seasonValues(map[string]int{"зима": 2, "winter": 1, "fall": 3, "": 5, "дождь": 1})
+	want := []FacetValue{
+		{Key: "autumn", Name: "Осень", Count: 3},
+		{Key: "winter", Name: "Зима", Count: 3},
+		{Key: "дождь", Name: "дождь", Count: 1},
+	}
+	if len(values) != len(want) {
+		t.Fatalf("got %v values, want %v", len(values), len(want))
+	}
+	for i, v := range values {
+		if *v != want[i] {
+			t.Errorf("got %+v, want %+v", *v, want[i])
+		}
+	}
+}
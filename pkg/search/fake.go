@@ -0,0 +1,297 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/parasource/papaya-api/pkg/mood"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Fake is an in-memory backend for tests. It keeps whole rows, so
+// nothing is loaded from the database, and matches them when every
+// word of the query is a prefix of one of their words. Only looks
+// and wardrobe items are searched, facets are never counted
+type Fake struct {
+	mu    sync.RWMutex
+	looks []*models.Look
+	items []*models.WardrobeItem
+
+	// Suggestions map misspelled queries to their corrections
+	Suggestions map[string]string
+	// Records are searches users made, in order
+	Records []*models.SearchRecord
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		Suggestions: make(map[string]string),
+	}
+}
+
+// AddLook adds the look, its categories, topics and
+// items are what it's matched with moods by
+func (f *Fake) AddLook(look *models.Look) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.looks = append(f.looks, look)
+}
+
+// AddItem adds the wardrobe item, looks contain it
+// if it's one of their items
+func (f *Fake) AddItem(item *models.WardrobeItem) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.items = append(f.items, item)
+}
+
+func (f *Fake) Search(q *Query) (*Hits, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	hits := &Hits{
+		Sections: make(map[string]*SectionHits, len(Sections)),
+		Facets:   make(map[string][]*FacetValue),
+	}
+	for _, section := range Sections {
+		hits.Sections[section] = &SectionHits{}
+	}
+
+	looks := hits.Sections[SectionLooks]
+	for _, look := range f.looks {
+		if rank := fakeRank(q.Text, look.Name, look.Desc); rank > 0 && fakeSex(q.Sex, look.Sex) {
+			looks.Hits = append(looks.Hits, Hit{ID: look.ID, Rank: rank})
+		}
+	}
+	items := hits.Sections[SectionWardrobe]
+	for _, item := range f.items {
+		if rank := fakeRank(q.Text, item.Name, item.Tags); rank > 0 && fakeSex(q.Sex, item.Sex) {
+			items.Hits = append(items.Hits, Hit{ID: item.ID, Rank: rank})
+		}
+	}
+
+	for _, h := range []*SectionHits{looks, items} {
+		sortHits(h.Hits)
+		h.Count = int64(len(h.Hits))
+		if len(h.Hits) > q.Limit {
+			h.Hits = h.Hits[:q.Limit]
+		}
+	}
+	return hits, nil
+}
+
+func (f *Fake) Looks(q *LooksQuery) (*LookHits, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	// Items are ranked like looks, the ones
+	// in more looks go first on equal rank
+	inLooks := make(map[uint]int)
+	for _, look := range f.looks {
+		for _, item := range look.Items {
+			inLooks[item.ID]++
+		}
+	}
+	hits := &LookHits{}
+	for _, item := range f.items {
+		if rank := fakeRank(q.Text, item.Name, item.Tags); rank > 0 && fakeSex(q.Sex, item.Sex) {
+			hits.Items = append(hits.Items, Hit{ID: item.ID, Rank: rank})
+		}
+	}
+	sort.SliceStable(hits.Items, func(i, j int) bool {
+		a, b := hits.Items[i], hits.Items[j]
+		if a.Rank != b.Rank {
+			return a.Rank > b.Rank
+		}
+		return inLooks[a.ID] > inLooks[b.ID]
+	})
+	if len(hits.Items) > maxLookItems {
+		hits.Items = hits.Items[:maxLookItems]
+	}
+	ordering := make(map[uint]int, len(hits.Items))
+	for i, item := range hits.Items {
+		ordering[item.ID] = len(hits.Items) - i
+	}
+
+	type fakeLookHit struct {
+		Hit
+		ordering int
+	}
+	var found []fakeLookHit
+	for _, look := range f.looks {
+		if !fakeSex(q.Sex, look.Sex) || (q.Mood != nil && !fakeMood(q.Mood, look)) {
+			continue
+		}
+		h := fakeLookHit{Hit: Hit{ID: look.ID, Rank: fakeRank(q.Text, look.Name, look.Desc)}}
+		for _, item := range look.Items {
+			if ordering[item.ID] > h.ordering {
+				h.ordering = ordering[item.ID]
+			}
+		}
+		if h.Rank > 0 || h.ordering > 0 {
+			found = append(found, h)
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		a, b := found[i], found[j]
+		if q.Order == OrderText && a.Rank != b.Rank {
+			return a.Rank > b.Rank
+		}
+		if a.ordering != b.ordering {
+			return a.ordering > b.ordering
+		}
+		if a.Rank != b.Rank {
+			return a.Rank > b.Rank
+		}
+		return a.ID < b.ID
+	})
+
+	hits.Total = int64(len(found))
+	for i := q.Offset; i < len(found) && i < q.Offset+q.Limit; i++ {
+		hits.Looks = append(hits.Looks, found[i].Hit)
+	}
+	return hits, nil
+}
+
+func (f *Fake) Suggest(text string) (string, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.Suggestions[text], nil
+}
+
+func (f *Fake) Record(userID uint, query string, results int64, latency time.Duration) (*models.SearchRecord, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	sr := newRecord(userID, query, results, latency)
+	sr.ID = uint(len(f.Records) + 1)
+	f.Records = append(f.Records, sr)
+	return sr, nil
+}
+
+// Index does nothing, rows are added to the fake directly
+func (f *Fake) Index(section string, ids []uint) error {
+	return nil
+}
+
+// Reindex does nothing, rows are added to the fake directly
+func (f *Fake) Reindex() error {
+	return nil
+}
+
+// Load returns rows of hits in order of hits
+func (f *Fake) Load(section string, hits []Hit) (interface{}, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	pos := position(hits)
+	switch section {
+	case SectionLooks:
+		looks := make([]*models.Look, len(hits))
+		for _, look := range f.looks {
+			if i, ok := pos[look.ID]; ok {
+				look.Rank = hits[i].Rank
+				looks[i] = look
+			}
+		}
+		return compactLooks(looks), nil
+	case SectionWardrobe:
+		items := make([]*models.WardrobeItem, len(hits))
+		for _, item := range f.items {
+			if i, ok := pos[item.ID]; ok {
+				items[i] = item
+			}
+		}
+		return compactItems(items), nil
+	case SectionTopics:
+		return []*models.Topic{}, nil
+	case SectionBrands:
+		return []*models.Brand{}, nil
+	}
+	return []*models.Article{}, nil
+}
+
+// fakeRank is the share of query words, that
+// are prefixes of words of the text fields
+func fakeRank(query string, fields ...string) float32 {
+	words := Tokenize(query)
+	if len(words) == 0 {
+		return 0
+	}
+	tokens := Tokenize(strings.Join(fields, " "))
+
+	var matched int
+	for _, w := range words {
+		for _, t := range tokens {
+			if strings.HasPrefix(t.Text, w.Text) {
+				matched++
+				break
+			}
+		}
+	}
+	if matched < len(words) {
+		return 0
+	}
+	return float32(matched) / float32(len(tokens))
+}
+
+func fakeSex(want string, sex string) bool {
+	return want == "" || want == sex
+}
+
+// fakeMood matches the look as mood's sql filter does
+func fakeMood(m *mood.Mood, look *models.Look) bool {
+	for _, c := range look.Categories {
+		if fakeContains(m.Categories, c.Slug) {
+			return true
+		}
+	}
+	for _, t := range look.Topics {
+		if fakeContains(m.Topics, t.Slug) {
+			return true
+		}
+	}
+	for _, item := range look.Items {
+		for _, tag := range m.Tags {
+			if strings.Contains(strings.ToLower(item.Tags), strings.ToLower(tag)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func fakeContains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func sortHits(hits []Hit) {
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Rank != hits[j].Rank {
+			return hits[i].Rank > hits[j].Rank
+		}
+		return hits[i].ID < hits[j].ID
+	})
+}
@@ -0,0 +1,45 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import "sort"
+
+// rrfK dampens the weight of top ranks, so that a single
+// list can't outweigh the others. 60 is the usual choice
+const rrfK = 60
+
+// Fuse merges rankings of ids with reciprocal rank fusion.
+// Every id scores 1/(k+rank) in every ranking it is in, so
+// ids ranked high by more rankings go first
+func Fuse(rankings ...[]uint) []uint {
+	scores := make(map[uint]float64)
+	var ids []uint
+	for _, ranking := range rankings {
+		for rank, id := range ranking {
+			if _, ok := scores[id]; !ok {
+				ids = append(ids, id)
+			}
+			scores[id] += 1 / float64(rrfK+rank+1)
+		}
+	}
+
+	// Stable keeps the order of the first ranking on ties
+	sort.SliceStable(ids, func(i, j int) bool {
+		return scores[ids[i]] > scores[ids[j]]
+	})
+	return ids
+}
@@ -0,0 +1,44 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestFuse(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		rankings [][]uint
+		want     []uint
+	}{
+		{"nothing", nil, nil},
+		{"empty rankings", [][]uint{{}, {}}, nil},
+		{"single ranking", [][]uint{{3, 1, 2}}, []uint{3, 1, 2}},
+		{"one ranking is empty", [][]uint{{}, {5, 6}}, []uint{5, 6}},
+		{"ties keep the first ranking", [][]uint{{1}, {2}}, []uint{1, 2}},
+		{"found by both go first", [][]uint{{1, 2, 3}, {3, 4}}, []uint{3, 1, 2, 4}},
+		{"rank matters", [][]uint{{1, 2}, {2, 1}, {2}}, []uint{2, 1}},
+		{"repeated id is kept once", [][]uint{{1, 1}, {2}}, []uint{1, 2}},
+	} {
+		got := Fuse(c.rankings...)
+		if fmt.Sprint(got) != fmt.Sprint(c.want) {
+			t.Errorf("%v: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
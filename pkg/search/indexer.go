@@ -0,0 +1,120 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"reflect"
+)
+
+// maxQueuedChanges is how many changed rows may wait to be
+// indexed, changes are dropped once the queue is full and
+// picked up by the next full reindex
+const maxQueuedChanges = 10000
+
+// change is a row of a section table, that was
+// created, updated or deleted through gorm
+type change struct {
+	section string
+	id      uint
+}
+
+// changeSet is ids of changed rows by section
+type changeSet map[string]map[uint]struct{}
+
+func (cs changeSet) add(c change) {
+	if cs[c.section] == nil {
+		cs[c.section] = make(map[uint]struct{})
+	}
+	cs[c.section][c.id] = struct{}{}
+}
+
+// registerCallbacks queues rows of section tables changed
+// through gorm and marks autofill to be rebuilt. Raw sql and changes of join tables, like
+// look items, are not seen and wait for the next full reindex
+func (s *Search) registerCallbacks(db *gorm.DB) error {
+	err := db.Callback().Create().After("gorm:create").Register("search:index_create", s.queueChanged)
+	if err != nil {
+		return err
+	}
+	err = db.Callback().Update().After("gorm:update").Register("search:index_update", s.queueChanged)
+	if err != nil {
+		return err
+	}
+	return db.Callback().Delete().After("gorm:delete").Register("search:index_delete", s.queueChanged)
+}
+
+func (s *Search) queueChanged(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil || !isSection(db.Statement.Schema.Table) {
+		return
+	}
+	if db.Statement.Schema.Table == SectionWardrobe {
+		s.completer.markDirty()
+	}
+	// Postgres backend searches tables themselves
+	if s.changes == nil {
+		return
+	}
+	field := db.Statement.Schema.PrioritizedPrimaryField
+	if field == nil {
+		return
+	}
+
+	queue := func(rv reflect.Value) {
+		rv = reflect.Indirect(rv)
+		if rv.Kind() != reflect.Struct {
+			return
+		}
+		value, zero := field.ValueOf(db.Statement.Context, rv)
+		id, ok := value.(uint)
+		if zero || !ok {
+			return
+		}
+
+		select {
+		case s.changes <- change{section: db.Statement.Schema.Table, id: id}:
+		default:
+			logrus.Warnf("search index queue is full, dropping change of %v %v", db.Statement.Schema.Table, id)
+		}
+	}
+
+	rv := db.Statement.ReflectValue
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			queue(rv.Index(i))
+		}
+	default:
+		queue(rv)
+	}
+}
+
+// index sends changed rows to the backend
+func (s *Search) index(changes changeSet) {
+	for section, set := range changes {
+		ids := make([]uint, 0, len(set))
+		for id := range set {
+			ids = append(ids, id)
+		}
+
+		err := s.backend.Index(section, ids)
+		if err != nil {
+			logrus.Errorf("error indexing %v %v: %v", len(ids), section, err)
+		}
+	}
+}
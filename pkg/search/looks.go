@@ -0,0 +1,100 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"strings"
+	"time"
+)
+
+type LooksResults struct {
+	Looks []*models.Look
+	// Items are wardrobe items, that made looks match
+	Items []*models.WardrobeItem
+	// Total is the number of all matching looks
+	Total int64
+}
+
+// Looks returns a page of looks matching the query and
+// wardrobe items, that made looks containing them match
+func (s *Search) Looks(q *LooksQuery) (*LooksResults, error) {
+	q.Text = strings.TrimSpace(q.Text)
+	if q.Limit <= 0 || q.Limit > MaxLooksLimit {
+		q.Limit = s.cfg.Limit
+	}
+	if q.Mood != nil && !q.Mood.HasRules() {
+		return &LooksResults{Looks: []*models.Look{}, Items: []*models.WardrobeItem{}}, nil
+	}
+
+	hits, err := s.backend.Looks(q)
+	if err != nil {
+		return nil, err
+	}
+
+	looks, err := s.load(SectionLooks, hits.Looks)
+	if err != nil {
+		return nil, err
+	}
+	items, err := s.load(SectionWardrobe, hits.Items)
+	if err != nil {
+		return nil, err
+	}
+	return &LooksResults{
+		Looks: looks.([]*models.Look),
+		Items: items.([]*models.WardrobeItem),
+		Total: hits.Total,
+	}, nil
+}
+
+// LoadLooks returns looks with the ids in the same order,
+// ones that don't exist are skipped
+func (s *Search) LoadLooks(ids []uint) ([]*models.Look, error) {
+	hits := make([]Hit, len(ids))
+	for i, id := range ids {
+		hits[i] = Hit{ID: id}
+	}
+	looks, err := s.load(SectionLooks, hits)
+	if err != nil {
+		return nil, err
+	}
+	return looks.([]*models.Look), nil
+}
+
+// Suggest corrects a misspelled query, it returns
+// an empty string if there is nothing to correct
+func (s *Search) Suggest(text string) (string, error) {
+	return s.backend.Suggest(strings.TrimSpace(text))
+}
+
+// Record adds the search to user's history, kept by the
+// backend if it keeps rows itself, or in the database
+func (s *Search) Record(userID uint, query string, results int64, latency time.Duration) (*models.SearchRecord, error) {
+	if r, ok := s.backend.(recorder); ok {
+		return r.Record(userID, query, results, latency)
+	}
+	return Record(userID, query, results, latency)
+}
+
+// load loads rows of hits from the backend, if it keeps
+// them, or from the database in order of hits
+func (s *Search) load(section string, hits []Hit) (interface{}, error) {
+	if l, ok := s.backend.(loader); ok {
+		return l.Load(section, hits)
+	}
+	return loadFuncs[section](hits)
+}
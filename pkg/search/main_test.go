@@ -0,0 +1,106 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// testSynonyms are the synonyms tests search with
+var testSynonyms = []synonymRow{
+	{GroupID: 1, Term: "кеды"},
+	{GroupID: 1, Term: "сникерсы"},
+}
+
+// testDatabase is the address of a throwaway postgres started
+// for tests, noDatabase tells why there is none
+var testDatabase, noDatabase string
+
+func TestMain(m *testing.M) {
+	synonyms.load = func() ([]synonymRow, error) {
+		return testSynonyms, nil
+	}
+
+	stop, err := startPostgres()
+	if err != nil {
+		noDatabase = err.Error()
+	}
+	code := m.Run()
+	if stop != nil {
+		stop()
+	}
+	os.Exit(code)
+}
+
+// startPostgres runs postgres installed locally with a new cluster
+// in a temporary directory. It only listens on a unix socket in
+// that directory, so it never clashes with a running postgres
+func startPostgres() (func(), error) {
+	if os.Geteuid() == 0 {
+		return nil, fmt.Errorf("postgres refuses to run as root")
+	}
+	bin, err := postgresBin()
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "papaya-pg")
+	if err != nil {
+		return nil, err
+	}
+	data := filepath.Join(dir, "data")
+	out, err := exec.Command(filepath.Join(bin, "initdb"), "-D", data, "-U", "papaya", "-A", "trust", "-E", "UTF8", "--locale=C").
+		CombinedOutput()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("error creating postgres cluster: %v: %s", err, out)
+	}
+
+	pgCtl := filepath.Join(bin, "pg_ctl")
+	out, err = exec.Command(pgCtl, "-D", data, "-l", filepath.Join(dir, "postgres.log"), "-w",
+		"-o", fmt.Sprintf("-k %v -c listen_addresses=''", dir), "start").CombinedOutput()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("error starting postgres: %v: %s", err, out)
+	}
+
+	testDatabase = fmt.Sprintf("host=%v user=papaya dbname=postgres sslmode=disable", dir)
+	return func() {
+		exec.Command(pgCtl, "-D", data, "-m", "immediate", "-w", "stop").Run()
+		os.RemoveAll(dir)
+	}, nil
+}
+
+// postgresBin returns the directory of postgres binaries, either
+// found in PATH or installed the way debian does it
+func postgresBin() (string, error) {
+	if path, err := exec.LookPath("pg_ctl"); err == nil {
+		return filepath.Dir(path), nil
+	}
+	dirs, _ := filepath.Glob("/usr/lib/postgresql/*/bin")
+	if len(dirs) == 0 {
+		return "", fmt.Errorf("postgres is not installed")
+	}
+	// The latest version
+	sort.Strings(dirs)
+	return dirs[len(dirs)-1], nil
+}
@@ -0,0 +1,594 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/mood"
+	"github.com/parasource/papaya-api/pkg/season"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reindexBatchSize is how many documents are sent at once
+const reindexBatchSize = 1000
+
+// Documents of every section as json, %v is a condition on ids
+var meilisearchDocumentsSql = map[string]string{
+	SectionLooks: `SELECT looks.id, json_build_object(
+	    'id', looks.id, 'name', looks.name, 'desc', looks."desc", 'sex', looks.sex,
+	    'season', lower(trim(coalesce(looks.season, ''))),
+	    'categories', coalesce((SELECT json_agg(DISTINCT c.slug) FROM look_categories lc
+	        JOIN categories c ON c.id = lc.category_id AND c.deleted_at IS NULL
+	        WHERE lc.look_id = looks.id), '[]'::json),
+	    'brands', coalesce((SELECT json_agg(DISTINCT b.slug) FROM look_items li
+	        JOIN item_urls iu ON iu.item_id = li.wardrobe_item_id AND iu.deleted_at IS NULL
+	        JOIN brands b ON b.id = iu.brand_id
+	        WHERE li.look_id = looks.id), '[]'::json),
+	    'wardrobe_categories', coalesce((SELECT json_agg(DISTINCT wc.slug) FROM look_items li
+	        JOIN wardrobe_items wi ON wi.id = li.wardrobe_item_id
+	        JOIN wardrobe_categories wc ON wc.id = wi.wardrobe_category_id
+	        WHERE li.look_id = looks.id), '[]'::json),
+	    'topics', coalesce((SELECT json_agg(DISTINCT t.slug) FROM topic_looks tl
+	        JOIN topics t ON t.id = tl.topic_id AND t.deleted_at IS NULL
+	        WHERE tl.look_id = looks.id), '[]'::json),
+	    'items', coalesce((SELECT json_agg(DISTINCT li.wardrobe_item_id) FROM look_items li
+	        WHERE li.look_id = looks.id), '[]'::json),
+	    'tags', coalesce((SELECT json_agg(DISTINCT lower(trim(t))) FROM look_items li
+	        JOIN wardrobe_items wi ON wi.id = li.wardrobe_item_id
+	        CROSS JOIN unnest(string_to_array(wi.tags, ',')) t
+	        WHERE li.look_id = looks.id AND trim(t) <> ''), '[]'::json)
+	)::text AS doc
+	FROM looks WHERE looks.deleted_at IS NULL AND looks.id %v
+	ORDER BY looks.id LIMIT ?`,
+	SectionTopics: `SELECT topics.id, json_build_object(
+	    'id', topics.id, 'name', topics.name, 'desc', topics."desc"
+	)::text AS doc
+	FROM topics WHERE topics.deleted_at IS NULL AND topics.id %v
+	ORDER BY topics.id LIMIT ?`,
+	SectionWardrobe: `SELECT wardrobe_items.id, json_build_object(
+	    'id', wardrobe_items.id, 'name', wardrobe_items.name, 'tags', wardrobe_items.tags,
+	    'sex', wardrobe_items.sex,
+	    'wardrobe_category', (SELECT wc.slug FROM wardrobe_categories wc
+	        WHERE wc.id = wardrobe_items.wardrobe_category_id),
+	    'brands', coalesce((SELECT json_agg(DISTINCT b.slug) FROM item_urls iu
+	        JOIN brands b ON b.id = iu.brand_id
+	        WHERE iu.item_id = wardrobe_items.id AND iu.deleted_at IS NULL), '[]'::json)
+	)::text AS doc
+	FROM wardrobe_items WHERE wardrobe_items.id %v
+	ORDER BY wardrobe_items.id LIMIT ?`,
+	SectionBrands: `SELECT brands.id, json_build_object(
+	    'id', brands.id, 'name', brands.name, 'slug', brands.slug
+	)::text AS doc
+	FROM brands WHERE brands.deleted_at IS NULL AND brands.id %v
+	ORDER BY brands.id LIMIT ?`,
+	SectionArticles: `SELECT articles.id, json_build_object(
+	    'id', articles.id, 'title', articles.title, 'text', articles.text, 'sex', articles.sex
+	)::text AS doc
+	FROM articles WHERE articles.deleted_at IS NULL AND articles.id %v
+	ORDER BY articles.id LIMIT ?`,
+}
+
+type meilisearchSettings struct {
+	SearchableAttributes []string            `json:"searchableAttributes"`
+	FilterableAttributes []string            `json:"filterableAttributes"`
+	Synonyms             map[string][]string `json:"synonyms"`
+}
+
+var meilisearchIndexSettings = map[string]*meilisearchSettings{
+	SectionLooks: {
+		SearchableAttributes: []string{"name", "desc"},
+		FilterableAttributes: []string{"sex", "season", "categories", "brands", "wardrobe_categories", "topics", "items", "tags"},
+	},
+	SectionTopics: {
+		SearchableAttributes: []string{"name", "desc"},
+		FilterableAttributes: []string{},
+	},
+	SectionWardrobe: {
+		SearchableAttributes: []string{"name", "tags"},
+		FilterableAttributes: []string{"sex", "brands", "wardrobe_category"},
+	},
+	SectionBrands: {
+		SearchableAttributes: []string{"name", "slug"},
+		FilterableAttributes: []string{"slug"},
+	},
+	SectionArticles: {
+		SearchableAttributes: []string{"title", "text"},
+		FilterableAttributes: []string{"sex"},
+	},
+}
+
+// Facets are counted by meilisearch from these attributes
+var meilisearchFacets = map[string]struct {
+	section   string
+	attribute string
+	// table facet value names are taken from
+	table string
+}{
+	FacetCategory:         {SectionLooks, "categories", "categories"},
+	FacetSeason:           {SectionLooks, "season", ""},
+	FacetBrand:            {SectionWardrobe, "brands", "brands"},
+	FacetWardrobeCategory: {SectionWardrobe, "wardrobe_category", "wardrobe_categories"},
+}
+
+// Meilisearch keeps a copy of searchable rows in meilisearch,
+// one index per section. Rows are still loaded from the
+// database, but only by primary keys
+type Meilisearch struct {
+	c       *http.Client
+	address string
+	apiKey  string
+	prefix  string
+}
+
+func NewMeilisearch(address string, apiKey string, prefix string) *Meilisearch {
+	return &Meilisearch{
+		c: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+		address: strings.TrimRight(address, "/"),
+		apiKey:  apiKey,
+		prefix:  prefix,
+	}
+}
+
+type meilisearchRequest struct {
+	Q                    string   `json:"q"`
+	Offset               int      `json:"offset,omitempty"`
+	Limit                int      `json:"limit"`
+	Filter               string   `json:"filter,omitempty"`
+	Facets               []string `json:"facets,omitempty"`
+	AttributesToRetrieve []string `json:"attributesToRetrieve"`
+	ShowRankingScore     bool     `json:"showRankingScore"`
+}
+
+type meilisearchResponse struct {
+	Hits []struct {
+		ID           uint    `json:"id"`
+		RankingScore float32 `json:"_rankingScore"`
+	} `json:"hits"`
+	EstimatedTotalHits int64                     `json:"estimatedTotalHits"`
+	FacetDistribution  map[string]map[string]int `json:"facetDistribution"`
+}
+
+// Search queries indexes of all sections in parallel
+func (m *Meilisearch) Search(q *Query) (*Hits, error) {
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+	)
+	responses := make(map[string]*meilisearchResponse, len(Sections))
+	for _, section := range Sections {
+		wg.Add(1)
+		go func(section string) {
+			defer wg.Done()
+			r := &meilisearchRequest{
+				Q:                    q.Text,
+				Limit:                q.Limit,
+				Filter:               meilisearchFilter(section, q),
+				AttributesToRetrieve: []string{"id"},
+				ShowRankingScore:     true,
+			}
+			for _, f := range meilisearchFacets {
+				if f.section == section {
+					r.Facets = append(r.Facets, f.attribute)
+				}
+			}
+
+			var res meilisearchResponse
+			err := m.do("POST", fmt.Sprintf("/indexes/%v/search", m.index(section)), r, &res)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("error searching %v: %v", section, err))
+				return
+			}
+			responses[section] = &res
+		}(section)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+
+	hits := &Hits{
+		Sections: make(map[string]*SectionHits, len(Sections)),
+		Facets:   make(map[string][]*FacetValue, len(meilisearchFacets)),
+	}
+	for section, res := range responses {
+		h := &SectionHits{
+			Count: res.EstimatedTotalHits,
+		}
+		for _, hit := range res.Hits {
+			h.Hits = append(h.Hits, Hit{ID: hit.ID, Rank: hit.RankingScore})
+		}
+		hits.Sections[section] = h
+	}
+	for key, f := range meilisearchFacets {
+		values, err := facetValues(responses[f.section].FacetDistribution[f.attribute], f.table)
+		if err != nil {
+			return nil, fmt.Errorf("error getting %v facet names: %v", key, err)
+		}
+		hits.Facets[key] = values
+	}
+	return hits, nil
+}
+
+func meilisearchFilter(section string, q *Query) string {
+	var conditions []string
+	in := func(attribute string, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		quoted := make([]string, len(values))
+		for i, v := range values {
+			quoted[i] = strconv.Quote(v)
+		}
+		conditions = append(conditions, fmt.Sprintf("%v IN [%v]", attribute, strings.Join(quoted, ", ")))
+	}
+
+	switch section {
+	case SectionLooks:
+		if q.Sex != "" {
+			in("sex", []string{q.Sex})
+		}
+		in("categories", q.Filters.Categories)
+		var seasons []string
+		for _, s := range q.Filters.Seasons {
+			seasons = append(seasons, season.Matching(season.Normalize(s))...)
+		}
+		in("season", seasons)
+		in("brands", q.Filters.Brands)
+		in("wardrobe_categories", q.Filters.WardrobeCategories)
+	case SectionWardrobe:
+		if q.Sex != "" {
+			in("sex", []string{q.Sex})
+		}
+		in("brands", q.Filters.Brands)
+		in("wardrobe_category", q.Filters.WardrobeCategories)
+	case SectionBrands:
+		in("slug", q.Filters.Brands)
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// Looks searches wardrobe items first, looks containing them
+// go along with the ones matching the query by text. Meilisearch
+// can't rank looks by items, so they are two lists one after another
+func (m *Meilisearch) Looks(q *LooksQuery) (*LookHits, error) {
+	var filter []string
+	if q.Sex != "" {
+		filter = append(filter, fmt.Sprintf("sex = %v", strconv.Quote(q.Sex)))
+	}
+
+	var items meilisearchResponse
+	err := m.do("POST", fmt.Sprintf("/indexes/%v/search", m.index(SectionWardrobe)), &meilisearchRequest{
+		Q:                    q.Text,
+		Limit:                maxLookItems,
+		Filter:               strings.Join(filter, " AND "),
+		AttributesToRetrieve: []string{"id"},
+		ShowRankingScore:     true,
+	}, &items)
+	if err != nil {
+		return nil, fmt.Errorf("error searching wardrobe items: %v", err)
+	}
+
+	hits := &LookHits{}
+	ids := make([]string, len(items.Hits))
+	for i, hit := range items.Hits {
+		hits.Items = append(hits.Items, Hit{ID: hit.ID, Rank: hit.RankingScore})
+		ids[i] = strconv.Itoa(int(hit.ID))
+	}
+	if q.Mood != nil {
+		filter = append(filter, meilisearchMoodFilter(q.Mood))
+	}
+
+	// Looks matching by text, then the ones made of items
+	lists := []*meilisearchRequest{{
+		Q:      q.Text,
+		Filter: strings.Join(filter, " AND "),
+	}}
+	if len(ids) > 0 {
+		inItems := fmt.Sprintf("items IN [%v]", strings.Join(ids, ", "))
+		lists[0].Filter = strings.Join(append(filter, "NOT "+inItems), " AND ")
+		withItems := &meilisearchRequest{
+			Filter: strings.Join(append(filter, inItems), " AND "),
+		}
+		if q.Order == OrderText {
+			lists = append(lists, withItems)
+		} else {
+			lists = append([]*meilisearchRequest{withItems}, lists...)
+		}
+	}
+
+	offset, limit := q.Offset, q.Limit
+	for _, r := range lists {
+		r.Offset, r.Limit = offset, limit
+		r.AttributesToRetrieve = []string{"id"}
+		r.ShowRankingScore = true
+
+		var res meilisearchResponse
+		err = m.do("POST", fmt.Sprintf("/indexes/%v/search", m.index(SectionLooks)), r, &res)
+		if err != nil {
+			return nil, fmt.Errorf("error searching looks: %v", err)
+		}
+		for _, hit := range res.Hits {
+			hits.Looks = append(hits.Looks, Hit{ID: hit.ID, Rank: hit.RankingScore})
+		}
+		hits.Total += res.EstimatedTotalHits
+
+		// The next list starts where this one ends
+		offset -= int(res.EstimatedTotalHits)
+		if offset < 0 {
+			offset = 0
+		}
+		limit -= len(res.Hits)
+	}
+	return hits, nil
+}
+
+// meilisearchMoodFilter matches looks of the mood's categories
+// and topics, or containing items tagged with one of its tags.
+// Moods without rules never get here
+func meilisearchMoodFilter(m *mood.Mood) string {
+	var conditions []string
+	in := func(attribute string, values []string) {
+		quoted := make([]string, 0, len(values))
+		for _, v := range values {
+			quoted = append(quoted, strconv.Quote(strings.ToLower(v)))
+		}
+		if len(quoted) > 0 {
+			conditions = append(conditions, fmt.Sprintf("%v IN [%v]", attribute, strings.Join(quoted, ", ")))
+		}
+	}
+	in("categories", m.Categories)
+	in("topics", m.Topics)
+	in("tags", m.Tags)
+	return "(" + strings.Join(conditions, " OR ") + ")"
+}
+
+// Suggest corrects the query to words of searchable names. Meilisearch
+// tolerates typos, but not ones changing most of a short word
+func (m *Meilisearch) Suggest(text string) (string, error) {
+	return Suggest(text)
+}
+
+// facetValues turns facet distribution into the most
+// frequent facet values, named after rows of the table
+func facetValues(distribution map[string]int, table string) ([]*FacetValue, error) {
+	if table == "" {
+		// Seasons aren't stored anywhere
+		return seasonValues(distribution), nil
+	}
+
+	values := []*FacetValue{}
+	for key, count := range distribution {
+		if key != "" {
+			values = append(values, &FacetValue{Key: key, Name: key, Count: count})
+		}
+	}
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Count != values[j].Count {
+			return values[i].Count > values[j].Count
+		}
+		return values[i].Key < values[j].Key
+	})
+	if len(values) > maxFacetValues {
+		values = values[:maxFacetValues]
+	}
+
+	if len(values) == 0 {
+		return values, nil
+	}
+
+	keys := make([]string, len(values))
+	for i, v := range values {
+		keys[i] = v.Key
+	}
+	var names []struct {
+		Slug string
+		Name string
+	}
+	err := database.DB().Table(table).Select("slug, name").
+		Where("slug IN ? AND deleted_at IS NULL", keys).
+		Scan(&names).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, n := range names {
+		for _, v := range values {
+			if v.Key == n.Slug {
+				v.Name = n.Name
+			}
+		}
+	}
+	return values, nil
+}
+
+type meilisearchDocument struct {
+	ID  uint
+	Doc string
+}
+
+func (m *Meilisearch) documents(section string, condition string, args ...interface{}) ([]meilisearchDocument, error) {
+	var docs []meilisearchDocument
+	err := database.DB().Raw(fmt.Sprintf(meilisearchDocumentsSql[section], condition), args...).Scan(&docs).Error
+	return docs, err
+}
+
+func (m *Meilisearch) send(index string, docs []meilisearchDocument) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	body := make([]json.RawMessage, len(docs))
+	for i, d := range docs {
+		body[i] = json.RawMessage(d.Doc)
+	}
+	return m.do("POST", fmt.Sprintf("/indexes/%v/documents", index), body, nil)
+}
+
+// Index replaces documents of the rows and
+// removes documents of the deleted ones
+func (m *Meilisearch) Index(section string, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	docs, err := m.documents(section, "IN ?", ids, len(ids))
+	if err != nil {
+		return fmt.Errorf("error getting documents: %v", err)
+	}
+	err = m.send(m.index(section), docs)
+	if err != nil {
+		return fmt.Errorf("error sending documents: %v", err)
+	}
+
+	found := make(map[uint]bool, len(docs))
+	for _, d := range docs {
+		found[d.ID] = true
+	}
+	var deleted []uint
+	for _, id := range ids {
+		if !found[id] {
+			deleted = append(deleted, id)
+		}
+	}
+	if len(deleted) > 0 {
+		err = m.do("POST", fmt.Sprintf("/indexes/%v/documents/delete-batch", m.index(section)), deleted, nil)
+		if err != nil {
+			return fmt.Errorf("error deleting documents: %v", err)
+		}
+	}
+	return nil
+}
+
+// Reindex builds documents of every section into a new index
+// along with its settings and synonyms, which is then swapped
+// with the live one, so that search never sees it incomplete.
+// Tasks are applied by meilisearch in order, so the swap
+// happens after all documents are added
+func (m *Meilisearch) Reindex() error {
+	synonyms := synonyms.all()
+
+	for _, section := range Sections {
+		live := m.index(section)
+		building := live + "_reindex"
+
+		// Leftovers of a failed reindex are dropped,
+		// deleting a missing index fails silently
+		err := m.do("DELETE", "/indexes/"+building, nil, nil)
+		if err != nil {
+			return fmt.Errorf("error deleting %v index: %v", building, err)
+		}
+		// Creating an existing index fails silently too,
+		// but both have to exist to be swapped
+		for _, index := range []string{live, building} {
+			err = m.do("POST", "/indexes", map[string]string{"uid": index, "primaryKey": "id"}, nil)
+			if err != nil {
+				return fmt.Errorf("error creating %v index: %v", index, err)
+			}
+		}
+
+		settings := *meilisearchIndexSettings[section]
+		settings.Synonyms = synonyms
+		err = m.do("PATCH", fmt.Sprintf("/indexes/%v/settings", building), settings, nil)
+		if err != nil {
+			return fmt.Errorf("error updating %v index settings: %v", building, err)
+		}
+
+		var lastID uint
+		for {
+			docs, err := m.documents(section, "> ?", lastID, reindexBatchSize)
+			if err != nil {
+				return fmt.Errorf("error getting %v documents: %v", section, err)
+			}
+			err = m.send(building, docs)
+			if err != nil {
+				return fmt.Errorf("error sending %v documents: %v", section, err)
+			}
+			if len(docs) < reindexBatchSize {
+				break
+			}
+			lastID = docs[len(docs)-1].ID
+		}
+
+		swap := []map[string][]string{{"indexes": {live, building}}}
+		err = m.do("POST", "/swap-indexes", swap, nil)
+		if err != nil {
+			return fmt.Errorf("error swapping %v index: %v", live, err)
+		}
+		// It has the old documents now
+		err = m.do("DELETE", "/indexes/"+building, nil, nil)
+		if err != nil {
+			return fmt.Errorf("error deleting %v index: %v", building, err)
+		}
+	}
+	return nil
+}
+
+func (m *Meilisearch) index(section string) string {
+	return m.prefix + section
+}
+
+// do sends request to meilisearch and decodes
+// response into dst, if it's not nil
+func (m *Meilisearch) do(method string, path string, body interface{}, dst interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, m.address+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if m.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+m.apiKey)
+	}
+
+	res, err := m.c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return fmt.Errorf("wrong status code - %v", res.StatusCode)
+	}
+	if dst != nil {
+		return json.NewDecoder(res.Body).Decode(dst)
+	}
+	return nil
+}
@@ -0,0 +1,406 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/season"
+	"gorm.io/gorm"
+	"strings"
+	"sync"
+)
+
+// Postgres searches with full text search of the primary database.
+// Its tsv columns are kept up to date by triggers, so it needs
+// no indexing
+type Postgres struct{}
+
+type facetFunc func(q *Query) ([]*FacetValue, error)
+
+var facetFuncs = map[string]facetFunc{
+	FacetCategory:         categoryFacet,
+	FacetSeason:           seasonFacet,
+	FacetBrand:            brandFacet,
+	FacetWardrobeCategory: wardrobeCategoryFacet,
+}
+
+// Search runs queries of every section and facet in parallel
+func (p *Postgres) Search(q *Query) (*Hits, error) {
+	tsQuery, err := TsQuery(q.Text)
+	if err != nil {
+		return nil, err
+	}
+	pq := *q
+	pq.tsQuery = tsQuery
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+	)
+	hits := &Hits{
+		Sections: make(map[string]*SectionHits, len(Sections)),
+		Facets:   make(map[string][]*FacetValue, len(facetFuncs)),
+	}
+	for _, section := range Sections {
+		wg.Add(1)
+		go func(section string) {
+			defer wg.Done()
+			h, err := sectionHits(section, &pq)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			hits.Sections[section] = h
+		}(section)
+	}
+	for key, f := range facetFuncs {
+		wg.Add(1)
+		go func(key string, f facetFunc) {
+			defer wg.Done()
+			values, err := f(&pq)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				return
+			}
+			hits.Facets[key] = values
+		}(key, f)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return nil, errs[0]
+	}
+	return hits, nil
+}
+
+func (p *Postgres) Index(section string, ids []uint) error {
+	return nil
+}
+
+// Reindex refreshes words, that misspelled queries are corrected to
+func (p *Postgres) Reindex() error {
+	return database.DB().Exec("REFRESH MATERIALIZED VIEW CONCURRENTLY search_words").Error
+}
+
+func sectionHits(section string, q *Query) (*SectionHits, error) {
+	var (
+		hits *SectionHits
+		err  error
+	)
+	switch section {
+	case SectionLooks:
+		hits, err = textMatcher("looks", q.tsQuery).hits(q.Limit, looksScopes(q)...)
+	case SectionTopics:
+		hits, err = textMatcher("topics", q.tsQuery).hits(q.Limit, notDeleted("topics"))
+	case SectionWardrobe:
+		hits, err = textMatcher("wardrobe_items", q.tsQuery).hits(q.Limit, itemsScopes(q)...)
+	case SectionBrands:
+		hits, err = brandMatcher(q.Text).hits(q.Limit, notDeleted("brands"), brandFilters(q.Filters))
+	case SectionArticles:
+		hits, err = textMatcher("articles", q.tsQuery).hits(q.Limit, notDeleted("articles"))
+	default:
+		return nil, fmt.Errorf("unknown search section %v", section)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error searching %v: %v", section, err)
+	}
+	return hits, nil
+}
+
+// Full text match and rank of a table with tsv column,
+// the query is in tsquery text form built by TsQuery
+const (
+	tsMatch = "%[1]v.tsv @@ ?::tsquery"
+	tsRank  = "ts_rank(%[1]v.tsv, ?::tsquery)"
+)
+
+// Brands have no tsv and names are mostly latin, so they
+// are matched by trigram similarity or a part of the name
+const (
+	brandMatch = "lower(brands.name) % ? OR lower(brands.name) LIKE ?"
+	brandRank  = "similarity(lower(brands.name), ?) + CASE WHEN lower(brands.name) LIKE ? THEN 1 ELSE 0 END"
+)
+
+// matcher is a sql condition of a table matching
+// the query, and rank of the matching rows
+type matcher struct {
+	table string
+	match string
+	rank  string
+	args  []interface{}
+}
+
+func textMatcher(table string, tsQuery string) *matcher {
+	return &matcher{
+		table: table,
+		match: fmt.Sprintf(tsMatch, table),
+		rank:  fmt.Sprintf(tsRank, table),
+		args:  []interface{}{tsQuery},
+	}
+}
+
+// brandMatcher matches the query, its synonyms and transliterations,
+// so "зара" finds Zara. Rank is the one of the best matching variant
+func brandMatcher(text string) *matcher {
+	text = strings.ToLower(text)
+
+	var (
+		matches []string
+		ranks   []string
+		args    []interface{}
+	)
+	for _, v := range append([]string{text}, Variants(text)...) {
+		matches = append(matches, brandMatch)
+		ranks = append(ranks, brandRank)
+		args = append(args, v, "%"+EscapeLike(v)+"%")
+	}
+
+	return &matcher{
+		table: "brands",
+		match: "(" + strings.Join(matches, " OR ") + ")",
+		rank:  "greatest(" + strings.Join(ranks, ", ") + ")",
+		args:  args,
+	}
+}
+
+// rows returns matching rows, that pass all scopes
+func (m *matcher) rows(scopes ...func(*gorm.DB) *gorm.DB) *gorm.DB {
+	return database.DB().Table(m.table).Where(m.match, m.args...).Scopes(scopes...)
+}
+
+// ids is a subquery of ids of matching rows
+func (m *matcher) ids(scopes ...func(*gorm.DB) *gorm.DB) *gorm.DB {
+	return m.rows(scopes...).Select(m.table + ".id")
+}
+
+// hits counts matching rows and returns the best ones
+func (m *matcher) hits(limit int, scopes ...func(*gorm.DB) *gorm.DB) (*SectionHits, error) {
+	result := &SectionHits{}
+	err := m.rows(scopes...).Count(&result.Count).Error
+	if err != nil || result.Count == 0 {
+		return result, err
+	}
+
+	err = m.rows(scopes...).
+		Select(fmt.Sprintf("%v.id, %v AS rank", m.table, m.rank), m.args...).
+		Order("rank DESC, " + m.table + ".id").
+		Limit(limit).
+		Scan(&result.Hits).Error
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func notDeleted(table string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(table + ".deleted_at IS NULL")
+	}
+}
+
+func withSex(table string, sex string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if sex == "" {
+			return db
+		}
+		return db.Where(table+".sex = ?", sex)
+	}
+}
+
+func lookFilters(f Filters) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if len(f.Categories) > 0 {
+			db = db.Where(`looks.id IN (SELECT lc.look_id FROM look_categories lc
+				JOIN categories c ON c.id = lc.category_id WHERE c.slug IN ?)`, f.Categories)
+		}
+		if len(f.Seasons) > 0 {
+			var values []string
+			for _, s := range f.Seasons {
+				values = append(values, season.Matching(season.Normalize(s))...)
+			}
+			db = db.Where("lower(trim(coalesce(looks.season, ''))) IN ?", values)
+		}
+		if len(f.Brands) > 0 {
+			db = db.Where(`looks.id IN (SELECT li.look_id FROM look_items li
+				JOIN item_urls iu ON iu.item_id = li.wardrobe_item_id AND iu.deleted_at IS NULL
+				JOIN brands b ON b.id = iu.brand_id WHERE b.slug IN ?)`, f.Brands)
+		}
+		if len(f.WardrobeCategories) > 0 {
+			db = db.Where(`looks.id IN (SELECT li.look_id FROM look_items li
+				JOIN wardrobe_items wi ON wi.id = li.wardrobe_item_id
+				JOIN wardrobe_categories wc ON wc.id = wi.wardrobe_category_id WHERE wc.slug IN ?)`, f.WardrobeCategories)
+		}
+		return db
+	}
+}
+
+func itemFilters(f Filters) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if len(f.Brands) > 0 {
+			db = db.Where(`wardrobe_items.id IN (SELECT iu.item_id FROM item_urls iu
+				JOIN brands b ON b.id = iu.brand_id WHERE iu.deleted_at IS NULL AND b.slug IN ?)`, f.Brands)
+		}
+		if len(f.WardrobeCategories) > 0 {
+			db = db.Where(`wardrobe_items.wardrobe_category_id IN (SELECT id FROM wardrobe_categories
+				WHERE slug IN ?)`, f.WardrobeCategories)
+		}
+		return db
+	}
+}
+
+func brandFilters(f Filters) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if len(f.Brands) > 0 {
+			db = db.Where("brands.slug IN ?", f.Brands)
+		}
+		return db
+	}
+}
+
+func looksScopes(q *Query) []func(*gorm.DB) *gorm.DB {
+	return []func(*gorm.DB) *gorm.DB{notDeleted("looks"), withSex("looks", q.Sex), lookFilters(q.Filters)}
+}
+
+func itemsScopes(q *Query) []func(*gorm.DB) *gorm.DB {
+	return []func(*gorm.DB) *gorm.DB{withSex("wardrobe_items", q.Sex), itemFilters(q.Filters)}
+}
+
+// Looks matching the query or containing matching wardrobe
+// items. A look is grouped, so that it appears once no matter
+// how many of its items match, and ordered by the best of them.
+// %[1]v are the items with their ordering, %[2]v is the order
+// of looks and %[3]v is a condition of the mood
+const (
+	postgresLooksSql = `SELECT looks.id,
+        ts_rank(looks.tsv, ?::tsquery) AS rank,
+        coalesce(max(x.ordering), 0) AS ordering
+FROM looks
+    LEFT JOIN look_items li ON looks.id = li.look_id
+    LEFT JOIN (
+        VALUES %[1]v
+    ) AS x (id, ordering) ON li.wardrobe_item_id = x.id
+WHERE (looks.tsv @@ ?::tsquery OR x.id IS NOT NULL)
+	AND looks.sex = ? AND looks.deleted_at IS NULL%[3]v
+GROUP BY looks.id
+ORDER BY %[2]v
+OFFSET ? LIMIT ?`
+
+	postgresLooksCountSql = `SELECT count(DISTINCT looks.id)
+FROM looks
+    LEFT JOIN look_items li ON looks.id = li.look_id
+    LEFT JOIN (
+        VALUES %[1]v
+    ) AS x (id, ordering) ON li.wardrobe_item_id = x.id
+WHERE (looks.tsv @@ ?::tsquery OR x.id IS NOT NULL)
+	AND looks.sex = ? AND looks.deleted_at IS NULL%[2]v`
+
+	postgresTextLooksSql = `SELECT looks.id,
+        ts_rank(looks.tsv, ?::tsquery) AS rank
+FROM looks
+WHERE looks.tsv @@ ?::tsquery
+	AND looks.sex = ? AND looks.deleted_at IS NULL%v
+ORDER BY rank DESC, looks.id
+OFFSET ? LIMIT ?`
+
+	postgresTextLooksCountSql = `SELECT count(*)
+FROM looks
+WHERE looks.tsv @@ ?::tsquery
+	AND looks.sex = ? AND looks.deleted_at IS NULL%v`
+
+	// The best wardrobe items matching the query, that are in some looks
+	postgresLookItemsSql = `SELECT wardrobe_items.id, ts_rank(wardrobe_items.tsv, ?::tsquery) AS rank,
+       count(li.id) AS items_count
+    FROM wardrobe_items JOIN look_items li on wardrobe_items.id = li.wardrobe_item_id
+    WHERE wardrobe_items.tsv @@ ?::tsquery and sex = ?
+    GROUP BY wardrobe_items.id, rank
+	ORDER BY rank DESC, items_count DESC LIMIT ?`
+)
+
+var postgresLooksOrders = map[string]string{
+	OrderWardrobe: "ordering DESC, rank DESC, looks.id",
+	OrderText:     "rank DESC, ordering DESC, looks.id",
+}
+
+// Looks searches wardrobe items first, as looks made of
+// them are what user is most likely looking for
+func (p *Postgres) Looks(q *LooksQuery) (*LookHits, error) {
+	tsQuery, err := TsQuery(q.Text)
+	if err != nil {
+		return nil, err
+	}
+
+	hits := &LookHits{}
+	err = database.DB().Raw(postgresLookItemsSql, tsQuery, tsQuery, q.Sex, maxLookItems).Scan(&hits.Items).Error
+	if err != nil {
+		return nil, fmt.Errorf("error searching wardrobe items: %v", err)
+	}
+
+	var moodFilter string
+	var moodArgs []interface{}
+	if q.Mood != nil {
+		moodFilter, moodArgs = q.Mood.LookFilter()
+		moodFilter = " AND " + moodFilter
+	}
+	countArgs := append([]interface{}{tsQuery, q.Sex}, moodArgs...)
+	pageArgs := append(append([]interface{}{tsQuery, tsQuery, q.Sex}, moodArgs...), q.Offset, q.Limit)
+
+	var countSql, pageSql string
+	if len(hits.Items) > 0 {
+		values := itemsWithOrdering(hits.Items)
+		order, ok := postgresLooksOrders[q.Order]
+		if !ok {
+			order = postgresLooksOrders[OrderWardrobe]
+		}
+		countSql = fmt.Sprintf(postgresLooksCountSql, values, moodFilter)
+		pageSql = fmt.Sprintf(postgresLooksSql, values, order, moodFilter)
+	} else {
+		countSql = fmt.Sprintf(postgresTextLooksCountSql, moodFilter)
+		pageSql = fmt.Sprintf(postgresTextLooksSql, moodFilter)
+	}
+
+	err = database.DB().Raw(countSql, countArgs...).Scan(&hits.Total).Error
+	if err == nil && hits.Total > int64(q.Offset) {
+		err = database.DB().Raw(pageSql, pageArgs...).Scan(&hits.Looks).Error
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error searching looks: %v", err)
+	}
+	return hits, nil
+}
+
+// Suggest corrects the query to words of searchable names
+func (p *Postgres) Suggest(text string) (string, error) {
+	return Suggest(text)
+}
+
+// itemsWithOrdering returns sql values of items and their
+// ordering, the best item gets the highest one
+func itemsWithOrdering(items []Hit) string {
+	values := make([]string, len(items))
+	for i, item := range items {
+		values[i] = fmt.Sprintf("(%v, %v)", item.ID, len(items)-i)
+	}
+	return strings.Join(values, ", ")
+}
@@ -0,0 +1,163 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"testing"
+)
+
+// postgresLooks creates five looks of jeans, two looks made of
+// jeans, one of them of two pairs, and looks, that don't match
+func postgresLooks(t *testing.T) map[uint]bool {
+	if testDatabase == "" {
+		t.Skipf("no postgres to test with: %v", noDatabase)
+	}
+	if database.DB() == nil {
+		err := database.New(database.Config{Address: testDatabase})
+		if err != nil {
+			t.Fatalf("error connecting to database: %v", err)
+		}
+	}
+
+	// The database is started for tests, so tables
+	// are emptied along with ones referencing them
+	truncate := func() {
+		err := database.DB().Exec("TRUNCATE look_items, looks, wardrobe_items, wardrobe_categories RESTART IDENTITY CASCADE").Error
+		if err != nil {
+			t.Fatalf("error truncating tables: %v", err)
+		}
+	}
+	truncate()
+	t.Cleanup(truncate)
+
+	category := &models.WardrobeCategory{Name: "Низ"}
+	create := func(value interface{}) {
+		if err := database.DB().Create(value).Error; err != nil {
+			t.Fatalf("error creating %T: %v", value, err)
+		}
+	}
+	create(category)
+
+	blue := &models.WardrobeItem{Name: "Синие джинсы", Sex: "male", WardrobeCategoryID: category.ID}
+	black := &models.WardrobeItem{Name: "Черные джинсы", Sex: "male", WardrobeCategoryID: category.ID}
+	shirt := &models.WardrobeItem{Name: "Белая рубашка", Sex: "male", WardrobeCategoryID: category.ID}
+	create(blue)
+	create(black)
+	create(shirt)
+
+	office := &models.Look{Name: "Офис", Sex: "male", Items: []*models.WardrobeItem{blue, black}}
+	evening := &models.Look{Name: "Вечер", Sex: "male", Items: []*models.WardrobeItem{blue, shirt}}
+	create(office)
+	create(evening)
+	for i := 1; i <= 5; i++ {
+		create(&models.Look{Name: fmt.Sprintf("Джинсы и кеды %v", i), Sex: "male"})
+	}
+	create(&models.Look{Name: "Рубашка", Sex: "male", Items: []*models.WardrobeItem{shirt}})
+	create(&models.Look{Name: "Джинсы", Sex: "female"})
+
+	deleted := &models.Look{Name: "Старые джинсы", Sex: "male"}
+	create(deleted)
+	if err := database.DB().Delete(deleted).Error; err != nil {
+		t.Fatalf("error deleting look: %v", err)
+	}
+
+	return map[uint]bool{office.ID: true, evening.ID: true}
+}
+
+func TestPostgresLooksPages(t *testing.T) {
+	withItems := postgresLooks(t)
+	p := &Postgres{}
+
+	var (
+		cursor = &Cursor{}
+		seen   = make(map[uint]bool)
+		pages  int
+	)
+	for {
+		hits, err := p.Looks(&LooksQuery{Text: "джинсы", Sex: "male", Offset: cursor.Offset, Limit: 3})
+		if err != nil {
+			t.Fatalf("error searching looks: %v", err)
+		}
+		if hits.Total != 7 {
+			t.Fatalf("got total %v, want 7", hits.Total)
+		}
+		if pages == 0 {
+			if len(hits.Items) != 2 {
+				t.Errorf("got %v wardrobe items, want both pairs of jeans", len(hits.Items))
+			}
+			// Looks with matching items go first
+			for _, h := range hits.Looks[:2] {
+				if !withItems[h.ID] {
+					t.Errorf("got look %v among the first ones", h.ID)
+				}
+			}
+		}
+		pages++
+
+		// A look made of two matching items is found once
+		for _, h := range hits.Looks {
+			if seen[h.ID] {
+				t.Errorf("got look %v twice", h.ID)
+			}
+			seen[h.ID] = true
+		}
+
+		next := cursor.Next(len(hits.Looks), hits.Total)
+		if next == "" {
+			break
+		}
+		cursor, err = DecodeCursor(next)
+		if err != nil {
+			t.Fatalf("error decoding cursor: %v", err)
+		}
+	}
+
+	if pages != 3 || len(seen) != 7 {
+		t.Errorf("got %v looks on %v pages, want 7 on 3", len(seen), pages)
+	}
+}
+
+func TestPostgresLooksTextOrder(t *testing.T) {
+	withItems := postgresLooks(t)
+	p := &Postgres{}
+
+	hits, err := p.Looks(&LooksQuery{Text: "джинсы", Sex: "male", Order: OrderText, Limit: 10})
+	if err != nil {
+		t.Fatalf("error searching looks: %v", err)
+	}
+	if hits.Total != 7 || len(hits.Looks) != 7 {
+		t.Fatalf("got %v looks of %v, want 7", len(hits.Looks), hits.Total)
+	}
+	// Looks only matching by items have no rank
+	for _, h := range hits.Looks[5:] {
+		if !withItems[h.ID] {
+			t.Errorf("got look %v among the last ones", h.ID)
+		}
+	}
+
+	// Offset past the last look
+	hits, err = p.Looks(&LooksQuery{Text: "джинсы", Sex: "male", Offset: 10, Limit: 10})
+	if err != nil {
+		t.Fatalf("error searching looks: %v", err)
+	}
+	if hits.Total != 7 || len(hits.Looks) != 0 {
+		t.Errorf("got %v looks of %v past the last page", len(hits.Looks), hits.Total)
+	}
+}
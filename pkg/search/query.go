@@ -0,0 +1,196 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database"
+	"strings"
+	"unicode"
+)
+
+// Every word of the query is replaced with the most
+// similar known word, words are kept in their order
+const suggestSql = `SELECT coalesce((
+	    SELECT sw.word FROM search_words sw WHERE sw.word % w.word
+	    ORDER BY similarity(sw.word, w.word) DESC, sw.ndoc DESC LIMIT 1
+	), w.word)
+	FROM unnest(string_to_array(?, ' ')) WITH ORDINALITY AS w(word, n)
+	ORDER BY w.n`
+
+// minSuggestWordLen is the length of the shortest word,
+// that is corrected, shorter ones are rarely misspelled
+const minSuggestWordLen = 3
+
+// TsQuery turns user's input into a full text query. Input may
+// use web search syntax: "quoted phrases", -excluded words and or.
+// The last word is matched as a prefix, as user might be still
+// typing it. Plain words are expanded with their synonyms and
+// transliterations. The query is returned in tsquery text form
+func TsQuery(text string) (string, error) {
+	expr, args := tsQueryExpr(text)
+
+	var query string
+	err := database.DB().Raw("SELECT ("+expr+")::text", args...).Scan(&query).Error
+	if err != nil {
+		return "", fmt.Errorf("error parsing search query: %v", err)
+	}
+	return query, nil
+}
+
+func tsQueryExpr(text string) (string, []interface{}) {
+	if words := plainWords(text); words != nil {
+		last := text[len(text)-1]
+		return expandedExpr(words, last != ' ' && last != '\t')
+	}
+
+	rest, last := splitLastWord(text)
+	switch {
+	case last == "":
+		return "websearch_to_tsquery('russian', ?)", []interface{}{text}
+	case strings.TrimSpace(rest) == "":
+		return "to_tsquery('russian', ?)", []interface{}{last + ":*"}
+	default:
+		return "websearch_to_tsquery('russian', ?) && to_tsquery('russian', ?)", []interface{}{rest, last + ":*"}
+	}
+}
+
+// expandedExpr matches every word or any of its variants. If the
+// whole query has synonyms, matching any of them is enough too
+func expandedExpr(words []string, prefix bool) (string, []interface{}) {
+	var (
+		parts []string
+		args  []interface{}
+	)
+	for i, word := range words {
+		asPrefix := prefix && i == len(words)-1
+
+		var group []string
+		for _, v := range append([]string{word}, Variants(word)...) {
+			if asPrefix && isPlainWord(v) {
+				group = append(group, "to_tsquery('russian', ?)")
+				args = append(args, v+":*")
+			} else {
+				group = append(group, "plainto_tsquery('russian', ?)")
+				args = append(args, v)
+			}
+		}
+		parts = append(parts, "("+strings.Join(group, " || ")+")")
+	}
+	expr := strings.Join(parts, " && ")
+
+	if len(words) > 1 {
+		for _, v := range synonyms.get(strings.Join(words, " ")) {
+			expr = "(" + expr + ") || plainto_tsquery('russian', ?)"
+			args = append(args, v)
+		}
+	}
+	return expr, args
+}
+
+// plainWords returns lowercase words of the query,
+// or nil if it uses any web search syntax
+func plainWords(text string) []string {
+	words := strings.Fields(strings.ToLower(text))
+	if len(words) == 0 {
+		return nil
+	}
+	for _, word := range words {
+		if word == "or" || !isPlainWord(word) {
+			return nil
+		}
+	}
+	return words
+}
+
+func isPlainWord(word string) bool {
+	for _, r := range word {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return word != ""
+}
+
+// splitLastWord splits off the last word, if it can be matched
+// as a prefix: it's a plain word outside of quotes, that is not
+// followed by a space
+func splitLastWord(text string) (string, string) {
+	if strings.Count(text, `"`)%2 != 0 {
+		return text, ""
+	}
+
+	i := strings.LastIndexFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	last := text[i+1:]
+	if last == "" || strings.ToLower(last) == "or" {
+		return text, ""
+	}
+	if i >= 0 && !unicode.IsSpace(rune(text[i])) {
+		// Last word is quoted, excluded or glued to something
+		return text, ""
+	}
+	return text[:i+1], last
+}
+
+// Suggest corrects misspelled words of the query using words of
+// looks, topics, wardrobe items and brands names. It returns an
+// empty string if there is nothing to correct
+func Suggest(text string) (string, error) {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var candidates []string
+	for _, word := range words {
+		if len([]rune(word)) >= minSuggestWordLen {
+			candidates = append(candidates, word)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", nil
+	}
+
+	var corrected []string
+	err := database.DB().Raw(suggestSql, strings.Join(candidates, " ")).Scan(&corrected).Error
+	if err != nil {
+		return "", fmt.Errorf("error correcting query: %v", err)
+	}
+	if len(corrected) != len(candidates) {
+		return "", nil
+	}
+
+	changed := false
+	replacements := make(map[string]string, len(candidates))
+	for i, word := range candidates {
+		if corrected[i] != word {
+			replacements[word] = corrected[i]
+			changed = true
+		}
+	}
+	if !changed {
+		return "", nil
+	}
+
+	for i, word := range words {
+		if r, ok := replacements[word]; ok {
+			words[i] = r
+		}
+	}
+	return strings.Join(words, " "), nil
+}
@@ -0,0 +1,382 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var instance *Search
+
+// Sections of results
+const (
+	SectionLooks    = "looks"
+	SectionTopics   = "topics"
+	SectionWardrobe = "wardrobe_items"
+	SectionBrands   = "brands"
+	SectionArticles = "articles"
+)
+
+// Facets of results
+const (
+	FacetCategory         = "category"
+	FacetSeason           = "season"
+	FacetBrand            = "brand"
+	FacetWardrobeCategory = "wardrobe_category"
+)
+
+const (
+	DefaultLimit = 10
+	MaxLimit     = 50
+	// MaxLooksLimit is the most looks taken at once,
+	// enough for candidates of hybrid search
+	MaxLooksLimit = 200
+	// maxFacetValues is how many most frequent
+	// values of every facet are returned
+	maxFacetValues = 20
+)
+
+type Config struct {
+	// Backend is either postgres or meilisearch
+	Backend string
+	// Address, APIKey and IndexPrefix configure
+	// external backend, they are not used by postgres
+	Address     string
+	APIKey      string
+	IndexPrefix string
+
+	// Limit is the default number of results in a section
+	Limit int
+	// RefreshInterval is how often words, that
+	// misspelled queries are corrected to, are refreshed
+	RefreshInterval time.Duration
+	// TrendsInterval is how often trending queries are refreshed
+	TrendsInterval time.Duration
+	// IndexInterval is how often changed rows are
+	// sent to external backend
+	IndexInterval time.Duration
+}
+
+// Search queries looks, topics, wardrobe items, brands
+// and articles at once and counts facets of the results
+type Search struct {
+	cfg     Config
+	backend Backend
+
+	// Rows changed since they were last indexed,
+	// only used with external backends
+	changes chan change
+	// completer completes wardrobe item names in autofill
+	completer *completer
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func New(cfg Config) (*Search, error) {
+	cfg = cfg.withDefaults()
+	backend, err := newBackend(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s, err := NewWithBackend(cfg, backend)
+	if err != nil {
+		return nil, err
+	}
+	err = s.registerCallbacks(database.DB())
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewWithBackend creates search over the given backend. Rows
+// changed in the database are not sent to it, so it's meant
+// for backends keeping rows themselves
+func NewWithBackend(cfg Config, backend Backend) (*Search, error) {
+	s := &Search{
+		cfg:     cfg.withDefaults(),
+		backend: backend,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+
+		completer: &completer{},
+	}
+	if _, ok := backend.(*Postgres); !ok {
+		s.changes = make(chan change, maxQueuedChanges)
+	}
+
+	instance = s
+	return s, nil
+}
+
+func (cfg Config) withDefaults() Config {
+	if cfg.Limit <= 0 || cfg.Limit > MaxLimit {
+		cfg.Limit = DefaultLimit
+	}
+	if cfg.RefreshInterval <= 0 {
+		cfg.RefreshInterval = time.Hour
+	}
+	if cfg.TrendsInterval <= 0 {
+		cfg.TrendsInterval = 10 * time.Minute
+	}
+	if cfg.IndexInterval <= 0 {
+		cfg.IndexInterval = 5 * time.Second
+	}
+	if cfg.IndexPrefix == "" {
+		cfg.IndexPrefix = "papaya_"
+	}
+	return cfg
+}
+
+func Get() *Search {
+	if instance == nil {
+		_, err := New(Config{})
+		if err != nil {
+			logrus.Fatalf("error creating search: %v", err)
+		}
+	}
+	return instance
+}
+
+// Reindex rebuilds all documents of the backend
+func (s *Search) Reindex() error {
+	return s.backend.Reindex()
+}
+
+// Filters are slugs of facet values results must have.
+// Looks are filtered by every facet, wardrobe items by brand
+// and wardrobe category, brands by brand. Topics and
+// articles have no facets and are never filtered
+type Filters struct {
+	Categories         []string
+	Seasons            []string
+	Brands             []string
+	WardrobeCategories []string
+}
+
+type Query struct {
+	Text    string
+	Sex     string
+	Filters Filters
+	// Limit is the number of results in every section
+	Limit int
+
+	tsQuery string
+}
+
+type Section struct {
+	Type  string      `json:"type"`
+	Count int64       `json:"count"`
+	Items interface{} `json:"items"`
+}
+
+type FacetValue struct {
+	Key   string `json:"key"`
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type Results struct {
+	// Sections are in order of Sections, the ones
+	// without results go last
+	Sections []*Section               `json:"sections"`
+	Facets   map[string][]*FacetValue `json:"facets"`
+	// DidYouMean is set when nothing was found and
+	// results are shown for the corrected query
+	DidYouMean string `json:"did_you_mean,omitempty"`
+	// SearchID is sent back with the opened result
+	SearchID uint `json:"search_id,omitempty"`
+}
+
+// Do searches all sections and counts facets. If nothing
+// is found, the query is corrected and searched again
+func (s *Search) Do(q *Query) (*Results, error) {
+	q.Text = strings.TrimSpace(q.Text)
+	if q.Limit <= 0 || q.Limit > MaxLimit {
+		q.Limit = s.cfg.Limit
+	}
+
+	results, err := s.do(q)
+	if err != nil || !results.empty() {
+		return results, err
+	}
+
+	suggestion, err := s.backend.Suggest(q.Text)
+	if err != nil {
+		logrus.Errorf("error correcting search query: %v", err)
+		return results, nil
+	}
+	if suggestion == "" {
+		return results, nil
+	}
+
+	corrected := *q
+	corrected.Text = suggestion
+	results, err = s.do(&corrected)
+	if err != nil {
+		return nil, err
+	}
+	results.DidYouMean = suggestion
+	return results, nil
+}
+
+func (s *Search) do(q *Query) (*Results, error) {
+	hits, err := s.backend.Search(q)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		wg   sync.WaitGroup
+		errs = make([]error, len(Sections))
+	)
+	sections := make([]*Section, len(Sections))
+	for i, section := range Sections {
+		h := hits.Sections[section]
+		if h == nil {
+			h = &SectionHits{}
+		}
+
+		wg.Add(1)
+		go func(i int, section string, h *SectionHits) {
+			defer wg.Done()
+			items, err := s.load(section, h.Hits)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			sections[i] = newSection(section, h, items)
+		}(i, section, h)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Ranks of different sections are on different scales,
+	// so sections keep their order and only the ones
+	// without results go last
+	sort.SliceStable(sections, func(i, j int) bool {
+		return sections[i].Count > 0 && sections[j].Count == 0
+	})
+
+	results := &Results{
+		Sections: sections,
+		Facets:   make(map[string][]*FacetValue, len(facetFuncs)),
+	}
+	for key := range facetFuncs {
+		results.Facets[key] = []*FacetValue{}
+	}
+	for key, values := range hits.Facets {
+		results.Facets[key] = values
+	}
+
+	return results, nil
+}
+
+func (r *Results) empty() bool {
+	for _, section := range r.Sections {
+		if section.Count > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Run refreshes words, that misspelled queries are corrected to,
+// trending queries and autofill, and sends changed rows to
+// external backend until Stop is called
+func (s *Search) Run() {
+	defer close(s.done)
+
+	err := s.completer.build()
+	if err != nil {
+		logrus.Errorf("error building autofill: %v", err)
+		s.completer.markDirty()
+	}
+
+	refresh := time.NewTicker(s.cfg.RefreshInterval)
+	defer refresh.Stop()
+	trends := time.NewTicker(s.cfg.TrendsInterval)
+	defer trends.Stop()
+	index := time.NewTicker(s.cfg.IndexInterval)
+	defer index.Stop()
+	complete := time.NewTicker(completerInterval)
+	defer complete.Stop()
+
+	pending := make(changeSet)
+	for {
+		select {
+		case <-refresh.C:
+			err := s.Refresh()
+			if err != nil {
+				logrus.Errorf("error refreshing search words: %v", err)
+			}
+			// Items changed with raw sql are not seen by
+			// callbacks, so autofill is rebuilt every now and then
+			s.completer.markDirty()
+		case <-trends.C:
+			err := s.RefreshTrends()
+			if err != nil {
+				logrus.Errorf("error refreshing trending queries: %v", err)
+			}
+		case <-complete.C:
+			err := s.completer.rebuild()
+			if err != nil {
+				logrus.Errorf("error rebuilding autofill: %v", err)
+			}
+		case c := <-s.changes:
+			pending.add(c)
+		case <-index.C:
+			s.index(pending)
+			pending = make(changeSet)
+		case <-s.stop:
+			// Indexing what's left before shutting down
+			for {
+				select {
+				case c := <-s.changes:
+					pending.add(c)
+				default:
+					s.index(pending)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (s *Search) Stop() {
+	close(s.stop)
+	<-s.done
+}
+
+func (s *Search) Refresh() error {
+	return database.DB().Exec("REFRESH MATERIALIZED VIEW CONCURRENTLY search_words").Error
+}
+
+func (s *Search) RefreshTrends() error {
+	return database.DB().Exec("REFRESH MATERIALIZED VIEW CONCURRENTLY search_trends").Error
+}
@@ -0,0 +1,52 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"gorm.io/gorm"
+	"testing"
+)
+
+func TestDoSectionsOrder(t *testing.T) {
+	fake := NewFake()
+	fake.AddLook(&models.Look{Model: gorm.Model{ID: 1}, Name: "Куртка и джинсы с кедами в парке", Sex: "male"})
+	fake.AddItem(&models.WardrobeItem{ID: 1, Name: "Куртка", Sex: "male"})
+	fake.AddItem(&models.WardrobeItem{ID: 2, Name: "Шапка", Sex: "male"})
+	s, err := NewWithBackend(Config{}, fake)
+	if err != nil {
+		t.Fatalf("error creating search: %v", err)
+	}
+
+	// Wardrobe item ranks higher, but looks go first anyway
+	results, err := s.Do(&Query{Text: "куртка", Sex: "male"})
+	if err != nil {
+		t.Fatalf("error searching: %v", err)
+	}
+	if results.Sections[0].Type != SectionLooks || results.Sections[1].Type != SectionWardrobe {
+		t.Errorf("got %v and %v sections first", results.Sections[0].Type, results.Sections[1].Type)
+	}
+
+	// Sections without results go last
+	results, err = s.Do(&Query{Text: "шапка", Sex: "male"})
+	if err != nil {
+		t.Fatalf("error searching: %v", err)
+	}
+	if results.Sections[0].Type != SectionWardrobe || results.Sections[1].Type != SectionLooks {
+		t.Errorf("got %v and %v sections first", results.Sections[0].Type, results.Sections[1].Type)
+	}
+}
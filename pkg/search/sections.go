@@ -0,0 +1,213 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"strings"
+)
+
+// loadFunc loads rows of hits from the database in order of hits
+type loadFunc func(hits []Hit) (interface{}, error)
+
+var loadFuncs = map[string]loadFunc{
+	SectionLooks:    loadLooks,
+	SectionTopics:   loadTopics,
+	SectionWardrobe: loadWardrobe,
+	SectionBrands:   loadBrands,
+	SectionArticles: loadArticles,
+}
+
+func newSection(typ string, hits *SectionHits, items interface{}) *Section {
+	return &Section{
+		Type:  typ,
+		Count: hits.Count,
+		Items: items,
+	}
+}
+
+func hitIDs(hits []Hit) []uint {
+	ids := make([]uint, 0, len(hits))
+	for _, h := range hits {
+		ids = append(ids, h.ID)
+	}
+	return ids
+}
+
+// position maps ids to their place in hits, so
+// loaded rows can be put back in order of rank
+func position(hits []Hit) map[uint]int {
+	pos := make(map[uint]int, len(hits))
+	for i, h := range hits {
+		pos[h.ID] = i
+	}
+	return pos
+}
+
+func loadLooks(hits []Hit) (interface{}, error) {
+	looks := make([]*models.Look, len(hits))
+	if len(hits) > 0 {
+		var found []*models.Look
+		err := database.DB().Where("id IN ?", hitIDs(hits)).Find(&found).Error
+		if err != nil {
+			return nil, fmt.Errorf("error getting found looks: %v", err)
+		}
+		pos := position(hits)
+		for _, look := range found {
+			look.Rank = hits[pos[look.ID]].Rank
+			looks[pos[look.ID]] = look
+		}
+		looks = compactLooks(looks)
+	}
+
+	return looks, nil
+}
+
+func loadTopics(hits []Hit) (interface{}, error) {
+	topics := make([]*models.Topic, len(hits))
+	if len(hits) > 0 {
+		var found []*models.Topic
+		err := database.DB().Where("id IN ?", hitIDs(hits)).Find(&found).Error
+		if err != nil {
+			return nil, fmt.Errorf("error getting found topics: %v", err)
+		}
+		pos := position(hits)
+		for _, topic := range found {
+			topic.Rank = hits[pos[topic.ID]].Rank
+			topics[pos[topic.ID]] = topic
+		}
+		topics = compactTopics(topics)
+	}
+
+	return topics, nil
+}
+
+func loadWardrobe(hits []Hit) (interface{}, error) {
+	items := make([]*models.WardrobeItem, len(hits))
+	if len(hits) > 0 {
+		var found []*models.WardrobeItem
+		err := database.DB().Where("id IN ?", hitIDs(hits)).
+			Preload("WardrobeCategory").Preload("Urls.Brand").
+			Find(&found).Error
+		if err != nil {
+			return nil, fmt.Errorf("error getting found wardrobe items: %v", err)
+		}
+		pos := position(hits)
+		for _, item := range found {
+			items[pos[item.ID]] = item
+		}
+		items = compactItems(items)
+	}
+
+	return items, nil
+}
+
+func loadBrands(hits []Hit) (interface{}, error) {
+	brands := make([]*models.Brand, len(hits))
+	if len(hits) > 0 {
+		var found []*models.Brand
+		err := database.DB().Where("id IN ?", hitIDs(hits)).Find(&found).Error
+		if err != nil {
+			return nil, fmt.Errorf("error getting found brands: %v", err)
+		}
+		pos := position(hits)
+		for _, brand := range found {
+			brands[pos[brand.ID]] = brand
+		}
+		brands = compactBrands(brands)
+	}
+
+	return brands, nil
+}
+
+func loadArticles(hits []Hit) (interface{}, error) {
+	articles := make([]*models.Article, len(hits))
+	if len(hits) > 0 {
+		var found []*models.Article
+		err := database.DB().Where("id IN ?", hitIDs(hits)).Find(&found).Error
+		if err != nil {
+			return nil, fmt.Errorf("error getting found articles: %v", err)
+		}
+		pos := position(hits)
+		for _, article := range found {
+			articles[pos[article.ID]] = article
+		}
+		articles = compactArticles(articles)
+	}
+
+	return articles, nil
+}
+
+// Rows might be deleted between searching and loading them,
+// compact* drop the places they would take
+
+func compactLooks(looks []*models.Look) []*models.Look {
+	result := looks[:0]
+	for _, look := range looks {
+		if look != nil {
+			result = append(result, look)
+		}
+	}
+	return result
+}
+
+func compactTopics(topics []*models.Topic) []*models.Topic {
+	result := topics[:0]
+	for _, topic := range topics {
+		if topic != nil {
+			result = append(result, topic)
+		}
+	}
+	return result
+}
+
+func compactItems(items []*models.WardrobeItem) []*models.WardrobeItem {
+	result := items[:0]
+	for _, item := range items {
+		if item != nil {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+func compactBrands(brands []*models.Brand) []*models.Brand {
+	result := brands[:0]
+	for _, brand := range brands {
+		if brand != nil {
+			result = append(result, brand)
+		}
+	}
+	return result
+}
+
+func compactArticles(articles []*models.Article) []*models.Article {
+	result := articles[:0]
+	for _, article := range articles {
+		if article != nil {
+			result = append(result, article)
+		}
+	}
+	return result
+}
+
+// EscapeLike escapes wildcards of LIKE patterns
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
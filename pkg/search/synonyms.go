@@ -0,0 +1,168 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"sync"
+	"time"
+)
+
+// synonymsTTL is how long synonyms are cached, edits
+// on other instances are picked up after it passes
+const synonymsTTL = time.Minute
+
+// maxVariants limits how many alternatives a single
+// term is expanded to, so queries stay cheap
+const maxVariants = 5
+
+const synonymsSql = `SELECT s.search_synonym_group_id AS group_id, lower(s.term) AS term
+	FROM search_synonyms s JOIN search_synonym_groups g ON g.id = s.search_synonym_group_id
+	WHERE g.deleted_at IS NULL`
+
+var synonyms = &dictionary{load: loadSynonyms}
+
+// synonymRow is a term of a synonym group
+type synonymRow struct {
+	GroupID uint
+	Term    string
+}
+
+// dictionary maps every term to other terms of its groups
+type dictionary struct {
+	mu       sync.RWMutex
+	terms    map[string][]string
+	loadedAt time.Time
+	// load returns terms of all groups
+	load func() ([]synonymRow, error)
+}
+
+func loadSynonyms() ([]synonymRow, error) {
+	var rows []synonymRow
+	err := database.DB().Raw(synonymsSql).Scan(&rows).Error
+	return rows, err
+}
+
+func (d *dictionary) get(term string) []string {
+	d.reload()
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.terms[term]
+}
+
+// all returns every term with its synonyms
+func (d *dictionary) all() map[string][]string {
+	d.reload()
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	terms := make(map[string][]string, len(d.terms))
+	for term, synonyms := range d.terms {
+		terms[term] = synonyms
+	}
+	return terms
+}
+
+func (d *dictionary) reload() {
+	d.mu.RLock()
+	fresh := time.Since(d.loadedAt) < synonymsTTL
+	d.mu.RUnlock()
+	if fresh {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if time.Since(d.loadedAt) < synonymsTTL {
+		return
+	}
+	// Even if loading fails we don't want to retry on every query
+	d.loadedAt = time.Now()
+
+	rows, err := d.load()
+	if err != nil {
+		logrus.Errorf("error loading search synonyms: %v", err)
+		return
+	}
+
+	groups := make(map[uint][]string)
+	for _, row := range rows {
+		term := strings.TrimSpace(row.Term)
+		if term != "" {
+			groups[row.GroupID] = append(groups[row.GroupID], term)
+		}
+	}
+
+	terms := make(map[string][]string)
+	for _, group := range groups {
+		for _, term := range group {
+			for _, other := range group {
+				if other != term {
+					terms[term] = appendUnique(terms[term], other)
+				}
+			}
+		}
+	}
+	d.terms = terms
+}
+
+// ReloadSynonyms makes the next query load synonyms
+// from the database, it's called after they are edited
+func ReloadSynonyms() {
+	synonyms.mu.Lock()
+	synonyms.loadedAt = time.Time{}
+	synonyms.mu.Unlock()
+}
+
+// Variants returns synonyms and transliterations of the term,
+// along with synonyms of its transliteration
+func Variants(term string) []string {
+	term = strings.ToLower(strings.TrimSpace(term))
+	if term == "" {
+		return nil
+	}
+
+	var variants []string
+	add := func(v string) {
+		if v != term && len(variants) < maxVariants {
+			variants = appendUnique(variants, v)
+		}
+	}
+
+	for _, s := range synonyms.get(term) {
+		add(s)
+	}
+	if t := Transliterate(term); t != term {
+		add(t)
+		for _, s := range synonyms.get(t) {
+			add(s)
+		}
+	}
+	return variants
+}
+
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
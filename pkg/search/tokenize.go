@@ -0,0 +1,95 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// prepositions are joined with the word following them, so
+// "куртка с капюшоном" is completed with "с капюшоном" at once
+var prepositions = map[string]bool{
+	"в": true, "во": true, "с": true, "со": true, "без": true,
+	"из": true, "на": true, "для": true, "под": true,
+}
+
+type Token struct {
+	Text string
+	// Start and End are offsets of the token in runes
+	Start int
+	End   int
+}
+
+// Tokenize splits text into lowercase words, anything but letters
+// and digits separates them. A preposition makes a single token
+// with the next word, unless it is the last one
+func Tokenize(text string) []Token {
+	var words []Token
+	var word []rune
+	start := 0
+	flush := func(end int) {
+		if len(word) > 0 {
+			words = append(words, Token{Text: string(word), Start: start, End: end})
+			word = word[:0]
+		}
+	}
+
+	i := 0
+	for _, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if len(word) == 0 {
+				start = i
+			}
+			word = append(word, normalizeRune(r))
+		} else {
+			flush(i)
+		}
+		i++
+	}
+	flush(i)
+
+	tokens := make([]Token, 0, len(words))
+	for i := 0; i < len(words); i++ {
+		t := words[i]
+		if prepositions[t.Text] && i+1 < len(words) {
+			next := words[i+1]
+			t = Token{Text: t.Text + " " + next.Text, Start: t.Start, End: next.End}
+			i++
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens
+}
+
+// normalizeRune lowercases the rune keeping it a single
+// one, so offsets of tokens match the original text
+func normalizeRune(r rune) rune {
+	r = unicode.ToLower(r)
+	if r == 'ё' {
+		return 'е'
+	}
+	return r
+}
+
+func joinTokens(tokens []Token) string {
+	texts := make([]string, len(tokens))
+	for i, t := range tokens {
+		texts[i] = t.Text
+	}
+	return strings.Join(texts, " ")
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"strings"
+	"testing"
+	"unicode"
+)
+
+func TestTokenize(t *testing.T) {
+	tokens := Tokenize("Куртка с капюшоном, ЁЛКА")
+	want := []Token{
+		{Text: "куртка", Start: 0, End: 6},
+		{Text: "с капюшоном", Start: 7, End: 18},
+		{Text: "елка", Start: 20, End: 24},
+	}
+	if len(tokens) != len(want) {
+		t.Fatalf("got %v tokens, want %v", tokens, want)
+	}
+	for i := range want {
+		if tokens[i] != want[i] {
+			t.Errorf("got token %+v, want %+v", tokens[i], want[i])
+		}
+	}
+}
+
+func FuzzTokenize(f *testing.F) {
+	for _, seed := range []string{"", " ", "Куртка с капюшоном", "ЁЛКА", "в", "с с с", "a\xffb", "İstanbul", "джинсы-клеш 501"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		runes := []rune(text)
+		end := 0
+		for _, token := range Tokenize(text) {
+			if token.Start < end || token.End <= token.Start || token.End > len(runes) {
+				t.Fatalf("token %+v out of %v runes after %v", token, len(runes), end)
+			}
+			end = token.End
+
+			// Token is the words of its runes, lowercased
+			words := strings.FieldsFunc(string(runes[token.Start:token.End]), func(r rune) bool {
+				return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+			})
+			for i, w := range words {
+				words[i] = strings.Map(normalizeRune, w)
+			}
+			if want := strings.Join(words, " "); token.Text != want {
+				t.Fatalf("got token %q, want %q", token.Text, want)
+			}
+		}
+	})
+}
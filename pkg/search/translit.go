@@ -0,0 +1,111 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Russian letters in latin, close to how brand
+// names and fashion words are usually written
+var ruToEn = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "e",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "sch", 'ъ': "",
+	'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+}
+
+// Latin letter combinations in russian, longer
+// ones go first, as they are matched greedily
+var enToRu = []struct {
+	en string
+	ru string
+}{
+	{"shch", "щ"}, {"sch", "щ"}, {"zh", "ж"}, {"kh", "х"}, {"ts", "ц"}, {"ch", "ч"},
+	{"sh", "ш"}, {"yu", "ю"}, {"ya", "я"}, {"yo", "ё"}, {"ee", "и"}, {"oo", "у"},
+	{"a", "а"}, {"b", "б"}, {"c", "к"}, {"d", "д"}, {"e", "е"}, {"f", "ф"},
+	{"g", "г"}, {"h", "х"}, {"i", "и"}, {"j", "дж"}, {"k", "к"}, {"l", "л"},
+	{"m", "м"}, {"n", "н"}, {"o", "о"}, {"p", "п"}, {"q", "к"}, {"r", "р"},
+	{"s", "с"}, {"t", "т"}, {"u", "у"}, {"v", "в"}, {"w", "в"}, {"x", "кс"},
+	{"y", "и"}, {"z", "з"},
+}
+
+// Transliterate writes a russian word in latin and a latin
+// word in russian. Words mixing both, or having other
+// letters, are returned as they are
+func Transliterate(word string) string {
+	word = strings.ToLower(word)
+
+	switch script(word) {
+	case unicode.Cyrillic:
+		var b strings.Builder
+		for _, r := range word {
+			if en, ok := ruToEn[r]; ok {
+				b.WriteString(en)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+		return b.String()
+	case unicode.Latin:
+		var b strings.Builder
+		for rest := word; rest != ""; {
+			matched := false
+			for _, t := range enToRu {
+				if strings.HasPrefix(rest, t.en) {
+					b.WriteString(t.ru)
+					rest = rest[len(t.en):]
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				// Digits are kept
+				b.WriteByte(rest[0])
+				rest = rest[1:]
+			}
+		}
+		return b.String()
+	}
+	return word
+}
+
+// script returns the only alphabet letters of the
+// word are from, or nil for mixed and other words
+func script(word string) *unicode.RangeTable {
+	var found *unicode.RangeTable
+	for _, r := range word {
+		var s *unicode.RangeTable
+		switch {
+		case unicode.IsDigit(r):
+			continue
+		case unicode.Is(unicode.Cyrillic, r):
+			s = unicode.Cyrillic
+		case unicode.Is(unicode.Latin, r) && r < unicode.MaxASCII:
+			s = unicode.Latin
+		default:
+			return nil
+		}
+		if found != nil && found != s {
+			return nil
+		}
+		found = s
+	}
+	return found
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package search
+
+import (
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database"
+	"strings"
+	"time"
+)
+
+const (
+	// MinTrendingUsers is how many different users should search
+	// for a query, before it is suggested to anyone else
+	MinTrendingUsers = 3
+
+	// historyHalfLife is how fast user's own searches fade,
+	// it is the same as the one of search_trends view
+	historyHalfLife = 3 * 24 * time.Hour
+	// historyWeight lifts user's own searches above
+	// the ones, that are just popular
+	historyWeight = 5
+)
+
+// Queries containing blocked terms are never suggested
+const notBlocked = `NOT EXISTS (SELECT 1 FROM search_blocked_terms b WHERE position(b.term IN s.query) > 0)`
+
+const trendingSql = `SELECT s.query, sum(s.score) AS score FROM search_trends s
+	WHERE (? = '' OR s.sex = ?) AND s.users >= ? AND ` + notBlocked + `
+	GROUP BY s.query ORDER BY score DESC, s.query LIMIT ?`
+
+const autofillSql = `SELECT s.query, sum(s.score) AS score FROM (
+	    SELECT query, score FROM search_trends
+	    WHERE (? = '' OR sex = ?) AND users >= ? AND query LIKE ?
+	    UNION ALL
+	    SELECT query, ? * sum(exp(ln(0.5) * extract(epoch FROM now() - created_at) / ?)) AS score
+	    FROM search_records
+	    WHERE user_id = ? AND visible AND deleted_at IS NULL AND query LIKE ?
+	        AND created_at > now() - interval '90 day'
+	    GROUP BY query
+	) s
+	WHERE ` + notBlocked + `
+	GROUP BY s.query ORDER BY score DESC, s.query LIMIT ?`
+
+type Suggestion struct {
+	Query string  `json:"query"`
+	Score float64 `json:"score"`
+}
+
+// Trending returns queries recently popular among users of the sex
+func Trending(sex string, limit int) ([]*Suggestion, error) {
+	suggestions := []*Suggestion{}
+	err := database.DB().Raw(trendingSql, sex, sex, MinTrendingUsers, limit).Scan(&suggestions).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting trending queries: %v", err)
+	}
+	return suggestions, nil
+}
+
+// Autofill returns queries starting with the prefix. User's own
+// history is blended with trending queries and weighs more
+func Autofill(userID uint, sex string, prefix string, limit int) ([]*Suggestion, error) {
+	like := EscapeLike(strings.ToLower(prefix)) + "%"
+
+	suggestions := []*Suggestion{}
+	err := database.DB().Raw(autofillSql,
+		sex, sex, MinTrendingUsers, like,
+		historyWeight, historyHalfLife.Seconds(), userID, like,
+		limit,
+	).Scan(&suggestions).Error
+	if err != nil {
+		return nil, fmt.Errorf("error getting autofill queries: %v", err)
+	}
+	return suggestions, nil
+}
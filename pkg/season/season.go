@@ -0,0 +1,179 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package season
+
+import (
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	Winter = "winter"
+	Spring = "spring"
+	Summer = "summer"
+	Autumn = "autumn"
+
+	// Demi is used for looks suitable for both spring and autumn
+	Demi = "demi"
+	// All is used for looks suitable for any season
+	All = "all"
+)
+
+const (
+	HemisphereNorth = "north"
+	HemisphereSouth = "south"
+)
+
+var northern = map[time.Month]string{
+	time.December: Winter, time.January: Winter, time.February: Winter,
+	time.March: Spring, time.April: Spring, time.May: Spring,
+	time.June: Summer, time.July: Summer, time.August: Summer,
+	time.September: Autumn, time.October: Autumn, time.November: Autumn,
+}
+
+var opposite = map[string]string{
+	Winter: Summer,
+	Summer: Winter,
+	Spring: Autumn,
+	Autumn: Spring,
+}
+
+// Current returns meteorological season at the given time
+func Current(t time.Time, hemisphere string) string {
+	s := northern[t.Month()]
+	if hemisphere == HemisphereSouth {
+		return opposite[s]
+	}
+	return s
+}
+
+// ForUser returns current season at user's location, using
+// user's time zone for the date. Northern hemisphere is
+// assumed when user didn't tell us otherwise
+func ForUser(user *models.User) string {
+	return Current(time.Now().In(user.Location()), user.Hemisphere)
+}
+
+// synonyms are values of look's season, that content
+// editors use besides the canonical ones
+var synonyms = map[string]string{
+	"зима":         Winter,
+	"зимний":       Winter,
+	"весна":        Spring,
+	"весенний":     Spring,
+	"лето":         Summer,
+	"летний":       Summer,
+	"осень":        Autumn,
+	"осенний":      Autumn,
+	"fall":         Autumn,
+	"демисезон":    Demi,
+	"демисезонный": Demi,
+	"межсезонье":   Demi,
+	"всесезон":     All,
+	"всесезонный":  All,
+	"круглый год":  All,
+	"any":          All,
+}
+
+// Normalize returns canonical season of look's season value,
+// values that are not known seasons are returned lowercased
+func Normalize(value string) string {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if s, ok := synonyms[value]; ok {
+		return s
+	}
+	return value
+}
+
+// known are seasons, that look's season is compared with
+var known = []string{Winter, Spring, Summer, Autumn, Demi, All}
+
+func suitable(look string, s string) bool {
+	switch look {
+	case s, All, "":
+		return true
+	case Demi:
+		return s == Spring || s == Autumn
+	}
+	return false
+}
+
+// Matching returns values of look's season, including
+// synonyms, that are suitable for the given season
+func Matching(s string) []string {
+	return values(func(v string) bool {
+		return suitable(v, s)
+	})
+}
+
+// mismatching returns values of look's season,
+// including synonyms, that are known to be not
+// suitable for the given season
+func mismatching(s string) []string {
+	return values(func(v string) bool {
+		return !suitable(v, s)
+	})
+}
+
+func values(keep func(s string) bool) []string {
+	var result []string
+	if keep("") {
+		result = append(result, "")
+	}
+	for _, v := range known {
+		if keep(v) {
+			result = append(result, v)
+		}
+	}
+	var matched []string
+	for synonym, v := range synonyms {
+		if keep(v) {
+			matched = append(matched, synonym)
+		}
+	}
+	sort.Strings(matched)
+	return append(result, matched...)
+}
+
+// Filter returns sql condition for looks, that can be worn
+// in the given season. Only looks made for other known
+// seasons are left out, unknown values are kept
+func Filter(s string) (string, []interface{}) {
+	return "(looks.season IS NULL OR lower(trim(looks.season)) NOT IN ?)", []interface{}{mismatching(s)}
+}
+
+// MadeFor returns sql condition for looks made
+// for the given season itself, by any of its names
+func MadeFor(s string) (string, []interface{}) {
+	made := values(func(v string) bool {
+		return v == s
+	})
+	return "lower(trim(looks.season)) IN ?", []interface{}{made}
+}
+
+// Matches reports whether look is suitable for the given season
+func Matches(look *models.Look, s string) bool {
+	ls := Normalize(look.Season)
+	for _, v := range known {
+		if ls == v {
+			return suitable(ls, s)
+		}
+	}
+	return true
+}
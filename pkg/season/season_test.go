@@ -0,0 +1,60 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package season
+
+import (
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"testing"
+)
+
+func TestMadeFor(t *testing.T) {
+	_, args := MadeFor(Winter)
+	made := make(map[string]bool)
+	for _, v := range args[0].([]string) {
+		made[v] = true
+	}
+	for _, v := range []string{"winter", "зима", "зимний"} {
+		if !made[v] {
+			t.Errorf("%q is not made for winter", v)
+		}
+	}
+	for _, v := range []string{"", "all", "demi", "лето"} {
+		if made[v] {
+			t.Errorf("%q is made for winter", v)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	for _, c := range []struct {
+		look  string
+		s     string
+		match bool
+	}{
+		{" Зима ", Winter, true},
+		{"fall", Autumn, true},
+		{"демисезон", Spring, true},
+		{"демисезон", Summer, false},
+		{"лето", Winter, false},
+		{"", Winter, true},
+		{"дождь", Winter, true},
+	} {
+		if got := Matches(&models.Look{Season: c.look}, c.s); got != c.match {
+			t.Errorf("%q in %v: got %v, want %v", c.look, c.s, got, c.match)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package today
+
+import (
+	"errors"
+	"fmt"
+	"github.com/parasource/papaya-api/pkg/database"
+	"github.com/parasource/papaya-api/pkg/database/models"
+	"github.com/parasource/papaya-api/pkg/gorse"
+	"github.com/parasource/papaya-api/pkg/mood"
+	"github.com/parasource/papaya-api/pkg/season"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+	"strconv"
+	"time"
+)
+
+var instance *Rotator
+
+// errNothingToShow is returned when there is no look
+// that could become the look of the day
+var errNothingToShow = errors.New("nothing to show")
+
+const (
+	dateLayout = "2006-01-02"
+	batchSize  = 200
+)
+
+var sexes = []string{"male", "female"}
+
+// Users, that don't have a look of the day for the
+// current date in their own time zone yet. Users after
+// the given id go in batches, so that ones we couldn't
+// rotate are not fetched again. Time zones postgres doesn't
+// know are replaced with the default one, as they would
+// fail the whole query
+const dueUsersSql = `WITH zones AS (SELECT name FROM pg_timezone_names)
+SELECT users.* FROM users
+	LEFT JOIN zones ON zones.name = users.timezone
+	WHERE users.deleted_at IS NULL AND users.id > ?
+	  AND NOT EXISTS (
+	      SELECT 1 FROM today_look_histories h
+	      WHERE h.user_id = users.id AND h.sex = users.sex AND h.deleted_at IS NULL
+	        AND h.date = (now() AT TIME ZONE coalesce(zones.name, ?))::date
+	  )
+	ORDER BY users.id LIMIT ?`
+
+const todayWardrobeLooksSql = `
+select looks.id from looks
+	 join look_items li on looks.id = li.look_id
+	 join users_wardrobe uw on li.wardrobe_item_id = uw.wardrobe_item_id
+	WHERE uw.user_id = ?
+	  AND looks.sex = ?
+	  AND looks.deleted_at IS NULL
+	  AND looks.id NOT IN ?
+	  AND %[1]s
+	GROUP BY looks.id ORDER BY (%[2]s) DESC NULLS LAST, random() LIMIT 20`
+
+const todayRecentLooksSql = `SELECT look_id FROM today_look_histories
+	WHERE user_id = ? AND sex = ? AND deleted_at IS NULL AND date > ?::date - ?::int
+	UNION SELECT look_id FROM disliked_looks WHERE user_id = ?`
+
+const todayInsertHistorySql = `INSERT INTO today_look_histories (created_at, updated_at, user_id, sex, date, look_id)
+	VALUES (now(), now(), ?, ?, ?::date, ?)
+	ON CONFLICT (user_id, sex, date) DO NOTHING`
+
+type Config struct {
+	// Interval is how often we look for users,
+	// whose day has changed
+	Interval time.Duration
+	// RepeatWindow is the number of days a look
+	// can't be the look of the day again
+	RepeatWindow int
+}
+
+// Rotator picks a new look of the day for every
+// user, once a day in user's time zone
+type Rotator struct {
+	cfg  Config
+	stop chan struct{}
+}
+
+func New(cfg Config) *Rotator {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Minute
+	}
+	if cfg.RepeatWindow <= 0 {
+		cfg.RepeatWindow = 30
+	}
+
+	instance = &Rotator{
+		cfg:  cfg,
+		stop: make(chan struct{}),
+	}
+	return instance
+}
+
+func Get() *Rotator {
+	if instance == nil {
+		New(Config{})
+	}
+	return instance
+}
+
+// Run rotates looks of the day until Stop is called
+func (r *Rotator) Run() {
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+
+	for {
+		r.rotateDue()
+
+		select {
+		case <-ticker.C:
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+func (r *Rotator) Stop() {
+	close(r.stop)
+}
+
+func (r *Rotator) rotateDue() {
+	var lastID uint
+	for {
+		var users []*models.User
+		err := database.DB().Raw(dueUsersSql, lastID, models.DefaultTimezone, batchSize).Scan(&users).Error
+		if err != nil {
+			logrus.Errorf("error getting users to rotate today look: %v", err)
+			return
+		}
+
+		rotated, failed := 0, 0
+		for _, user := range users {
+			lastID = user.ID
+
+			err = r.rotateUser(user)
+			if errors.Is(err, errNothingToShow) {
+				continue
+			}
+			if err != nil {
+				logrus.Errorf("error rotating today look for user %v: %v", user.ID, err)
+				failed++
+				continue
+			}
+			rotated++
+		}
+		if len(users) > 0 {
+			logrus.Debugf("rotated today looks for %v of %v users", rotated, len(users))
+		}
+
+		// If every user failed, database is likely down,
+		// so we wait for the next tick instead
+		if len(users) < batchSize || failed == len(users) {
+			return
+		}
+	}
+}
+
+// Rotate sets looks of the day for the current date in
+// user's time zone, if they are not set yet
+func (r *Rotator) Rotate(user *models.User) error {
+	err := r.rotateUser(user)
+	if errors.Is(err, errNothingToShow) {
+		return nil
+	}
+	return err
+}
+
+// rotateUser returns errNothingToShow if there
+// is no look for user's own sex
+func (r *Rotator) rotateUser(user *models.User) error {
+	date := LocalDate(user)
+	for _, sex := range sexes {
+		err := r.rotate(user, sex, date)
+		if errors.Is(err, errNothingToShow) && sex != user.Sex {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// TodayLook returns user's look of the day, rotating
+// it first if the scheduler didn't get to user yet.
+// Look is nil if there are no looks to choose from
+func (r *Rotator) TodayLook(user *models.User) (*models.Look, string, error) {
+	date := LocalDate(user)
+
+	err := r.rotate(user, user.Sex, date)
+	if errors.Is(err, errNothingToShow) {
+		return nil, date, nil
+	}
+	if err != nil {
+		return nil, date, err
+	}
+
+	var history models.TodayLookHistory
+	err = database.DB().Preload("Look.Items.Urls.Brand").Preload("Look.Items.WardrobeCategory").
+		Where("user_id = ? AND sex = ? AND date = ?::date", user.ID, user.Sex, date).
+		First(&history).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, date, nil
+	}
+	if err != nil {
+		return nil, date, err
+	}
+	return history.Look, date, nil
+}
+
+func (r *Rotator) rotate(user *models.User, sex string, date string) error {
+	var exists bool
+	err := database.DB().Raw("SELECT exists(SELECT 1 FROM today_look_histories WHERE user_id = ? AND sex = ? AND date = ?::date AND deleted_at IS NULL)", user.ID, sex, date).
+		Scan(&exists).Error
+	if err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	lookID, err := r.pick(user, sex, date)
+	if err != nil {
+		return err
+	}
+	if lookID == 0 {
+		return errNothingToShow
+	}
+
+	return database.DB().Transaction(func(tx *gorm.DB) error {
+		// Another instance might have rotated it meanwhile,
+		// then we just keep theirs
+		res := tx.Exec(todayInsertHistorySql, user.ID, sex, date, lookID)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return nil
+		}
+
+		// today_looks is still read by v1 clients
+		err := tx.Exec("DELETE FROM today_looks WHERE user_id = ? AND sex = ?", user.ID, sex).Error
+		if err != nil {
+			return err
+		}
+		return tx.Exec("INSERT INTO today_looks (user_id, look_id, sex) VALUES (?, ?, ?) ON CONFLICT DO NOTHING", user.ID, lookID, sex).Error
+	})
+}
+
+// pick prefers looks made of user's wardrobe that gorse also
+// recommends, then any wardrobe look, then gorse recommendations,
+// and finally any look, never repeating recent ones. If user
+// has a mood, looks matching it go before everything else
+func (r *Rotator) pick(user *models.User, sex string, date string) (uint, error) {
+	var recent []uint
+	err := database.DB().Raw(todayRecentLooksSql, user.ID, sex, date, r.cfg.RepeatWindow, user.ID).Scan(&recent).Error
+	if err != nil {
+		return 0, fmt.Errorf("error getting recent today looks: %v", err)
+	}
+	// Empty NOT IN doesn't work in postgres
+	recent = append(recent, 0)
+
+	// Looks for other seasons are never picked,
+	// looks made exactly for this one go first
+	current := season.ForUser(user)
+	seasonFilter, seasonArgs := season.Filter(current)
+	madeFor, madeForArgs := season.MadeFor(current)
+
+	args := append([]interface{}{user.ID, sex, recent}, seasonArgs...)
+	args = append(args, madeForArgs...)
+
+	var wardrobe []uint
+	err = database.DB().Raw(fmt.Sprintf(todayWardrobeLooksSql, seasonFilter, madeFor), args...).Scan(&wardrobe).Error
+	if err != nil {
+		return 0, fmt.Errorf("error getting wardrobe looks: %v", err)
+	}
+
+	var recommended []uint
+	slugs, err := gorse.RecommendForUserAndCategory(strconv.Itoa(int(user.ID)), sex, 20, 0)
+	if err != nil {
+		logrus.Warnf("error getting today look recommendations from gorse: %v", err)
+	} else if len(slugs) > 0 {
+		err = database.DB().Model(&models.Look{}).Where("slug in ?", slugs).Where("id NOT IN ?", recent).
+			Where(seasonFilter, seasonArgs...).Pluck("id", &recommended).Error
+		if err != nil {
+			return 0, fmt.Errorf("error getting recommended looks: %v", err)
+		}
+	}
+
+	inRecommended := make(map[uint]bool, len(recommended))
+	for _, id := range recommended {
+		inRecommended[id] = true
+	}
+	var preferred []uint
+	for _, id := range wardrobe {
+		if inRecommended[id] {
+			preferred = append(preferred, id)
+		}
+	}
+	candidates := append(append(preferred, wardrobe...), recommended...)
+
+	// Looks matching user's mood win over the rest,
+	// the order above is kept within both groups
+	if m := mood.Get(user.Mood); m != nil && len(candidates) > 0 {
+		matching, err := m.MatchingLooks(candidates)
+		if err != nil {
+			logrus.Warnf("error matching today looks with mood: %v", err)
+		}
+		for _, id := range candidates {
+			if matching[id] {
+				return id, nil
+			}
+		}
+	}
+	if len(candidates) > 0 {
+		return candidates[0], nil
+	}
+
+	var fallback uint
+	args = append([]interface{}{sex, recent}, seasonArgs...)
+	args = append(args, madeForArgs...)
+	err = database.DB().Raw("SELECT id FROM looks WHERE sex = ? AND deleted_at IS NULL AND id NOT IN ? AND "+seasonFilter+
+		" ORDER BY ("+madeFor+") DESC NULLS LAST, random() LIMIT 1", args...).
+		Scan(&fallback).Error
+	return fallback, err
+}
+
+// LocalDate returns current date in user's time zone
+func LocalDate(user *models.User) string {
+	return time.Now().In(user.Location()).Format(dateLayout)
+}
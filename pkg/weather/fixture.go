@@ -0,0 +1,68 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// FixtureProvider returns weather from a json file, it's
+// meant for development and tests. The file maps rounded
+// "lat,lon" keys to conditions, "default" is used for
+// every other location
+//
+//	{
+//	  "55.8,37.6": {"temperature": -5, "precipitation": 0.4},
+//	  "default": {"temperature": 18, "precipitation": 0}
+//	}
+type FixtureProvider struct {
+	fixtures map[string]*Conditions
+}
+
+func NewFixtureProvider(path string) (*FixtureProvider, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading weather fixture: %v", err)
+	}
+
+	var fixtures map[string]*Conditions
+	err = json.Unmarshal(data, &fixtures)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing weather fixture: %v", err)
+	}
+
+	return NewFakeProvider(fixtures), nil
+}
+
+func NewFakeProvider(fixtures map[string]*Conditions) *FixtureProvider {
+	return &FixtureProvider{
+		fixtures: fixtures,
+	}
+}
+
+func (p *FixtureProvider) Current(ctx context.Context, lat, lon float64) (*Conditions, error) {
+	if c, ok := p.fixtures[locationKey(lat, lon)]; ok {
+		return c, nil
+	}
+	if c, ok := p.fixtures["default"]; ok {
+		return c, nil
+	}
+	return nil, fmt.Errorf("no weather fixture for %v", locationKey(lat, lon))
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package weather
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultHTTPAddress = "https://api.open-meteo.com"
+
+	// Weather doesn't change that fast, so we keep
+	// conditions per location for a while
+	httpCacheTTL = 30 * time.Minute
+)
+
+// HTTPProvider gets weather from an open-meteo compatible api
+type HTTPProvider struct {
+	c       *http.Client
+	baseUrl string
+
+	mu        sync.Mutex
+	cache     map[string]cachedConditions
+	evictedAt time.Time
+}
+
+type cachedConditions struct {
+	conditions *Conditions
+	expiresAt  time.Time
+}
+
+func NewHTTPProvider(address string) *HTTPProvider {
+	if address == "" {
+		address = DefaultHTTPAddress
+	}
+	return &HTTPProvider{
+		c: &http.Client{
+			Timeout: 2 * time.Second,
+		},
+		baseUrl: address,
+		cache:   make(map[string]cachedConditions),
+	}
+}
+
+func (p *HTTPProvider) Current(ctx context.Context, lat, lon float64) (*Conditions, error) {
+	key := locationKey(lat, lon)
+
+	p.mu.Lock()
+	cached, ok := p.cache[key]
+	p.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.conditions, nil
+	}
+
+	url := fmt.Sprintf("%v/v1/forecast?latitude=%.2f&longitude=%.2f&current=temperature_2m,precipitation", p.baseUrl, lat, lon)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := p.c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("wrong status code - %v", res.StatusCode)
+	}
+
+	var body struct {
+		Current struct {
+			Temperature   float64 `json:"temperature_2m"`
+			Precipitation float64 `json:"precipitation"`
+		} `json:"current"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&body)
+	if err != nil {
+		return nil, err
+	}
+
+	conditions := &Conditions{
+		Temperature:   body.Current.Temperature,
+		Precipitation: body.Current.Precipitation,
+	}
+
+	p.mu.Lock()
+	p.evict()
+	p.cache[key] = cachedConditions{
+		conditions: conditions,
+		expiresAt:  time.Now().Add(httpCacheTTL),
+	}
+	p.mu.Unlock()
+
+	return conditions, nil
+}
+
+// evict removes expired conditions, so that locations users
+// have left don't pile up. It runs once per ttl at most and
+// must be called with mu held
+func (p *HTTPProvider) evict() {
+	now := time.Now()
+	if now.Sub(p.evictedAt) < httpCacheTTL {
+		return
+	}
+	p.evictedAt = now
+
+	for key, cached := range p.cache {
+		if now.After(cached.expiresAt) {
+			delete(p.cache, key)
+		}
+	}
+}
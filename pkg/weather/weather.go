@@ -0,0 +1,100 @@
+/*
+ * Copyright 2023 Parasource Organization
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *    http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package weather
+
+import (
+	"context"
+	"fmt"
+	"math"
+)
+
+var instance Provider
+
+const (
+	ProviderHTTP    = "http"
+	ProviderFixture = "fixture"
+
+	// ColdTemperature is the temperature in celsius
+	// below which we start suggesting outerwear
+	ColdTemperature = 10.0
+)
+
+// Conditions are current weather conditions at some place
+type Conditions struct {
+	// Temperature in celsius
+	Temperature float64 `json:"temperature"`
+	// Precipitation in millimeters for the last hour
+	Precipitation float64 `json:"precipitation"`
+}
+
+func (c *Conditions) Cold() bool {
+	return c.Temperature < ColdTemperature
+}
+
+func (c *Conditions) Wet() bool {
+	return c.Precipitation > 0
+}
+
+// Provider returns current weather at the given coordinates
+type Provider interface {
+	Current(ctx context.Context, lat, lon float64) (*Conditions, error)
+}
+
+type Config struct {
+	// Provider is either http or fixture,
+	// leave it empty to disable weather
+	Provider string
+	// Address is the base url of http provider
+	Address string
+	// Fixture is the path to fixture provider's file
+	Fixture string
+}
+
+func New(cfg Config) (Provider, error) {
+	var (
+		p   Provider
+		err error
+	)
+	switch cfg.Provider {
+	case "":
+		p = nil
+	case ProviderHTTP:
+		p = NewHTTPProvider(cfg.Address)
+	case ProviderFixture:
+		p, err = NewFixtureProvider(cfg.Fixture)
+	default:
+		err = fmt.Errorf("unknown weather provider: %v", cfg.Provider)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	instance = p
+	return p, nil
+}
+
+// Get returns configured provider, or nil
+// if weather is disabled
+func Get() Provider {
+	return instance
+}
+
+// locationKey rounds coordinates to ~10km, which is more
+// than enough for weather, and makes it cacheable
+func locationKey(lat, lon float64) string {
+	return fmt.Sprintf("%.1f,%.1f", math.Round(lat*10)/10, math.Round(lon*10)/10)
+}